@@ -0,0 +1,538 @@
+// Package client is a Go client for the rest-weasyprint PDF rendering service.
+//
+// Renders stream the PDF to an io.Writer, share variants return the link created
+// by a sharing service instead. Requests rejected with 429 or 503 are retried with
+// exponential backoff, honouring Retry-After. Uploaded files are streamed, their requests are retried
+// only when every file can seek back to be sent again.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retry defaults
+const (
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+)
+
+// Client calls the rendering service, it is safe for concurrent use
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	apiKey     string
+	tenant     string
+	maxRetries int
+	retryDelay time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithAPIKey authenticates requests with an API key
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithTenant sets the tenant of requests to services without API keys
+func WithTenant(tenant string) Option {
+	return func(c *Client) { c.tenant = tenant }
+}
+
+// WithRetry sets how often requests rejected with 429 or 503 are retried and the initial backoff delay.
+// Zero maxRetries disables retries
+func WithRetry(maxRetries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryDelay = delay
+	}
+}
+
+// New creates a client for the service at baseURL, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		maxRetries: DefaultMaxRetries,
+		retryDelay: DefaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// RenderHTML renders an HTML document and streams the PDF to w
+func (c *Client) RenderHTML(ctx context.Context, html string, w io.Writer, opts *RenderOptions) error {
+	req, err := c.htmlRequest(html, opts, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return c.render(ctx, req, w)
+}
+
+// RenderURL renders a remote page and streams the PDF to w
+func (c *Client) RenderURL(ctx context.Context, pageURL string, w io.Writer, opts *RenderOptions) error {
+	if err := checkPageURL(pageURL); err != nil {
+		return err
+	}
+	return c.RenderHTML(ctx, pageURL, w, opts)
+}
+
+// RenderFiles renders uploaded files and streams the PDF to w
+func (c *Client) RenderFiles(ctx context.Context, files *Files, w io.Writer, opts *RenderOptions) error {
+	req, err := c.filesRequest(files, opts, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return c.render(ctx, req, w)
+}
+
+// ShareHTML renders an HTML document and uploads the PDF to a sharing service
+func (c *Client) ShareHTML(ctx context.Context, html string, share ShareOptions, opts *RenderOptions) (*ShareResponse, error) {
+	req, err := c.htmlRequest(html, opts, &share, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.share(ctx, req)
+}
+
+// ShareURL renders a remote page and uploads the PDF to a sharing service
+func (c *Client) ShareURL(ctx context.Context, pageURL string, share ShareOptions, opts *RenderOptions) (*ShareResponse, error) {
+	if err := checkPageURL(pageURL); err != nil {
+		return nil, err
+	}
+	return c.ShareHTML(ctx, pageURL, share, opts)
+}
+
+// ShareFiles renders uploaded files and uploads the PDF to a sharing service
+func (c *Client) ShareFiles(ctx context.Context, files *Files, share ShareOptions, opts *RenderOptions) (*ShareResponse, error) {
+	req, err := c.filesRequest(files, opts, &share, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.share(ctx, req)
+}
+
+// RenderHTMLToOutput renders an HTML document and has the service upload the PDF to output
+func (c *Client) RenderHTMLToOutput(ctx context.Context, html string, output Output, opts *RenderOptions) (*OutputResult, error) {
+	req, err := c.htmlRequest(html, opts, nil, &output, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.output(ctx, req)
+}
+
+// RenderURLToOutput renders a remote page and has the service upload the PDF to output
+func (c *Client) RenderURLToOutput(ctx context.Context, pageURL string, output Output, opts *RenderOptions) (*OutputResult, error) {
+	if err := checkPageURL(pageURL); err != nil {
+		return nil, err
+	}
+	return c.RenderHTMLToOutput(ctx, pageURL, output, opts)
+}
+
+// RenderFilesToOutput renders uploaded files and has the service upload the PDF to output
+func (c *Client) RenderFilesToOutput(ctx context.Context, files *Files, output Output, opts *RenderOptions) (*OutputResult, error) {
+	req, err := c.filesRequest(files, opts, nil, &output, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.output(ctx, req)
+}
+
+// RenderHTMLToOutputs renders an HTML document once and has the service deliver the PDF to all outputs.
+// The PDF is written to w when outputs has a ResponseDestination. Failed destinations are reported
+// in the result rather than as an error
+func (c *Client) RenderHTMLToOutputs(ctx context.Context, html string, outputs []Destination, w io.Writer, opts *RenderOptions) (*OutputsResult, error) {
+	req, err := c.htmlRequest(html, opts, nil, nil, outputs)
+	if err != nil {
+		return nil, err
+	}
+	return c.outputs(ctx, req, w)
+}
+
+// RenderURLToOutputs renders a remote page once and has the service deliver the PDF to all outputs
+func (c *Client) RenderURLToOutputs(ctx context.Context, pageURL string, outputs []Destination, w io.Writer, opts *RenderOptions) (*OutputsResult, error) {
+	if err := checkPageURL(pageURL); err != nil {
+		return nil, err
+	}
+	return c.RenderHTMLToOutputs(ctx, pageURL, outputs, w, opts)
+}
+
+// RenderFilesToOutputs renders uploaded files once and has the service deliver the PDF to all outputs
+func (c *Client) RenderFilesToOutputs(ctx context.Context, files *Files, outputs []Destination, w io.Writer, opts *RenderOptions) (*OutputsResult, error) {
+	req, err := c.filesRequest(files, opts, nil, nil, outputs)
+	if err != nil {
+		return nil, err
+	}
+	return c.outputs(ctx, req, w)
+}
+
+// ShareProviders lists the share providers configured on the service
+func (c *Client) ShareProviders(ctx context.Context) ([]ShareProvider, error) {
+	resp, err := c.do(ctx, &request{method: http.MethodGet, path: "/api/v1/share/providers"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var providers struct {
+		Providers []ShareProvider `json:"providers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&providers); err != nil {
+		return nil, fmt.Errorf("failed to decode share providers: %v", err)
+	}
+	return providers.Providers, nil
+}
+
+// Version returns the service and WeasyPrint version information
+func (c *Client) Version(ctx context.Context) (map[string]string, error) {
+	resp, err := c.do(ctx, &request{method: http.MethodGet, path: "/api/v1/pdf/version"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var version map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return nil, fmt.Errorf("failed to decode version response: %v", err)
+	}
+	return version, nil
+}
+
+// request is an API request. A buffered body is replayed on retries, a streamed body is written
+// again for each attempt unless it can be read once only
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	contentType string
+	body        []byte
+	stream      func(w io.Writer) error // Writes the streamed body, replacing body
+	once        bool                    // stream can be written once only, the request is not retried
+}
+
+// htmlRequest builds a JSON render request
+func (c *Client) htmlRequest(html string, opts *RenderOptions, share *ShareOptions, output *Output, outputs []Destination) (*request, error) {
+	payload := struct {
+		HTML           string             `json:"html"`
+		Options        *WeasyPrintOptions `json:"options,omitempty"`
+		ShareService   string             `json:"share_service,omitempty"`
+		ShareFallbacks []string           `json:"share_fallbacks,omitempty"`
+		Output         *Output            `json:"output,omitempty"`
+		Email          *Email             `json:"email,omitempty"`
+		ShareOptions   *LinkOptions       `json:"share_options,omitempty"`
+		EncryptShare   bool               `json:"encrypt_share,omitempty"`
+		Outputs        []Destination      `json:"outputs,omitempty"`
+	}{HTML: html, Options: opts.weasyPrintOptions(), Output: output, Outputs: outputs}
+	if share != nil {
+		payload.ShareService = share.Service
+		payload.ShareFallbacks = share.Fallbacks
+		payload.Email = share.Email
+		payload.ShareOptions = share.Link
+		payload.EncryptShare = share.Encrypt
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+	return &request{
+		method:      http.MethodPost,
+		path:        "/api/v1/pdf/render/html",
+		query:       opts.query(),
+		contentType: "application/json",
+		body:        body,
+	}, nil
+}
+
+// filesRequest builds a multipart render request streaming the files
+func (c *Client) filesRequest(files *Files, opts *RenderOptions, share *ShareOptions, output *Output, outputs []Destination) (*request, error) {
+	if files == nil || files.HTML == nil {
+		return nil, fmt.Errorf("HTML file is required")
+	}
+	if err := files.check(); err != nil {
+		return nil, err
+	}
+
+	// JSON fields are encoded upfront so encoding errors are reported before sending
+	type jsonField struct {
+		name, description string
+		value             interface{}
+	}
+	var jsonFields []jsonField
+	if options := opts.weasyPrintOptions(); options != nil {
+		jsonFields = append(jsonFields, jsonField{"options", "options", options})
+	}
+	if output != nil {
+		jsonFields = append(jsonFields, jsonField{"output", "output", output})
+	}
+	if share != nil && share.Email != nil {
+		jsonFields = append(jsonFields, jsonField{"email", "email", share.Email})
+	}
+	if share != nil && share.Link != nil {
+		jsonFields = append(jsonFields, jsonField{"share_options", "share options", share.Link})
+	}
+	if outputs != nil {
+		jsonFields = append(jsonFields, jsonField{"outputs", "outputs", outputs})
+	}
+	fields := make([][2]string, len(jsonFields))
+	for i, field := range jsonFields {
+		data, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %v", field.description, err)
+		}
+		fields[i] = [2]string{field.name, string(data)}
+	}
+
+	// Every attempt writes the form with the same boundary after rewinding the files
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	rewind := files.rewinder()
+	var mu sync.Mutex // An abandoned attempt may still be writing
+	stream := func(w io.Writer) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if rewind != nil {
+			if err := rewind(); err != nil {
+				return err
+			}
+		}
+		form := multipart.NewWriter(w)
+		form.SetBoundary(boundary)
+		if err := files.write(form); err != nil {
+			return err
+		}
+		for _, field := range fields {
+			if err := form.WriteField(field[0], field[1]); err != nil {
+				return err
+			}
+		}
+		return form.Close()
+	}
+
+	query := opts.query()
+	if share != nil {
+		query.Set("share_service", share.Service)
+		if len(share.Fallbacks) > 0 {
+			query.Set("share_fallbacks", strings.Join(share.Fallbacks, ","))
+		}
+		if share.Encrypt {
+			query.Set("encrypt_share", "true")
+		}
+	}
+	return &request{
+		method:      http.MethodPost,
+		path:        "/api/v1/pdf/render/file",
+		query:       query,
+		contentType: "multipart/form-data; boundary=" + boundary,
+		stream:      stream,
+		once:        rewind == nil,
+	}, nil
+}
+
+// render performs a render request and streams the PDF to w
+func (c *Client) render(ctx context.Context, req *request, w io.Writer) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to read PDF: %v", err)
+	}
+	return nil
+}
+
+// share performs a share request and decodes the share response
+func (c *Client) share(ctx context.Context, req *request) (*ShareResponse, error) {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var share ShareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&share); err != nil {
+		return nil, fmt.Errorf("failed to decode share response: %v", err)
+	}
+	share.RenderID = resp.Header.Get("X-Render-ID")
+	return &share, nil
+}
+
+// output performs a render request with an output and decodes the upload result
+func (c *Client) output(ctx context.Context, req *request) (*OutputResult, error) {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result OutputResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode output result: %v", err)
+	}
+	result.RenderID = resp.Header.Get("X-Render-ID")
+	return &result, nil
+}
+
+// outputs performs a render request with outputs and decodes the summary. A multipart/mixed response
+// carries the summary followed by the PDF, which is copied to w
+func (c *Client) outputs(ctx context.Context, req *request, w io.Writer) (*OutputsResult, error) {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result OutputsResult
+	result.RenderID = resp.Header.Get("X-Render-ID")
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "multipart/mixed" {
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("failed to decode outputs result: %v", err)
+		}
+		return &result, nil
+	}
+
+	parts := multipart.NewReader(resp.Body, params["boundary"])
+	part, err := parts.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&result)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode outputs result: %v", err)
+	}
+	if part, err = parts.NextPart(); err != nil {
+		return &result, fmt.Errorf("failed to read PDF: %v", err)
+	}
+	if w == nil {
+		w = io.Discard
+	}
+	if _, err := io.Copy(w, part); err != nil {
+		return &result, fmt.Errorf("failed to read PDF: %v", err)
+	}
+	return &result, nil
+}
+
+// do sends req, retrying 429 and 503 responses unless its body can be sent once only. Non-2xx
+// responses are returned as *Error
+func (c *Client) do(ctx context.Context, req *request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		apiErr := newError(resp)
+		if !apiErr.Temporary() || attempt >= c.maxRetries || req.once {
+			return nil, apiErr
+		}
+
+		timer := time.NewTimer(c.backoff(attempt, apiErr.RetryAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends a single attempt of req
+func (c *Client) send(ctx context.Context, req *request) (*http.Response, error) {
+	u := c.baseURL.JoinPath(req.path)
+	u.RawQuery = req.query.Encode()
+
+	var body io.Reader = bytes.NewReader(req.body)
+	if req.stream != nil {
+		body = streamBody(req.stream)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if req.stream != nil && !req.once {
+		// Lets the transport resend the body, e.g. on 307 and 308 redirects
+		httpReq.GetBody = func() (io.ReadCloser, error) { return streamBody(req.stream), nil }
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if c.apiKey != "" {
+		httpReq.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.tenant != "" {
+		httpReq.Header.Set("X-Tenant-ID", c.tenant)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// streamBody returns a body written by stream as it is read, the transport closing the body ends stream
+func streamBody(stream func(w io.Writer) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(stream(pw)) }()
+	return pr
+}
+
+// backoff returns the delay before retry attempt+1, at least retryAfter
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	delay := min(c.retryDelay<<attempt, maxRetryDelay)
+	delay += rand.N(delay/2 + 1) // Jitter spreads retries of concurrent clients
+	return max(delay, retryAfter)
+}
+
+// checkPageURL verifies pageURL is an absolute http(s) URL, the service renders anything else as HTML
+func checkPageURL(pageURL string) error {
+	if !strings.HasPrefix(pageURL, "http://") && !strings.HasPrefix(pageURL, "https://") {
+		return fmt.Errorf("page URL must start with http:// or https://")
+	}
+	if _, err := url.Parse(pageURL); err != nil {
+		return fmt.Errorf("invalid page URL: %v", err)
+	}
+	return nil
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// statusServer answers requests with the statuses in order, the last one repeatedly
+type statusServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	forms    []map[string]string // Files and fields of each multipart request
+}
+
+func newStatusServer(t *testing.T, header http.Header, statuses ...int) *statusServer {
+	s := &statusServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if r.ContentLength != -1 {
+				t.Errorf("files buffered, content length %d", r.ContentLength)
+			}
+			form := make(map[string]string)
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("invalid form: %v", err)
+			} else {
+				for name, files := range r.MultipartForm.File {
+					f, _ := files[0].Open()
+					data, _ := io.ReadAll(f)
+					form[name] = files[0].Filename + ":" + string(data)
+				}
+				for name, values := range r.MultipartForm.Value {
+					form[name] = values[0]
+				}
+			}
+			s.forms = append(s.forms, form)
+		}
+
+		status := s.statuses[0]
+		if len(s.statuses) > 1 {
+			s.statuses = s.statuses[1:]
+		}
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "%PDF")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// calls returns the number of multipart requests received
+func (s *statusServer) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.forms)
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		calls    int
+		err      error
+	}{
+		{"rate limited then success", []int{429, 503, 200}, 3, nil},
+		{"retries exhausted", []int{503}, 4, ErrUnavailable},
+		{"server errors are not retried", []int{500, 200}, 1, ErrServer},
+		{"client errors are not retried", []int{400, 200}, 1, ErrBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStatusServer(t, nil, tt.statuses...)
+			c, _ := New(server.URL, WithRetry(3, time.Millisecond))
+			var out bytes.Buffer
+			files := &Files{HTML: strings.NewReader("<h1>Hi</h1>")}
+			err := c.RenderFiles(context.Background(), files, &out, nil)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Errorf("error %v, want %v", err, tt.err)
+			}
+			if server.calls() != tt.calls {
+				t.Errorf("%d requests, want %d", server.calls(), tt.calls)
+			}
+			if tt.err == nil && out.String() != "%PDF" {
+				t.Errorf("PDF %q", out.String())
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	server := newStatusServer(t, http.Header{"Retry-After": {"1"}}, 429, 200)
+	c, _ := New(server.URL, WithRetry(1, time.Millisecond))
+	start := time.Now()
+	if err := c.RenderFiles(context.Background(), &Files{HTML: strings.NewReader("<h1>Hi</h1>")}, io.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, before Retry-After", elapsed)
+	}
+
+	// Cancelling the context stops waiting
+	server = newStatusServer(t, http.Header{"Retry-After": {"60"}}, 503)
+	c, _ = New(server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.RenderFiles(ctx, &Files{HTML: strings.NewReader("<h1>Hi</h1>")}, io.Discard, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want the context deadline", err)
+	}
+
+	for value, want := range map[string]time.Duration{
+		"":      0,
+		"7":     7 * time.Second,
+		"-1":    0,
+		"later": 0,
+		time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat): 0,
+	} {
+		if got := parseRetryAfter(value); got != want {
+			t.Errorf("Retry-After %q: %v, want %v", value, got, want)
+		}
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 58*time.Second || got > time.Minute {
+		t.Errorf("Retry-After %q: %v", date, got)
+	}
+}
+
+func TestRenderFilesStreamsAndReplaysFiles(t *testing.T) {
+	html := strings.NewReader("skipped<h1>Hi</h1>")
+	html.Seek(int64(len("skipped")), io.SeekStart) // Files are sent from their current offset
+	logo := bytes.NewReader([]byte("png"))
+
+	server := newStatusServer(t, nil, 503, 503, 200)
+	c, _ := New(server.URL, WithRetry(3, time.Millisecond))
+	err := c.RenderFiles(context.Background(), &Files{
+		HTML:      html,
+		Resources: []File{{Name: "logo.png", Reader: logo}},
+	}, io.Discard, &RenderOptions{Options: &WeasyPrintOptions{DPI: 150}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.forms) != 3 {
+		t.Fatalf("%d requests, want 3", len(server.forms))
+	}
+	for i, form := range server.forms {
+		if form["html"] != "index.html:<h1>Hi</h1>" || form["asset.logo.png"] != "logo.png:png" || !strings.Contains(form["options"], `"dpi":150`) {
+			t.Errorf("attempt %d sent %q", i+1, form)
+		}
+	}
+
+	// Readers that cannot seek are sent once
+	server = newStatusServer(t, nil, 503, 200)
+	c, _ = New(server.URL, WithRetry(3, time.Millisecond))
+	err = c.RenderFiles(context.Background(), &Files{HTML: io.MultiReader(strings.NewReader("<h1>Hi</h1>"))}, io.Discard, nil)
+	if !errors.Is(err, ErrUnavailable) || server.calls() != 1 {
+		t.Errorf("error %v after %d requests, want 503 after 1", err, server.calls())
+	}
+}
+
+func TestRenderFilesFollowsRedirects(t *testing.T) {
+	server := newStatusServer(t, nil, 200)
+	redirect := httptest.NewServer(http.RedirectHandler(server.URL+"/api/v1/pdf/render/file", http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	c, _ := New(redirect.URL)
+	if err := c.RenderFiles(context.Background(), &Files{HTML: strings.NewReader("<h1>Hi</h1>")}, io.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.forms) != 1 || server.forms[0]["html"] != "index.html:<h1>Hi</h1>" {
+		t.Errorf("redirected requests %q", server.forms)
+	}
+}
+
+func TestRenderFilesRejectsIncompleteFiles(t *testing.T) {
+	c, _ := New("http://localhost")
+	for _, files := range []*Files{
+		nil,
+		{},
+		{HTML: strings.NewReader("<h1>Hi</h1>"), Stylesheets: []File{{Reader: strings.NewReader("body {}")}}},
+		{HTML: strings.NewReader("<h1>Hi</h1>"), Attachments: []File{{Name: "terms.pdf"}}},
+	} {
+		if err := c.RenderFiles(context.Background(), files, io.Discard, nil); err == nil || errors.As(err, new(*Error)) {
+			t.Errorf("files %+v: error %v, want a request error", files, err)
+		}
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        Error
+	}{
+		{"problem", "application/problem+json", `{"type": "about:blank", "title": "Unprocessable Entity", "status": 422, "code": "invalid_option",
+			"detail": "invalid options", "request_id": "req-1", "render_id": "render-1",
+			"errors": ["options.dpi: must be at most 600"], "diagnostics": ["WARNING: ignored"]}`, Error{
+			StatusCode:  422,
+			Code:        CodeInvalidOption,
+			Message:     "invalid options",
+			RequestID:   "req-1",
+			RenderID:    "render-1",
+			Errors:      []string{"options.dpi: must be at most 600"},
+			Diagnostics: []string{"WARNING: ignored"},
+		}},
+		{"plain text", "text/plain", "upstream down\n", Error{StatusCode: 422, Message: "upstream down", RenderID: "header-render"}},
+		{"empty", "text/plain", "", Error{StatusCode: 422, Message: "Unprocessable Entity", RenderID: "header-render"}},
+		{"invalid problem", "application/problem+json", "{", Error{StatusCode: 422, Message: "{", RenderID: "header-render"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("X-Render-ID", "header-render")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			c, _ := New(server.URL)
+			err := c.RenderHTML(context.Background(), "<h1>Hi</h1>", io.Discard, nil)
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || !errors.Is(err, ErrBadRequest) {
+				t.Fatalf("error %v, want a bad request *Error", err)
+			}
+			if apiErr.StatusCode != tt.want.StatusCode || apiErr.Code != tt.want.Code || apiErr.Message != tt.want.Message ||
+				apiErr.RequestID != tt.want.RequestID || apiErr.RenderID != tt.want.RenderID ||
+				!slices.Equal(apiErr.Errors, tt.want.Errors) || !slices.Equal(apiErr.Diagnostics, tt.want.Diagnostics) {
+				t.Errorf("error %+v, want %+v", *apiErr, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Encrypted shares are a header of the magic and a random nonce prefix followed by the PDF sealed
+// with AES-256-GCM in segments of EncryptedSegmentSize bytes. The nonce of a segment is the prefix,
+// its big-endian index and 1 for the final segment, 0 otherwise, and the header is authenticated
+// with every segment so truncated or reordered ciphertext fails to decrypt.
+const (
+	EncryptionAlgorithm  = "AES-256-GCM"
+	EncryptedSegmentSize = 64 << 10 // Plaintext bytes per segment
+	EncryptionKeySize    = 32
+
+	encryptionMagic  = "RWE1"
+	noncePrefixSize  = 7
+	encryptionHeader = len(encryptionMagic) + noncePrefixSize
+)
+
+// ErrDecrypt is returned for ciphertext that is not an encrypted share or not sealed with the key
+var ErrDecrypt = errors.New("wrong key or damaged share")
+
+// NewEncryptionKey returns a random share encryption key
+func NewEncryptionKey() ([]byte, error) {
+	key := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodeKey encodes key for links and commands, unpadded base64url
+func EncodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// DecodeKey decodes a key encoded by EncodeKey
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil || len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("invalid key, expected %d base64url encoded bytes", EncryptionKeySize)
+	}
+	return key, nil
+}
+
+// DecryptLink builds the link of the decrypt page at pageURL for the ciphertext at downloadURL.
+// The key and download URL are in the fragment, which browsers do not send to servers
+func DecryptLink(pageURL, downloadURL, filename string, key []byte) string {
+	fragment := url.Values{"u": {downloadURL}, "k": {EncodeKey(key)}}
+	if filename != "" {
+		fragment.Set("n", filename)
+	}
+	return pageURL + "#" + fragment.Encode()
+}
+
+// ParseDecryptLink returns the ciphertext URL, filename and key of a link built by DecryptLink
+func ParseDecryptLink(link string) (downloadURL, filename string, key []byte, err error) {
+	_, fragment, ok := strings.Cut(link, "#")
+	if !ok {
+		return "", "", nil, errors.New("decrypt link has no fragment")
+	}
+	values, err := url.ParseQuery(fragment)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid decrypt link: %v", err)
+	}
+	if values.Get("u") == "" {
+		return "", "", nil, errors.New("decrypt link has no download URL")
+	}
+	if key, err = DecodeKey(values.Get("k")); err != nil {
+		return "", "", nil, err
+	}
+	return values.Get("u"), values.Get("n"), key, nil
+}
+
+// newGCM creates the AEAD of key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("key must be %d bytes", EncryptionKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce returns the nonce of segment index
+func segmentNonce(header []byte, index uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, header[len(encryptionMagic):])
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encrypter seals the plaintext written to it segment by segment
+type encrypter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint32
+	closed bool
+}
+
+// NewEncrypter writes the header of an encrypted share to w and returns a writer encrypting to w.
+// Close must be called to seal the final segment, it does not close w
+func NewEncrypter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptionHeader)
+	copy(header, encryptionMagic)
+	if _, err := rand.Read(header[len(encryptionMagic):]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encrypter{w: w, aead: aead, header: header, buf: make([]byte, 0, EncryptedSegmentSize)}, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypter")
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full segment is sealed only once more plaintext follows, the final one may be full too
+		if len(e.buf) == EncryptedSegmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):EncryptedSegmentSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the final segment
+func (e *encrypter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+// seal writes the buffered segment
+func (e *encrypter) seal(final bool) error {
+	sealed := e.aead.Seal(nil, segmentNonce(e.header, e.index, final), e.buf, e.header)
+	e.buf = e.buf[:0]
+	e.index++
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Decrypt writes the plaintext of the encrypted share read from r to w. Segments are verified before
+// they are written, so on error w holds a verified prefix of the plaintext
+func Decrypt(w io.Writer, r io.Reader, key []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, encryptionHeader)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return ErrDecrypt
+	}
+
+	reader := bufio.NewReaderSize(r, EncryptedSegmentSize+aead.Overhead())
+	segment := make([]byte, EncryptedSegmentSize+aead.Overhead())
+	plaintext := make([]byte, 0, EncryptedSegmentSize)
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(reader, segment)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		final := err != nil
+		if !final {
+			_, peekErr := reader.Peek(1)
+			final = peekErr == io.EOF
+		}
+		plaintext, err = aead.Open(plaintext[:0], segmentNonce(header, index, final), segment[:n], header)
+		if err != nil {
+			return ErrDecrypt
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
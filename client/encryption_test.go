@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// encrypt returns plaintext encrypted with key, written in chunks of chunk bytes
+func encrypt(t *testing.T, plaintext, key []byte, chunk int) []byte {
+	t.Helper()
+	var ciphertext bytes.Buffer
+	w, err := NewEncrypter(&ciphertext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := plaintext; len(p) > 0; {
+		n := min(chunk, len(p))
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext.Bytes()
+}
+
+// sealedSegment is the ciphertext size of a full segment
+const sealedSegment = EncryptedSegmentSize + 16
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	key, err := NewEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, EncryptedSegmentSize - 1, EncryptedSegmentSize, EncryptedSegmentSize + 1, 3*EncryptedSegmentSize + 5} {
+		for _, chunk := range []int{1000, EncryptedSegmentSize, 1 << 20} {
+			plaintext := make([]byte, size)
+			rand.Read(plaintext)
+			ciphertext := encrypt(t, plaintext, key, chunk)
+
+			segments := max(1, (size+EncryptedSegmentSize-1)/EncryptedSegmentSize)
+			if want := encryptionHeader + size + segments*16; len(ciphertext) != want {
+				t.Errorf("size %d: ciphertext of %d bytes, want %d", size, len(ciphertext), want)
+			}
+			var decrypted bytes.Buffer
+			if err := Decrypt(&decrypted, bytes.NewReader(ciphertext), key); err != nil {
+				t.Fatalf("size %d chunk %d: %v", size, chunk, err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), plaintext) {
+				t.Errorf("size %d chunk %d: decrypted plaintext differs", size, chunk)
+			}
+		}
+	}
+}
+
+func TestDecryptRejectsTamperedCiphertext(t *testing.T) {
+	key, _ := NewEncryptionKey()
+	plaintext := make([]byte, 3*EncryptedSegmentSize+100)
+	rand.Read(plaintext)
+	ciphertext := encrypt(t, plaintext, key, len(plaintext))
+	segment := func(i int) []byte {
+		start := encryptionHeader + i*sealedSegment
+		return ciphertext[start:min(start+sealedSegment, len(ciphertext))]
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	header := ciphertext[:encryptionHeader]
+	otherKey, _ := NewEncryptionKey()
+	otherShare := encrypt(t, plaintext, key, len(plaintext))
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		key        []byte
+	}{
+		{"wrong key", ciphertext, otherKey},
+		{"truncated at a segment boundary", join(header, segment(0), segment(1), segment(2)), key},
+		{"truncated inside a segment", ciphertext[:len(ciphertext)-10], key},
+		{"header only", header, key},
+		{"reordered segments", join(header, segment(1), segment(0), segment(2), segment(3)), key},
+		{"duplicated segment", join(header, segment(0), segment(0), segment(1), segment(2), segment(3)), key},
+		{"appended data", join(ciphertext, []byte("x")), key},
+		{"segment of another share", join(header, otherShare[encryptionHeader:encryptionHeader+sealedSegment], segment(1), segment(2), segment(3)), key},
+		{"flipped bit", join(header, segment(0), segment(1), append([]byte{segment(2)[0] ^ 1}, segment(2)[1:]...), segment(3)), key},
+		{"not an encrypted share", []byte("%PDF-1.7 plain document"), key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decrypted bytes.Buffer
+			err := Decrypt(&decrypted, bytes.NewReader(tt.ciphertext), tt.key)
+			if !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("err = %v, want ErrDecrypt", err)
+			}
+			// Only verified segments are written
+			if !bytes.HasPrefix(plaintext, decrypted.Bytes()) {
+				t.Errorf("unverified plaintext was written")
+			}
+		})
+	}
+}
+
+func TestDecryptLinkRoundTrip(t *testing.T) {
+	key, _ := NewEncryptionKey()
+	link := DecryptLink("https://pdf.example.com/decrypt", "https://file.io/abc?x=1&y=2", "report 1.pdf", key)
+	downloadURL, filename, parsedKey, err := ParseDecryptLink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downloadURL != "https://file.io/abc?x=1&y=2" || filename != "report 1.pdf" || !bytes.Equal(parsedKey, key) {
+		t.Errorf("parsed %q %q %x", downloadURL, filename, parsedKey)
+	}
+
+	if _, err := DecodeKey(EncodeKey(key[:16])); err == nil {
+		t.Errorf("short key decoded")
+	}
+	if _, _, _, err := ParseDecryptLink("https://pdf.example.com/decrypt"); err == nil {
+		t.Errorf("link without fragment parsed")
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxErrorBody bounds how much of an error response is read
+const maxErrorBody = 64 << 10
+
+// Errors matched by errors.Is against *Error
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrUnavailable  = errors.New("service unavailable")
+	ErrServer       = errors.New("server error")
+)
+
+// Error codes of problem responses, see Error.Code
+const (
+	CodeInvalidJSON         = "invalid_json"          // Request body or a JSON form field is not valid JSON
+	CodeInvalidRequest      = "invalid_request"       // Request does not match the API
+	CodeInvalidOption       = "invalid_option"        // A WeasyPrint option is invalid
+	CodeMissingHTML         = "missing_html"          // No HTML to render
+	CodeInvalidUpload       = "invalid_upload"        // Multipart form could not be read
+	CodePayloadTooLarge     = "payload_too_large"     // Request body exceeds the upload limit
+	CodeURLNotAllowed       = "url_not_allowed"       // URL host is not allowed or an output URL resolves to a non-public address
+	CodeUnauthorized        = "unauthorized"          // Missing or invalid API or admin key
+	CodeRateLimited         = "rate_limited"          // Rate limit exceeded, see Retry-After
+	CodeRenderFailed        = "render_failed"         // WeasyPrint failed
+	CodeRenderTimeout       = "render_timeout"        // Render did not finish within the request timeout
+	CodeResourceFetchFailed = "resource_fetch_failed" // WeasyPrint could not fetch the document or a resource it references
+	CodeShareFailed         = "share_failed"          // Upload to every share provider failed
+	CodeShareUnavailable    = "share_unavailable"     // Share providers are unavailable, see Retry-After
+	CodeOutputFailed        = "output_failed"         // Upload to the output URL failed
+	CodeShareNotFound       = "share_not_found"       // Unknown share link
+	CodeShareExpired        = "share_expired"         // Share expired or reached its download limit
+	CodePasswordRequired    = "password_required"     // Share is password protected
+	CodeNotFound            = "not_found"             // Unknown route or disabled endpoint
+	CodeMethodNotAllowed    = "method_not_allowed"    // Route does not support the method
+	CodeInternalError       = "internal_error"        // Server side failure
+)
+
+// Error is a non-2xx response of the service
+type Error struct {
+	StatusCode  int
+	Code        string        // Stable error code of the problem response, empty for plain text responses
+	Message     string        // Error message returned by the service
+	RequestID   string        // Request ID of the server logs
+	RenderID    string        // Render ID of the failed render, if assigned
+	Errors      []string      // Every validation problem of invalid requests
+	Diagnostics []string      // WeasyPrint messages of failed renders
+	RetryAfter  time.Duration // Delay requested by the service for 429 and 503 responses
+}
+
+// problem is the RFC 7807 problem details body of error responses
+type problem struct {
+	Code        string   `json:"code"`
+	Detail      string   `json:"detail"`
+	RequestID   string   `json:"request_id"`
+	RenderID    string   `json:"render_id"`
+	Errors      []string `json:"errors"`
+	Diagnostics []string `json:"diagnostics"`
+}
+
+// newError reads an error response and closes its body
+func newError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		RenderID:   resp.Header.Get("X-Render-ID"),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	var details problem
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/problem+json") && json.Unmarshal(body, &details) == nil {
+		apiErr.Code = details.Code
+		apiErr.Message = details.Detail
+		apiErr.RequestID = details.RequestID
+		apiErr.Errors = details.Errors
+		apiErr.Diagnostics = details.Diagnostics
+		if details.RenderID != "" {
+			apiErr.RenderID = details.RenderID
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("rest-weasyprint: %d %s: %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Code, e.Message)
+	}
+	return fmt.Sprintf("rest-weasyprint: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is matches the sentinel error of the response status
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed when retried
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
@@ -0,0 +1,312 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"time"
+)
+
+// Share providers configured by default, ShareProviders lists those of a service
+const (
+	ShareFileIO = "file.io"
+	ShareKITC   = "ki.tc"
+	ShareCVSH   = "c-v.sh"
+)
+
+// QR code formats of LinkOptions
+const (
+	QRCodePNG = "png"
+	QRCodeSVG = "svg"
+)
+
+// WeasyPrintOptions are the WeasyPrint options of a render, zero values use the service defaults
+type WeasyPrintOptions struct {
+	// Basic options
+	Encoding  string `json:"encoding,omitempty"`
+	MediaType string `json:"media_type,omitempty"`
+	BaseURL   string `json:"base_url,omitempty"`
+
+	// PDF related options
+	PDFIdentifier string `json:"pdf_identifier,omitempty"`
+	PDFVariant    string `json:"pdf_variant,omitempty"` // e.g. pdf/a-3b, pdf/ua-1
+	PDFVersion    string `json:"pdf_version,omitempty"`
+	PDFForms      bool   `json:"pdf_forms,omitempty"`
+
+	// Output options
+	UncompressedPDF     bool `json:"uncompressed_pdf,omitempty"`
+	CustomMetadata      bool `json:"custom_metadata,omitempty"`
+	PresentationalHints bool `json:"presentational_hints,omitempty"`
+	SRGB                bool `json:"srgb,omitempty"`
+	OptimizeImages      bool `json:"optimize_images,omitempty"`
+	FullFonts           bool `json:"full_fonts,omitempty"`
+	Hinting             bool `json:"hinting,omitempty"`
+
+	// Quality and performance options
+	JPEGQuality int `json:"jpeg_quality,omitempty"` // 0 to 95
+	DPI         int `json:"dpi,omitempty"`          // 50 to 600
+	Timeout     int `json:"timeout,omitempty"`      // Remote resource timeout in seconds, 1 to 300
+
+	// Log level
+	Verbose bool `json:"verbose,omitempty"`
+	Debug   bool `json:"debug,omitempty"`
+	Quiet   bool `json:"quiet,omitempty"`
+}
+
+// RenderOptions configures a render, nil uses the defaults
+type RenderOptions struct {
+	Filename      string // Filename of the PDF, defaults to document.pdf
+	Options       *WeasyPrintOptions
+	StrictOptions bool // Fail with CodeInvalidOption instead of ignoring invalid options
+}
+
+// ShareOptions configures uploading the PDF to a sharing service
+type ShareOptions struct {
+	Service   string       // Name of a share provider
+	Fallbacks []string     // Providers tried in order when Service fails or is unavailable
+	Email     *Email       // Required by smtp providers
+	Link      *LinkOptions // Rejected by providers not supporting them
+	Encrypt   bool         // Upload the PDF encrypted, the link opens a decrypt page with the key in its fragment
+}
+
+// LinkOptions are settings of the share link, each provider accepts the ones it supports
+type LinkOptions struct {
+	Expires      string `json:"expires,omitempty"` // Duration such as 12h, 7d or 2w
+	MaxDownloads int    `json:"max_downloads,omitempty"`
+	Password     string `json:"password,omitempty"`
+	AutoDelete   bool   `json:"auto_delete,omitempty"` // Delete the PDF after its first download
+	QRCode       string `json:"qr_code,omitempty"`     // QRCodePNG or QRCodeSVG, returns a QR code of the link
+}
+
+// Email addresses the email smtp share providers deliver the PDF with. Subject and Body are
+// Go templates with .Filename, .Tenant, .RenderID, .Date and .Time
+type Email struct {
+	To      []string `json:"to"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	From    string   `json:"from,omitempty"` // Must be allowed for the tenant, defaults to the provider's sender
+	ReplyTo string   `json:"reply_to,omitempty"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body,omitempty"`
+}
+
+// ShareProvider describes a share provider configured on the service
+type ShareProvider struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Expires string `json:"expires,omitempty"`
+}
+
+// ShareResponse is the result of a share request
+type ShareResponse struct {
+	Link      string       `json:"link"`
+	Service   string       `json:"service"`
+	Success   bool         `json:"success"`
+	Message   string       `json:"message,omitempty"`
+	Filename  string       `json:"filename,omitempty"`
+	Key       string       `json:"key,omitempty"`           // Object key of storage providers
+	Path      string       `json:"path,omitempty"`          // Remote path of WebDAV and SFTP destinations
+	Expires   *time.Time   `json:"expires_at,omitempty"`    // When the link expires, if it does
+	MessageID string       `json:"message_id,omitempty"`    // Message-ID of emails sent by smtp providers
+	Options   *LinkOptions `json:"share_options,omitempty"` // Link options applied, without the password
+	QRCode    string       `json:"qr_code,omitempty"`       // QR code of the link as a data URI
+	RenderID  string       `json:"-"`                       // Render ID assigned by the service
+
+	Encryption *Encryption `json:"encryption,omitempty"` // Set for encrypted shares
+}
+
+// Encryption describes an encrypted share, Decrypt or the decrypt command restore the PDF
+type Encryption struct {
+	Algorithm    string `json:"algorithm"`
+	Key          string `json:"key"`           // Encoded as by EncodeKey
+	DownloadLink string `json:"download_link"` // Provider link of the ciphertext
+	Command      string `json:"decrypt_command"`
+}
+
+// Output is a destination the service uploads the PDF to with PUT, e.g. a presigned storage URL
+type Output struct {
+	PutURL  string            `json:"put_url"`
+	Headers map[string]string `json:"headers,omitempty"` // Extra upload headers, e.g. those the URL was signed with
+}
+
+// OutputResult is the result of rendering to an Output
+type OutputResult struct {
+	Success  bool   `json:"success"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Pages    int    `json:"pages"`  // 0 if the service could not determine it
+	Status   int    `json:"status"` // HTTP status of the upload
+	ETag     string `json:"etag,omitempty"`
+	RenderID string `json:"-"` // Render ID assigned by the service
+}
+
+// Destination types of Destination
+const (
+	DestinationResponse = "response" // The PDF is returned to the caller
+	DestinationShare    = "share"    // Uploaded to a share provider
+	DestinationPut      = "put"      // Uploaded to a client-supplied URL
+)
+
+// Destination is one of the destinations a single render is fanned out to, see ResponseDestination,
+// ShareDestination and PutDestination
+type Destination struct {
+	Type           string            `json:"type"`
+	ShareService   string            `json:"share_service,omitempty"`
+	ShareFallbacks []string          `json:"share_fallbacks,omitempty"`
+	Link           *LinkOptions      `json:"share_options,omitempty"`
+	EncryptShare   bool              `json:"encrypt_share,omitempty"`
+	Email          *Email            `json:"email,omitempty"`
+	PutURL         string            `json:"put_url,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+}
+
+// ResponseDestination returns the PDF to the caller
+func ResponseDestination() Destination {
+	return Destination{Type: DestinationResponse}
+}
+
+// ShareDestination uploads the PDF as share does
+func ShareDestination(share ShareOptions) Destination {
+	return Destination{
+		Type:           DestinationShare,
+		ShareService:   share.Service,
+		ShareFallbacks: share.Fallbacks,
+		Link:           share.Link,
+		EncryptShare:   share.Encrypt,
+		Email:          share.Email,
+	}
+}
+
+// PutDestination uploads the PDF to output
+func PutDestination(output Output) Destination {
+	return Destination{Type: DestinationPut, PutURL: output.PutURL, Headers: output.Headers}
+}
+
+// OutputsResult is the result of rendering to several destinations
+type OutputsResult struct {
+	Success  bool                `json:"success"` // All destinations succeeded
+	Size     int64               `json:"size"`
+	SHA256   string              `json:"sha256"`
+	Pages    int                 `json:"pages"`
+	Results  []DestinationResult `json:"results"` // In the order of the destinations
+	RenderID string              `json:"-"`       // Render ID assigned by the service
+}
+
+// DestinationResult is the result of one destination
+type DestinationResult struct {
+	Type    string         `json:"type"`
+	Success bool           `json:"success"`
+	Error   string         `json:"error,omitempty"`
+	Share   *ShareResponse `json:"share,omitempty"`  // Set for share destinations
+	Output  *OutputResult  `json:"output,omitempty"` // Set for put destinations
+}
+
+// File is a named file upload
+type File struct {
+	Name   string // Filename, referenced by the HTML for resources
+	Reader io.Reader
+}
+
+// Files are the files of a RenderFiles request. Files are streamed to the service, requests are
+// retried only when every reader is an io.Seeker, such as *os.File or *bytes.Reader
+type Files struct {
+	HTML        io.Reader
+	Stylesheets []File // Without stylesheets the service applies its default page stylesheet
+	Resources   []File // Images, fonts and other files referenced by the HTML
+	Attachments []File // Files attached to the PDF
+}
+
+// formFile is a file of a multipart form field
+type formFile struct {
+	field string
+	File
+}
+
+// fields returns the files by form field
+func (f *Files) fields() []formFile {
+	fields := []formFile{{"html", File{Name: "index.html", Reader: f.HTML}}}
+	for _, file := range f.Stylesheets {
+		fields = append(fields, formFile{"css." + file.Name, file})
+	}
+	for _, file := range f.Resources {
+		fields = append(fields, formFile{"asset." + file.Name, file})
+	}
+	for _, file := range f.Attachments {
+		fields = append(fields, formFile{"attachment." + file.Name, file})
+	}
+	return fields
+}
+
+// check verifies every file has a name and reader
+func (f *Files) check() error {
+	for _, file := range f.fields() {
+		if file.Name == "" || file.Reader == nil {
+			return fmt.Errorf("file %s requires a name and reader", file.field)
+		}
+	}
+	return nil
+}
+
+// rewinder returns a function seeking every reader back to its current offset so the files can be
+// sent again, nil when a reader cannot seek and the files can be read once only
+func (f *Files) rewinder() func() error {
+	type position struct {
+		seeker io.Seeker
+		offset int64
+	}
+	var positions []position
+	for _, file := range f.fields() {
+		seeker, ok := file.Reader.(io.Seeker)
+		if !ok {
+			return nil
+		}
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil // Pipes and terminals are files that cannot seek
+		}
+		positions = append(positions, position{seeker, offset})
+	}
+	return func() error {
+		for _, p := range positions {
+			if _, err := p.seeker.Seek(p.offset, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to rewind file: %v", err)
+			}
+		}
+		return nil
+	}
+}
+
+// write adds the files to a multipart form
+func (f *Files) write(form *multipart.Writer) error {
+	for _, file := range f.fields() {
+		part, err := form.CreateFormFile(file.field, file.Name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %v", file.field, err)
+		}
+		if _, err := io.Copy(part, file.Reader); err != nil {
+			return fmt.Errorf("failed to read %s: %v", file.Name, err)
+		}
+	}
+	return nil
+}
+
+// weasyPrintOptions returns the WeasyPrint options, nil when unset
+func (o *RenderOptions) weasyPrintOptions() *WeasyPrintOptions {
+	if o == nil {
+		return nil
+	}
+	return o.Options
+}
+
+// query returns the query parameters of the render
+func (o *RenderOptions) query() url.Values {
+	query := url.Values{}
+	if o != nil && o.Filename != "" {
+		query.Set("filename", o.Filename)
+	}
+	if o != nil && o.StrictOptions {
+		query.Set("strict_options", "true")
+	}
+	return query
+}
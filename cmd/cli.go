@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cxjava/rest-weasyprint/client"
+)
+
+// usage describes the subcommands
+const usage = `Usage:
+  rest-weasyprint serve [flags]     Start the HTTP server (default without subcommand)
+  rest-weasyprint render [flags]    Render a PDF remotely or with --local WeasyPrint
+  rest-weasyprint decrypt [flags]   Download and decrypt an encrypted share
+
+Run "rest-weasyprint <command> -h" for the flags of a command.
+`
+
+// runCommand dispatches the subcommand in args, flags without subcommand start the server
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+
+	switch args[0] {
+	case "serve":
+		return runServe(args[1:])
+	case "render":
+		return runRender(args[1:])
+	case "decrypt":
+		return runDecrypt(args[1:])
+	case "help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// renderFlags holds the flags of the render command
+type renderFlags struct {
+	html        string
+	url         string
+	css         stringList
+	assets      stringList
+	attachments stringList
+	options     string
+	strict      bool
+	output      string
+	filename    string
+	share       string
+	encrypt     bool
+	server      string
+	apiKey      string
+	timeout     time.Duration
+	local       bool
+	config      string
+	verbose     bool
+}
+
+// runRender renders a PDF through a server or, with --local, through the local WeasyPrint
+func runRender(args []string) int {
+	var f renderFlags
+	fs := flag.NewFlagSet("rest-weasyprint render", flag.ContinueOnError)
+	fs.StringVar(&f.html, "html", "", "HTML file to render, - reads stdin")
+	fs.StringVar(&f.url, "url", "", "URL to render instead of an HTML file")
+	fs.Var(&f.css, "css", "stylesheet file, repeatable")
+	fs.Var(&f.assets, "asset", "resource file referenced by the HTML, repeatable")
+	fs.Var(&f.attachments, "attachment", "file attached to the PDF, repeatable")
+	fs.StringVar(&f.options, "options", "", `WeasyPrint options as JSON, e.g. {"dpi": 150}`)
+	fs.BoolVar(&f.strict, "strict-options", false, "fail instead of ignoring invalid options")
+	fs.StringVar(&f.output, "o", "-", "output PDF file, - writes stdout")
+	fs.StringVar(&f.filename, "filename", "", "PDF filename reported to the server")
+	fs.StringVar(&f.share, "share", "", "upload to a sharing service and print the link (remote only)")
+	fs.BoolVar(&f.encrypt, "encrypt", false, "encrypt the shared PDF, the link opens a decrypt page")
+	fs.StringVar(&f.server, "server", envOr("WEB_SERVER_URL", "http://localhost:8080"), "server URL (env WEB_SERVER_URL)")
+	fs.StringVar(&f.apiKey, "api-key", os.Getenv("WEB_API_KEY"), "server API key (env WEB_API_KEY)")
+	fs.DurationVar(&f.timeout, "timeout", 2*time.Minute, "render timeout")
+	fs.BoolVar(&f.local, "local", false, "render with the local WeasyPrint instead of a server")
+	fs.StringVar(&f.config, "config", os.Getenv("WEB_CONFIG"), "config file for --local (env WEB_CONFIG)")
+	fs.BoolVar(&f.verbose, "v", false, "log render details to stderr")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if (f.html == "") == (f.url == "") {
+		fmt.Fprintln(os.Stderr, "exactly one of --html or --url is required")
+		return 2
+	}
+	if f.url != "" && (len(f.css) > 0 || len(f.assets) > 0 || len(f.attachments) > 0) {
+		fmt.Fprintln(os.Stderr, "--css, --asset and --attachment require --html")
+		return 2
+	}
+	if f.local && f.share != "" {
+		fmt.Fprintln(os.Stderr, "--share is not supported with --local")
+		return 2
+	}
+	if f.encrypt && f.share == "" {
+		fmt.Fprintln(os.Stderr, "--encrypt requires --share")
+		return 2
+	}
+	if name := duplicateBaseName(f.css, f.assets, f.attachments); name != "" {
+		fmt.Fprintf(os.Stderr, "more than one file is named %s, the HTML references files by name\n", name)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case f.share != "":
+		err = shareRemote(ctx, &f)
+	case f.local:
+		err = writeOutput(f.output, func(w io.Writer) error { return renderLocal(ctx, &f, w) })
+	default:
+		err = writeOutput(f.output, func(w io.Writer) error { return renderRemote(ctx, &f, w) })
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "render failed:", err)
+		var apiErr *client.Error
+		if errors.As(err, &apiErr) {
+			for _, line := range append(apiErr.Errors, apiErr.Diagnostics...) {
+				fmt.Fprintln(os.Stderr, "  "+line)
+			}
+		}
+		return 1
+	}
+	return 0
+}
+
+// renderRemote renders through the server
+func renderRemote(ctx context.Context, f *renderFlags, w io.Writer) error {
+	c, opts, err := f.remoteClient()
+	if err != nil {
+		return err
+	}
+	if f.url != "" {
+		return c.RenderURL(ctx, f.url, w, opts)
+	}
+
+	files, closeFiles, err := f.clientFiles()
+	if err != nil {
+		return err
+	}
+	defer closeFiles()
+	return c.RenderFiles(ctx, files, w, opts)
+}
+
+// shareRemote renders through the server, uploads to a sharing service and prints the share response
+func shareRemote(ctx context.Context, f *renderFlags) error {
+	c, opts, err := f.remoteClient()
+	if err != nil {
+		return err
+	}
+
+	share := client.ShareOptions{Service: f.share, Encrypt: f.encrypt}
+	var response *client.ShareResponse
+	if f.url != "" {
+		response, err = c.ShareURL(ctx, f.url, share, opts)
+	} else {
+		files, closeFiles, fileErr := f.clientFiles()
+		if fileErr != nil {
+			return fileErr
+		}
+		defer closeFiles()
+		response, err = c.ShareFiles(ctx, files, share, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(response)
+}
+
+// runDecrypt downloads an encrypted share and writes the decrypted PDF
+func runDecrypt(args []string) int {
+	fs := flag.NewFlagSet("rest-weasyprint decrypt", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rest-weasyprint decrypt [flags] <decrypt link | ciphertext URL or file with --key>")
+		fs.PrintDefaults()
+	}
+	output := fs.String("o", "-", "output PDF file, - writes stdout")
+	encodedKey := fs.String("key", "", "key of the share, the decrypt link carries it otherwise")
+	timeout := fs.Duration("timeout", 2*time.Minute, "download timeout")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	source := fs.Arg(0)
+	var key []byte
+	var err error
+	if *encodedKey != "" {
+		key, err = client.DecodeKey(*encodedKey)
+	} else {
+		source, _, key, err = client.ParseDecryptLink(source)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "decrypt failed:", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	err = writeOutput(*output, func(w io.Writer) error {
+		ciphertext, err := openShare(ctx, source)
+		if err != nil {
+			return err
+		}
+		defer ciphertext.Close()
+		return client.Decrypt(w, ciphertext, key)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "decrypt failed:", err)
+		return 1
+	}
+	return 0
+}
+
+// openShare opens the ciphertext at an http(s) URL or local path
+func openShare(ctx context.Context, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// remoteClient creates the API client and render options from the flags
+func (f *renderFlags) remoteClient() (*client.Client, *client.RenderOptions, error) {
+	c, err := client.New(f.server, client.WithAPIKey(f.apiKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := &client.RenderOptions{Filename: f.filename, StrictOptions: f.strict}
+	if f.options != "" {
+		opts.Options = &client.WeasyPrintOptions{}
+		decoder := json.NewDecoder(strings.NewReader(f.options))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(opts.Options); err != nil {
+			return nil, nil, fmt.Errorf("invalid --options: %v", err)
+		}
+	}
+	return c, opts, nil
+}
+
+// clientFiles opens the input files for upload, the returned function closes them
+func (f *renderFlags) clientFiles() (*client.Files, func(), error) {
+	var opened []*os.File
+	closeAll := func() {
+		for _, file := range opened {
+			file.Close()
+		}
+	}
+	open := func(paths []string) ([]client.File, error) {
+		files := make([]client.File, 0, len(paths))
+		for _, path := range paths {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			opened = append(opened, file)
+			files = append(files, client.File{Name: filepath.Base(path), Reader: file})
+		}
+		return files, nil
+	}
+
+	files := &client.Files{HTML: os.Stdin}
+	if f.html != "-" {
+		html, err := os.Open(f.html)
+		if err != nil {
+			return nil, nil, err
+		}
+		opened = append(opened, html)
+		files.HTML = html
+	}
+
+	var err error
+	if files.Stylesheets, err = open(f.css); err == nil {
+		if files.Resources, err = open(f.assets); err == nil {
+			files.Attachments, err = open(f.attachments)
+		}
+	}
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return files, closeAll, nil
+}
+
+// renderLocal renders with the local WeasyPrint using the server's option validation and argument building
+func renderLocal(ctx context.Context, f *renderFlags, w io.Writer) error {
+	var configArgs []string
+	if f.config != "" {
+		configArgs = []string{"-config", f.config}
+	}
+	config, _, err := loadConfig(configArgs)
+	if err != nil {
+		return err
+	}
+
+	level := slog.LevelWarn
+	if f.verbose {
+		level = slog.LevelInfo
+	}
+	logger, err := newLogger(os.Stderr, level, LogFormatText)
+	if err != nil {
+		return err
+	}
+	service, err := NewPDFService(config, logger, NewMetrics(config.Render.TempDir))
+	if err != nil {
+		return err
+	}
+	defer service.cleanupAllTemp(context.Background())
+
+	var options map[string]interface{}
+	if f.options != "" {
+		if err := json.Unmarshal([]byte(f.options), &options); err != nil {
+			return fmt.Errorf("invalid --options: %v", err)
+		}
+	}
+	weasyPrintOptions, ignored := service.validateOptions(ctx, options)
+	if len(ignored) > 0 && (f.strict || config.Render.StrictOptions) {
+		return fmt.Errorf("invalid options: %s", strings.Join(ignored, "; "))
+	}
+	for _, option := range ignored {
+		fmt.Fprintln(os.Stderr, "ignoring option", option)
+	}
+
+	if f.url != "" {
+		return service.generatePDFFromHTML(ctx, w, f.url, weasyPrintOptions)
+	}
+
+	// Stage the files in a temporary directory like uploads, so relative references resolve the same way
+	tempDir, err := service.createTempDir(ctx)
+	if err != nil {
+		return err
+	}
+	defer service.cleanupTempDir(ctx, tempDir)
+
+	fileInfo := &UploadedFileInfo{Options: weasyPrintOptions}
+	if fileInfo.HTMLPath, err = stageFile(tempDir, f.html, "index.html"); err != nil {
+		return err
+	}
+	for _, path := range f.css {
+		staged, err := stageFile(tempDir, path, "")
+		if err != nil {
+			return err
+		}
+		fileInfo.CSSPaths = append(fileInfo.CSSPaths, staged)
+	}
+	for _, path := range append(f.assets, f.attachments...) {
+		staged, err := stageFile(tempDir, path, "")
+		if err != nil {
+			return err
+		}
+		fileInfo.Attachments = append(fileInfo.Attachments, staged)
+	}
+	if len(fileInfo.CSSPaths) == 0 {
+		defaultCSSPath, err := service.createDefaultCSS(tempDir)
+		if err != nil {
+			return fmt.Errorf("failed to create default CSS: %v", err)
+		}
+		fileInfo.CSSPaths = append(fileInfo.CSSPaths, defaultCSSPath)
+	}
+
+	return service.generatePDFFromFiles(ctx, w, fileInfo)
+}
+
+// duplicateBaseName returns a file name shared by several paths, empty when names are unique
+func duplicateBaseName(lists ...[]string) string {
+	seen := make(map[string]bool)
+	for _, paths := range lists {
+		for _, path := range paths {
+			name := filepath.Base(path)
+			if seen[name] {
+				return name
+			}
+			seen[name] = true
+		}
+	}
+	return ""
+}
+
+// stageFile copies path into dir keeping its name, - copies stdin to stdinName. It fails rather than
+// overwrite a file staged under the same name
+func stageFile(dir, path, stdinName string) (string, error) {
+	src, name := os.Stdin, stdinName
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		src, name = file, filepath.Base(path)
+	}
+
+	dstPath := filepath.Join(dir, name)
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("%s: another file named %s is already staged", path, name)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return "", fmt.Errorf("failed to copy %s: %v", path, err)
+	}
+	return dstPath, nil
+}
+
+// writeOutput runs render against the output file, - is stdout. A failed render removes the file
+func writeOutput(path string, render func(w io.Writer) error) error {
+	if path == "-" {
+		return render(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := render(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// envOr returns the environment variable or fallback when unset
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
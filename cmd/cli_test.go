@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cxjava/rest-weasyprint/client"
+)
+
+func TestStageFileRejectsDuplicateNames(t *testing.T) {
+	src, dir := t.TempDir(), t.TempDir()
+	for _, path := range []string{"a/logo.png", "b/logo.png"} {
+		os.MkdirAll(filepath.Join(src, filepath.Dir(path)), 0o700)
+		os.WriteFile(filepath.Join(src, path), []byte(path), 0o600)
+	}
+
+	staged, err := stageFile(dir, filepath.Join(src, "a/logo.png"), "")
+	if err != nil || staged != filepath.Join(dir, "logo.png") {
+		t.Fatalf("staged %q: %v", staged, err)
+	}
+	if _, err := stageFile(dir, filepath.Join(src, "b/logo.png"), ""); err == nil || !strings.Contains(err.Error(), "another file named logo.png") {
+		t.Errorf("duplicate name staged: %v", err)
+	}
+	if data, _ := os.ReadFile(staged); string(data) != "a/logo.png" {
+		t.Errorf("staged file overwritten with %q", data)
+	}
+}
+
+func TestDuplicateBaseName(t *testing.T) {
+	tests := []struct {
+		lists [][]string
+		want  string
+	}{
+		{[][]string{{"style.css"}, {"img/logo.png", "font.woff"}, {"terms.pdf"}}, ""},
+		{[][]string{{"a/style.css", "b/style.css"}}, "style.css"},
+		{[][]string{{"style.css"}, {"assets/style.css"}}, "style.css"},
+		{[][]string{nil, {"logo.png"}, {"docs/logo.png"}}, "logo.png"},
+	}
+	for _, tt := range tests {
+		if got := duplicateBaseName(tt.lists...); got != tt.want {
+			t.Errorf("duplicateBaseName(%q) = %q, want %q", tt.lists, got, tt.want)
+		}
+	}
+}
+
+// echoWeasyPrint is a fake weasyprint writing a PDF header followed by its arguments, files as name=content
+const echoWeasyPrint = `if [ "$1" = --version ]; then echo "WeasyPrint version 66.0"; exit; fi
+printf '%%PDF'
+for arg in "$@"; do
+	if [ -f "$arg" ]; then printf ' %s=%s' "$(basename "$arg")" "$(cat "$arg")"; else printf ' %s' "$arg"; fi
+done`
+
+func TestRunRender(t *testing.T) {
+	weasyPrint := fakeWeasyPrint(t, echoWeasyPrint)
+	config := defaultConfig()
+	config.Render.WeasyPrintBin = weasyPrint
+	s := newTestService(t, config)
+	server := httptest.NewServer(newListenerServer(ListenerConfig{}, nil, s, NewHealthChecker(s), NewConfigReloader(nil, s, &slog.LevelVar{}), context.Background()).Handler)
+	defer server.Close()
+	configFile := writeConfigFile(t, "config.yaml", fmt.Sprintf("render:\n  weasyprint_bin: %s\n  temp_dir: %s\n", weasyPrint, t.TempDir()))
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o700)
+		os.WriteFile(path, []byte(content), 0o600)
+		return path
+	}
+	html, css := write("page.html", "<h1>Hi</h1>"), write("style.css", "h1{}")
+	logo, otherLogo := write("img/logo.png", "png"), write("other/logo.png", "other")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+		want []string // Parts of the output PDF
+	}{
+		{"remote", []string{"--html", html, "--css", css, "--asset", logo, "--options", `{"dpi": 150}`, "--server", server.URL}, 0,
+			[]string{"%PDF", "--dpi 150", "--stylesheet style.css=h1{}", "--attachment logo.png=png", "=<h1>Hi</h1> -"}},
+		{"remote rejected", []string{"--html", html, "--options", `{"dpi": 5000}`, "--strict-options", "--server", server.URL}, 1, nil},
+		{"remote unknown option", []string{"--html", html, "--options", `{"dots": 150}`, "--server", server.URL}, 1, nil},
+		{"local", []string{"--local", "--config", configFile, "--html", html, "--css", css, "--asset", logo, "--options", `{"dpi": 150}`}, 0,
+			[]string{"%PDF", "--dpi 150", "--stylesheet style.css=h1{}", "--attachment logo.png=png", "page.html=<h1>Hi</h1> -"}},
+		{"local default stylesheet", []string{"--local", "--config", configFile, "--html", html}, 0,
+			[]string{"--stylesheet default.css=@page { size: A4;"}},
+		{"local rejected", []string{"--local", "--config", configFile, "--html", html, "--options", `{"dpi": 5000}`, "--strict-options"}, 1, nil},
+		{"duplicate names", []string{"--local", "--config", configFile, "--html", html, "--asset", logo, "--asset", otherLogo}, 2, nil},
+		{"html and url", []string{"--html", html, "--url", "https://example.com"}, 2, nil},
+		{"neither html nor url", nil, 2, nil},
+		{"local share", []string{"--local", "--html", html, "--share", "fileio"}, 2, nil},
+		{"encrypt without share", []string{"--html", html, "--encrypt"}, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out.pdf")
+			if code := runRender(append(tt.args, "-o", out)); code != tt.code {
+				t.Fatalf("exit code %d, want %d", code, tt.code)
+			}
+			pdf, err := os.ReadFile(out)
+			if tt.code != 0 {
+				if err == nil {
+					t.Errorf("output %q left after a failed render", pdf)
+				}
+				return
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(pdf), want) {
+					t.Errorf("output %q lacks %q", pdf, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDecrypt(t *testing.T) {
+	key, _ := client.NewEncryptionKey()
+	var ciphertext bytes.Buffer
+	encrypter, _ := client.NewEncrypter(&ciphertext, key)
+	io.WriteString(encrypter, "%PDF-1.7 secret")
+	encrypter.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/s/token" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(ciphertext.Bytes())
+	}))
+	defer server.Close()
+	file := filepath.Join(t.TempDir(), "share.bin")
+	os.WriteFile(file, ciphertext.Bytes(), 0o600)
+	otherKey, _ := client.NewEncryptionKey()
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"decrypt link", []string{client.DecryptLink(server.URL+"/decrypt", server.URL+"/s/token", "a.pdf", key)}, 0},
+		{"URL and key", []string{"--key", client.EncodeKey(key), server.URL + "/s/token"}, 0},
+		{"file and key", []string{"--key", client.EncodeKey(key), file}, 0},
+		{"wrong key", []string{"--key", client.EncodeKey(otherKey), file}, 1},
+		{"download failure", []string{client.DecryptLink(server.URL+"/decrypt", server.URL+"/s/gone", "a.pdf", key)}, 1},
+		{"link without key", []string{server.URL + "/s/token"}, 2},
+		{"invalid key", []string{"--key", "not-a-key", file}, 2},
+		{"no source", nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out.pdf")
+			if code := runDecrypt(append([]string{"-o", out}, tt.args...)); code != tt.code {
+				t.Fatalf("exit code %d, want %d", code, tt.code)
+			}
+			pdf, err := os.ReadFile(out)
+			if tt.code == 0 && string(pdf) != "%PDF-1.7 secret" {
+				t.Errorf("decrypted %q, %v", pdf, err)
+			}
+			if tt.code != 0 && err == nil {
+				t.Errorf("output %q left after a failure", pdf)
+			}
+		})
+	}
+}
@@ -1,26 +1,620 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
-// Configuration constants
+// Configuration defaults
 const (
 	DefaultPort           = ":8080"
-	DefaultTimeoutSeconds = 30       // Default timeout in seconds
-	MaxUploadSize         = 32 << 20 // 32MB
+	DefaultTimeoutSeconds = 30 // Default timeout in seconds
+	DefaultMaxUploadMB    = 32 // 32MB
 	DefaultPageMargin     = "2cm 2.5cm"
 	DefaultPageSize       = "A4"
+	DefaultWeasyPrintBin  = "weasyprint"
+
+	DefaultMaxQueuedRenders = 64  // Queue length above which readiness fails
+	DefaultMinFreeDiskMB    = 100 // Minimum free temp dir space for readiness
+	DefaultDrainSeconds     = 30  // Time in-flight renders get to finish on shutdown
+	DefaultShutdownDelay    = 5   // Seconds readiness fails before listeners close on shutdown
+
+	DefaultConnectTimeoutSeconds  = 10  // Outbound dial and TLS handshake timeout
+	DefaultResponseTimeoutSeconds = 60  // Outbound wait for response headers
+	DefaultUploadTimeoutSeconds   = 120 // Outbound request timeout including the body
+	DefaultMaxRetries             = 2
+	DefaultRetryBackoffMS         = 500
+	DefaultBreakerFailures        = 5
+	DefaultBreakerCooldownSeconds = 30
 )
 
-// getTimeoutFromEnv gets timeout setting from environment variables, uses default if not exists
-func getTimeoutFromEnv() int {
-	timeoutSeconds := DefaultTimeoutSeconds
-	if envTimeout := os.Getenv("WEB_TIME_OUT_SECOND"); envTimeout != "" {
-		if parsedTimeout, err := strconv.Atoi(envTimeout); err == nil && parsedTimeout > 0 {
-			timeoutSeconds = parsedTimeout
+// Config holds the effective service configuration
+type Config struct {
+	Server   ServerConfig                  `yaml:"server"`
+	Render   RenderConfig                  `yaml:"render"`
+	Health   HealthConfig                  `yaml:"health"`
+	Log      LogConfig                     `yaml:"log"`
+	Trace    TraceConfig                   `yaml:"trace"`
+	Share    map[string]ShareServiceConfig `yaml:"share"`
+	Outbound OutboundConfig                `yaml:"outbound"`
+	Security SecurityConfig                `yaml:"security"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Listen                string           `yaml:"listen"`
+	RequestTimeoutSeconds int              `yaml:"request_timeout_seconds"`
+	DrainTimeoutSeconds   int              `yaml:"drain_timeout_seconds"`
+	ShutdownDelaySeconds  int              `yaml:"shutdown_delay_seconds"` // Requests are still served while readiness fails
+	TLS                   TLSConfig        `yaml:"tls"`
+	Listeners             []ListenerConfig `yaml:"listeners,omitempty"` // Replace listen when set
+}
+
+// ListenerConfig configures a listener and the routes it serves
+type ListenerConfig struct {
+	Address    string   `yaml:"address"`               // host:port or unix:/path/to.sock
+	SocketMode string   `yaml:"socket_mode,omitempty"` // Unix socket permissions in octal, e.g. 0660
+	Routes     []string `yaml:"routes,omitempty"`      // api, health, metrics, admin, docs; empty serves all
+	TLS        bool     `yaml:"tls,omitempty"`         // Serve HTTPS using server.tls
+}
+
+// TLSConfig configures HTTPS serving, an empty cert_file serves plain HTTP
+type TLSConfig struct {
+	CertFile     string   `yaml:"cert_file"`
+	KeyFile      string   `yaml:"key_file"`
+	MinVersion   string   `yaml:"min_version"`             // 1.0 to 1.3, defaults to 1.2
+	CipherSuites []string `yaml:"cipher_suites,omitempty"` // Go cipher suite names for TLS 1.2 and below
+	ClientCAFile string   `yaml:"client_ca_file"`          // CA bundle verifying client certificates
+	ClientAuth   string   `yaml:"client_auth"`             // none, request, require; defaults to require with a client CA
+}
+
+// RenderConfig configures PDF rendering
+type RenderConfig struct {
+	WeasyPrintBin        string                 `yaml:"weasyprint_bin"`
+	TempDir              string                 `yaml:"temp_dir"`
+	MaxUploadMB          int                    `yaml:"max_upload_mb"`
+	MaxConcurrentRenders int                    `yaml:"max_concurrent_renders"`
+	PageSize             string                 `yaml:"page_size"`
+	PageMargin           string                 `yaml:"page_margin"`
+	DefaultOptions       map[string]interface{} `yaml:"default_options,omitempty"` // Applied before request options
+	StrictOptions        bool                   `yaml:"strict_options"`            // Reject requests with invalid options instead of ignoring them
+}
+
+// HealthConfig configures readiness checks
+type HealthConfig struct {
+	MaxQueuedRenders    int `yaml:"max_queued_renders"`
+	MinFreeDiskMB       int `yaml:"min_free_disk_mb"`
+	DeepIntervalSeconds int `yaml:"deep_interval_seconds"` // Zero disables the deep check
+}
+
+// LogConfig configures logging
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// TraceConfig configures tracing
+type TraceConfig struct {
+	Exporter string `yaml:"exporter"`
+	File     string `yaml:"file"`
+}
+
+// OutboundConfig configures the HTTP client and retries of share and output uploads
+type OutboundConfig struct {
+	ConnectTimeoutSeconds  int `yaml:"connect_timeout_seconds"`
+	ResponseTimeoutSeconds int `yaml:"response_timeout_seconds"` // Wait for response headers once a request is sent
+	UploadTimeoutSeconds   int `yaml:"upload_timeout_seconds"`   // Limit of a whole request including the body
+	MaxRetries             int `yaml:"max_retries"`              // Retries of share uploads failing with network errors, 429 or 5xx
+	RetryBackoffMS         int `yaml:"retry_backoff_ms"`         // First retry delay, doubled per retry with jitter
+	BreakerFailures        int `yaml:"breaker_failures"`         // Consecutive failures opening a provider's circuit, 0 disables it
+	BreakerCooldownSeconds int `yaml:"breaker_cooldown_seconds"` // Time an open circuit fails fast before a trial upload
+}
+
+// ShareServiceConfig configures a share provider, entries are selected by their name
+type ShareServiceConfig struct {
+	Type          string           `yaml:"type,omitempty"` // Provider type, defaults to the entry name
+	Endpoint      string           `yaml:"endpoint,omitempty"`
+	APIKey        string           `yaml:"api_key,omitempty"`
+	Expires       string           `yaml:"expires,omitempty"` // Lifetime of uploads in the provider's format, e.g. 1d
+	Disabled      bool             `yaml:"disabled,omitempty"`
+	ContentLength bool             `yaml:"content_length,omitempty"` // Render to a temporary file before uploading instead of streaming, for targets rejecting chunked requests
+	S3            S3Config         `yaml:"s3,omitempty"`             // Settings of s3 providers
+	Local         LocalShareConfig `yaml:"local,omitempty"`          // Settings of local providers
+	WebDAV        WebDAVConfig     `yaml:"webdav,omitempty"`         // Settings of webdav providers
+	SFTP          SFTPConfig       `yaml:"sftp,omitempty"`           // Settings of sftp providers
+	SMTP          SMTPConfig       `yaml:"smtp,omitempty"`           // Settings of smtp providers
+}
+
+// SMTPConfig configures email delivery, endpoint is smtp://host[:port] or smtps://host[:port]
+type SMTPConfig struct {
+	Username          string              `yaml:"username,omitempty"`
+	Password          string              `yaml:"password,omitempty"`
+	TLS               string              `yaml:"tls,omitempty"`                // starttls, implicit or none, defaults to implicit for smtps and port 465, starttls otherwise
+	CAFile            string              `yaml:"ca_file,omitempty"`            // CA bundle verifying the server, defaults to the system roots
+	Hello             string              `yaml:"hello,omitempty"`              // EHLO name, defaults to the hostname
+	From              string              `yaml:"from"`                         // Default sender
+	Senders           map[string][]string `yaml:"senders,omitempty"`            // Further sender addresses or @domains by tenant
+	AllowedRecipients []string            `yaml:"allowed_recipients,omitempty"` // Recipient addresses or @domains, "*" allows all, required
+}
+
+// WebDAVConfig configures a WebDAV destination, endpoint is the base collection URL
+type WebDAVConfig struct {
+	Username     string `yaml:"username,omitempty"`
+	Password     string `yaml:"password,omitempty"`
+	Auth         string `yaml:"auth,omitempty"`          // basic, digest, or empty to answer the server's challenge
+	PathTemplate string `yaml:"path_template,omitempty"` // Go template of the path below the endpoint
+}
+
+// SFTPConfig configures an SFTP destination, endpoint is sftp://host[:port]
+type SFTPConfig struct {
+	Username             string `yaml:"username"`
+	Password             string `yaml:"password,omitempty"`
+	PrivateKeyFile       string `yaml:"private_key_file,omitempty"`
+	PrivateKeyPassphrase string `yaml:"private_key_passphrase,omitempty"`
+	KnownHostsFile       string `yaml:"known_hosts_file"`        // Verifies the server's host key
+	PathTemplate         string `yaml:"path_template,omitempty"` // Go template of the remote path, relative to the login directory
+}
+
+// LocalShareConfig configures a provider storing PDFs on the service's disk, served at /s/{token}
+type LocalShareConfig struct {
+	Directory    string `yaml:"directory,omitempty"`     // Defaults to rest-weasyprint-shares in the system temp directory
+	PublicURL    string `yaml:"public_url,omitempty"`    // Base URL of links, defaults to the URL the request reached
+	MaxDownloads int    `yaml:"max_downloads,omitempty"` // 0 allows unlimited downloads until expiry
+	Password     string `yaml:"password,omitempty"`      // Required as HTTP basic auth password when set
+	SigningKey   string `yaml:"signing_key,omitempty"`   // Signs links, defaults to a key generated in the directory
+}
+
+// S3Config configures an S3-compatible storage provider
+type S3Config struct {
+	Bucket               string `yaml:"bucket"`
+	Region               string `yaml:"region,omitempty"`                 // Defaults to us-east-1
+	AccessKeyID          string `yaml:"access_key_id,omitempty"`          // Defaults to AWS_ACCESS_KEY_ID
+	SecretAccessKey      string `yaml:"secret_access_key,omitempty"`      // Defaults to AWS_SECRET_ACCESS_KEY
+	SessionToken         string `yaml:"session_token,omitempty"`          // Defaults to AWS_SESSION_TOKEN
+	ForcePathStyle       bool   `yaml:"force_path_style,omitempty"`       // Bucket in the path instead of the host, e.g. for MinIO
+	KeyTemplate          string `yaml:"key_template,omitempty"`           // Go template of the object key
+	ServerSideEncryption string `yaml:"server_side_encryption,omitempty"` // AES256 or aws:kms
+	KMSKeyID             string `yaml:"kms_key_id,omitempty"`
+	ContentDisposition   string `yaml:"content_disposition,omitempty"`    // attachment or inline, defaults to attachment
+	PresignExpirySeconds int    `yaml:"presign_expiry_seconds,omitempty"` // Link is a presigned GET URL when positive
+	PartSizeMB           int    `yaml:"part_size_mb,omitempty"`           // Larger uploads use multipart upload, defaults to 16
+}
+
+// ProviderType returns the provider type of the share config entry named name
+func (c ShareServiceConfig) ProviderType(name string) string {
+	if c.Type != "" {
+		return c.Type
+	}
+	return name
+}
+
+// SecurityConfig configures access policies
+type SecurityConfig struct {
+	APIKeys            []APIKeyConfig     `yaml:"api_keys,omitempty"`             // Empty disables authentication
+	AdminKey           string             `yaml:"admin_key,omitempty"`            // Required by admin endpoints, empty disables them
+	AllowedHosts       []string           `yaml:"allowed_hosts,omitempty"`        // Hosts allowed for URL rendering, empty allows all
+	AllowedOutputHosts []string           `yaml:"allowed_output_hosts,omitempty"` // Hosts output.put_url may point to, empty allows all
+	AllowPrivateOutput bool               `yaml:"allow_private_output,omitempty"` // Allow output.put_url to reach loopback, private and link-local addresses
+	ClientCerts        []ClientCertConfig `yaml:"client_certs,omitempty"`         // Client certificate subjects mapped to tenants
+	RateLimit          RateLimitConfig    `yaml:"rate_limit"`
+	TrustedProxies     []string           `yaml:"trusted_proxies,omitempty"` // Addresses or CIDR ranges whose X-Forwarded-* and X-Real-IP headers are used
+}
+
+// APIKeyConfig maps an API key to a tenant
+type APIKeyConfig struct {
+	Key    string `yaml:"key"`
+	Tenant string `yaml:"tenant"`
+}
+
+// ClientCertConfig maps a client certificate subject common name to a tenant
+type ClientCertConfig struct {
+	CommonName string `yaml:"common_name"`
+	Tenant     string `yaml:"tenant"`
+}
+
+// RateLimitConfig limits requests per client, keyed by tenant or client IP
+type RateLimitConfig struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"` // Zero disables rate limiting
+	Burst             int `yaml:"burst"`
+}
+
+// configBinding binds an environment variable and/or command-line flag to a config field
+type configBinding struct {
+	env   string
+	flag  string
+	usage string
+	apply func(c *Config, value string) error
+}
+
+// configBindings lists all environment variables and flags that override the config file
+var configBindings = []configBinding{
+	{"WEB_LISTEN", "listen", "listen address", func(c *Config, v string) error { return setString(&c.Server.Listen, v) }},
+	{"WEB_TIME_OUT_SECOND", "timeout", "request timeout in seconds", func(c *Config, v string) error { return setInt(&c.Server.RequestTimeoutSeconds, v) }},
+	{"WEB_SHUTDOWN_DRAIN_SECOND", "drain-timeout", "shutdown drain timeout in seconds", func(c *Config, v string) error { return setInt(&c.Server.DrainTimeoutSeconds, v) }},
+	{"WEB_SHUTDOWN_DELAY_SECOND", "shutdown-delay", "seconds readiness fails before listeners close on shutdown", func(c *Config, v string) error { return setInt(&c.Server.ShutdownDelaySeconds, v) }},
+	{"WEB_TLS_CERT_FILE", "tls-cert", "TLS certificate file, enables HTTPS", func(c *Config, v string) error { return setString(&c.Server.TLS.CertFile, v) }},
+	{"WEB_TLS_KEY_FILE", "tls-key", "TLS private key file", func(c *Config, v string) error { return setString(&c.Server.TLS.KeyFile, v) }},
+	{"WEB_TLS_MIN_VERSION", "tls-min-version", "minimum TLS version: 1.0, 1.1, 1.2, 1.3", func(c *Config, v string) error { return setString(&c.Server.TLS.MinVersion, v) }},
+	{"WEB_TLS_CIPHER_SUITES", "tls-cipher-suites", "comma separated TLS 1.2 cipher suites", func(c *Config, v string) error { return setList(&c.Server.TLS.CipherSuites, v) }},
+	{"WEB_TLS_CLIENT_CA_FILE", "tls-client-ca", "CA bundle verifying client certificates", func(c *Config, v string) error { return setString(&c.Server.TLS.ClientCAFile, v) }},
+	{"WEB_TLS_CLIENT_AUTH", "tls-client-auth", "client certificate mode: none, request, require", func(c *Config, v string) error { return setString(&c.Server.TLS.ClientAuth, v) }},
+	{"WEB_WEASYPRINT_BIN", "weasyprint-bin", "path to the weasyprint binary", func(c *Config, v string) error { return setString(&c.Render.WeasyPrintBin, v) }},
+	{"WEB_TEMP_DIR", "temp-dir", "root directory for temporary files", func(c *Config, v string) error { return setString(&c.Render.TempDir, v) }},
+	{"WEB_MAX_UPLOAD_MB", "max-upload-mb", "maximum request body size in MB", func(c *Config, v string) error { return setInt(&c.Render.MaxUploadMB, v) }},
+	{"WEB_MAX_CONCURRENT_RENDERS", "max-concurrent-renders", "maximum concurrent WeasyPrint processes", func(c *Config, v string) error { return setInt(&c.Render.MaxConcurrentRenders, v) }},
+	{"WEB_PAGE_SIZE", "page-size", "default page size", func(c *Config, v string) error { return setString(&c.Render.PageSize, v) }},
+	{"WEB_PAGE_MARGIN", "page-margin", "default page margin", func(c *Config, v string) error { return setString(&c.Render.PageMargin, v) }},
+	{"WEB_STRICT_OPTIONS", "strict-options", "reject requests with invalid WeasyPrint options: true, false", func(c *Config, v string) error { return setBool(&c.Render.StrictOptions, v) }},
+	{"WEB_MAX_QUEUED_RENDERS", "max-queued-renders", "queued renders at which readiness fails", func(c *Config, v string) error { return setInt(&c.Health.MaxQueuedRenders, v) }},
+	{"WEB_MIN_FREE_DISK_MB", "min-free-disk-mb", "minimum free temp dir space in MB for readiness", func(c *Config, v string) error { return setInt(&c.Health.MinFreeDiskMB, v) }},
+	{"WEB_DEEP_HEALTH_INTERVAL_SECOND", "deep-health-interval", "deep health check interval in seconds, 0 disables it", func(c *Config, v string) error { return setInt(&c.Health.DeepIntervalSeconds, v) }},
+	{"WEB_LOG_LEVEL", "log-level", "log level: debug, info, warn, error", func(c *Config, v string) error { return setString(&c.Log.Level, v) }},
+	{"WEB_LOG_FORMAT", "log-format", "log format: json, text", func(c *Config, v string) error { return setString(&c.Log.Format, v) }},
+	{"WEB_TRACE_EXPORTER", "trace-exporter", "trace exporter: otlp, file, empty disables tracing", func(c *Config, v string) error { return setString(&c.Trace.Exporter, v) }},
+	{"WEB_TRACE_FILE", "trace-file", "output file for the file trace exporter", func(c *Config, v string) error { return setString(&c.Trace.File, v) }},
+	{"WEB_OUTBOUND_TIMEOUT_SECOND", "outbound-timeout", "share and output upload timeout in seconds", func(c *Config, v string) error { return setInt(&c.Outbound.UploadTimeoutSeconds, v) }},
+	{"WEB_SHARE_MAX_RETRIES", "share-max-retries", "retries of failed share uploads, 0 disables them", func(c *Config, v string) error { return setInt(&c.Outbound.MaxRetries, v) }},
+	{"WEB_ALLOWED_HOSTS", "allowed-hosts", "comma separated hosts allowed for URL rendering", func(c *Config, v string) error { return setList(&c.Security.AllowedHosts, v) }},
+	{"WEB_ALLOWED_OUTPUT_HOSTS", "allowed-output-hosts", "comma separated hosts output.put_url may point to", func(c *Config, v string) error { return setList(&c.Security.AllowedOutputHosts, v) }},
+	{"WEB_ALLOW_PRIVATE_OUTPUT", "allow-private-output", "allow output.put_url to reach loopback, private and link-local addresses: true, false", func(c *Config, v string) error { return setBool(&c.Security.AllowPrivateOutput, v) }},
+	{"WEB_TRUSTED_PROXIES", "trusted-proxies", "comma separated proxy addresses or CIDR ranges whose forwarded client address is used", func(c *Config, v string) error { return setList(&c.Security.TrustedProxies, v) }},
+	{"WEB_RATE_LIMIT_PER_MINUTE", "rate-limit", "requests per minute per client, 0 disables it", func(c *Config, v string) error { return setInt(&c.Security.RateLimit.RequestsPerMinute, v) }},
+	{"WEB_ADMIN_KEY", "", "", func(c *Config, v string) error { return setString(&c.Security.AdminKey, v) }},
+	{"WEB_FILEIO_API_KEY", "", "", func(c *Config, v string) error { return setShareAPIKey(c, FileIO, v) }},
+	{"WEB_KITC_API_KEY", "", "", func(c *Config, v string) error { return setShareAPIKey(c, KITC, v) }},
+	{"WEB_CVSH_API_KEY", "", "", func(c *Config, v string) error { return setShareAPIKey(c, CVSH, v) }},
+}
+
+// defaultConfig returns the built-in configuration
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Listen:                DefaultPort,
+			RequestTimeoutSeconds: DefaultTimeoutSeconds,
+			DrainTimeoutSeconds:   DefaultDrainSeconds,
+			ShutdownDelaySeconds:  DefaultShutdownDelay,
+		},
+		Render: RenderConfig{
+			WeasyPrintBin:        DefaultWeasyPrintBin,
+			TempDir:              os.TempDir(),
+			MaxUploadMB:          DefaultMaxUploadMB,
+			MaxConcurrentRenders: runtime.NumCPU(),
+			PageSize:             DefaultPageSize,
+			PageMargin:           DefaultPageMargin,
+		},
+		Health: HealthConfig{
+			MaxQueuedRenders: DefaultMaxQueuedRenders,
+			MinFreeDiskMB:    DefaultMinFreeDiskMB,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: LogFormatJSON,
+		},
+		Trace: TraceConfig{
+			File: "traces.json",
+		},
+		Share: map[string]ShareServiceConfig{
+			string(FileIO): {Endpoint: "https://file.io"},
+			string(KITC):   {Endpoint: "https://ki.tc/file/u/"},
+			string(CVSH):   {Endpoint: "https://c-v.sh"},
+		},
+		Outbound: OutboundConfig{
+			ConnectTimeoutSeconds:  DefaultConnectTimeoutSeconds,
+			ResponseTimeoutSeconds: DefaultResponseTimeoutSeconds,
+			UploadTimeoutSeconds:   DefaultUploadTimeoutSeconds,
+			MaxRetries:             DefaultMaxRetries,
+			RetryBackoffMS:         DefaultRetryBackoffMS,
+			BreakerFailures:        DefaultBreakerFailures,
+			BreakerCooldownSeconds: DefaultBreakerCooldownSeconds,
+		},
+	}
+}
+
+// loadConfig builds the effective configuration from defaults, config file, environment and flags,
+// in increasing order of precedence. printConfig reports whether --print-config was given
+func loadConfig(args []string) (cfg *Config, printConfig bool, err error) {
+	fs := flag.NewFlagSet("rest-weasyprint", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("WEB_CONFIG"), "path to YAML or .toml config file (env WEB_CONFIG)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+
+	// Flag values are applied after the environment so they take precedence
+	var flagValues []func(c *Config) error
+	for _, binding := range configBindings {
+		if binding.flag == "" {
+			continue
+		}
+		binding := binding
+		fs.Func(binding.flag, fmt.Sprintf("%s (env %s)", binding.usage, binding.env), func(v string) error {
+			flagValues = append(flagValues, func(c *Config) error {
+				if err := binding.apply(c, v); err != nil {
+					return fmt.Errorf("invalid -%s: %v", binding.flag, err)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	cfg = defaultConfig()
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, binding := range configBindings {
+		if value := os.Getenv(binding.env); value != "" {
+			if err := binding.apply(cfg, value); err != nil {
+				return nil, false, fmt.Errorf("invalid %s: %v", binding.env, err)
+			}
+		}
+	}
+
+	for _, apply := range flagValues {
+		if err := apply(cfg); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, false, fmt.Errorf("invalid configuration: %v", err)
+	}
+	return cfg, printConfig, nil
+}
+
+// loadFile overlays the YAML config file at path
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	// TOML files are converted to YAML, so both formats use the yaml field names and reject unknown fields
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		var tree map[string]interface{}
+		if _, err := toml.Decode(string(data), &tree); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+		if data, err = yaml.Marshal(tree); err != nil {
+			return fmt.Errorf("failed to convert config file %s: %v", path, err)
+		}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration for errors
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Listen == "" {
+		errs = append(errs, errors.New("server.listen is required"))
+	}
+	if c.Server.RequestTimeoutSeconds <= 0 {
+		errs = append(errs, errors.New("server.request_timeout_seconds must be positive"))
+	}
+	if c.Server.DrainTimeoutSeconds <= 0 {
+		errs = append(errs, errors.New("server.drain_timeout_seconds must be positive"))
+	}
+	if c.Server.ShutdownDelaySeconds < 0 {
+		errs = append(errs, errors.New("server.shutdown_delay_seconds must not be negative"))
+	}
+	errs = append(errs, c.Server.TLS.validate()...)
+	for i, listener := range c.Server.Listeners {
+		errs = append(errs, listener.validate(i, c.Server.TLS.Enabled())...)
+	}
+
+	if c.Render.WeasyPrintBin == "" {
+		errs = append(errs, errors.New("render.weasyprint_bin is required"))
+	}
+	if info, err := os.Stat(c.Render.TempDir); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("render.temp_dir %q is not a directory", c.Render.TempDir))
+	}
+	if c.Render.MaxUploadMB <= 0 {
+		errs = append(errs, errors.New("render.max_upload_mb must be positive"))
+	}
+	if c.Render.MaxConcurrentRenders <= 0 {
+		errs = append(errs, errors.New("render.max_concurrent_renders must be positive"))
+	}
+	if c.Render.PageSize == "" || c.Render.PageMargin == "" {
+		errs = append(errs, errors.New("render.page_size and render.page_margin are required"))
+	}
+
+	if c.Health.MaxQueuedRenders <= 0 {
+		errs = append(errs, errors.New("health.max_queued_renders must be positive"))
+	}
+	if c.Health.MinFreeDiskMB < 0 || c.Health.DeepIntervalSeconds < 0 {
+		errs = append(errs, errors.New("health values must not be negative"))
+	}
+
+	if _, err := c.Log.slogLevel(); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %v", err))
+	}
+	if c.Log.Format != LogFormatJSON && c.Log.Format != LogFormatText {
+		errs = append(errs, fmt.Errorf("log.format must be %s or %s", LogFormatJSON, LogFormatText))
+	}
+
+	switch c.Trace.Exporter {
+	case TraceExporterNone, TraceExporterOTLP, TraceExporterFile:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported trace.exporter: %s", c.Trace.Exporter))
+	}
+
+	if _, err := newShareRegistry(c.Share); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Outbound.ConnectTimeoutSeconds <= 0 || c.Outbound.ResponseTimeoutSeconds <= 0 || c.Outbound.UploadTimeoutSeconds <= 0 {
+		errs = append(errs, errors.New("outbound timeouts must be positive"))
+	}
+	if c.Outbound.MaxRetries < 0 || c.Outbound.RetryBackoffMS < 0 || c.Outbound.BreakerFailures < 0 || c.Outbound.BreakerCooldownSeconds < 0 {
+		errs = append(errs, errors.New("outbound values must not be negative"))
+	}
+
+	for i, key := range c.Security.APIKeys {
+		if key.Key == "" {
+			errs = append(errs, fmt.Errorf("security.api_keys[%d].key is required", i))
+		}
+	}
+	for i, cert := range c.Security.ClientCerts {
+		if cert.CommonName == "" || cert.Tenant == "" {
+			errs = append(errs, fmt.Errorf("security.client_certs[%d] requires common_name and tenant", i))
+		}
+	}
+	for i, proxy := range c.Security.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("security.trusted_proxies[%d]: %q is not an address or CIDR range", i, proxy))
+			}
+		}
+	}
+	if c.Security.RateLimit.RequestsPerMinute < 0 || c.Security.RateLimit.Burst < 0 {
+		errs = append(errs, errors.New("security.rate_limit values must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// WriteYAML writes the configuration as YAML with secrets redacted
+func (c *Config) WriteYAML(w io.Writer) error {
+	redacted := *c
+	redacted.Share = make(map[string]ShareServiceConfig, len(c.Share))
+	for name, share := range c.Share {
+		if share.APIKey != "" {
+			share.APIKey = "REDACTED"
+		}
+		if share.S3.SecretAccessKey != "" {
+			share.S3.SecretAccessKey = "REDACTED"
+		}
+		if share.S3.SessionToken != "" {
+			share.S3.SessionToken = "REDACTED"
+		}
+		if share.Local.Password != "" {
+			share.Local.Password = "REDACTED"
+		}
+		if share.Local.SigningKey != "" {
+			share.Local.SigningKey = "REDACTED"
 		}
+		if share.SMTP.Password != "" {
+			share.SMTP.Password = "REDACTED"
+		}
+		if share.WebDAV.Password != "" {
+			share.WebDAV.Password = "REDACTED"
+		}
+		if share.SFTP.Password != "" {
+			share.SFTP.Password = "REDACTED"
+		}
+		if share.SFTP.PrivateKeyPassphrase != "" {
+			share.SFTP.PrivateKeyPassphrase = "REDACTED"
+		}
+		redacted.Share[name] = share
+	}
+	if redacted.Security.AdminKey != "" {
+		redacted.Security.AdminKey = "REDACTED"
 	}
-	return timeoutSeconds
+	redacted.Security.APIKeys = make([]APIKeyConfig, len(c.Security.APIKeys))
+	for i, key := range c.Security.APIKeys {
+		redacted.Security.APIKeys[i] = APIKeyConfig{Key: "REDACTED", Tenant: key.Tenant}
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&redacted); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// RequestTimeout returns the request timeout
+func (c *Config) RequestTimeout() time.Duration {
+	return time.Duration(c.Server.RequestTimeoutSeconds) * time.Second
+}
+
+// ShutdownDelay returns how long readiness fails before the listeners close on shutdown
+func (c *Config) ShutdownDelay() time.Duration {
+	return time.Duration(c.Server.ShutdownDelaySeconds) * time.Second
+}
+
+// DrainTimeout returns how long in-flight requests may run after a shutdown signal
+func (c *Config) DrainTimeout() time.Duration {
+	return time.Duration(c.Server.DrainTimeoutSeconds) * time.Second
+}
+
+// MaxUploadBytes returns the maximum request body size in bytes
+func (c *Config) MaxUploadBytes() int64 {
+	return int64(c.Render.MaxUploadMB) << 20
+}
+
+// slogLevel parses the configured log level
+func (l LogConfig) slogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(l.Level))
+	return level, err
+}
+
+// setString sets a string config value
+func setString(dst *string, value string) error {
+	*dst = strings.TrimSpace(value)
+	return nil
+}
+
+// setInt parses and sets an integer config value
+func setInt(dst *int, value string) error {
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", value)
+	}
+	*dst = parsed
+	return nil
+}
+
+// setBool parses and sets a boolean config value
+func setBool(dst *bool, value string) error {
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", value)
+	}
+	*dst = parsed
+	return nil
+}
+
+// setList parses and sets a comma separated config value
+func setList(dst *[]string, value string) error {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*dst = list
+	return nil
+}
+
+// setShareAPIKey sets the API key of a share service
+func setShareAPIKey(c *Config, service FileShareService, value string) error {
+	share := c.Share[string(service)]
+	share.APIKey = strings.TrimSpace(value)
+	c.Share[string(service)] = share
+	return nil
 }
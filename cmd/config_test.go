@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteYAMLRedactsSecrets(t *testing.T) {
+	config := defaultConfig()
+	config.Share = map[string]ShareServiceConfig{
+		"s3":     {Type: "s3", APIKey: "secret-share-api-key", S3: S3Config{SecretAccessKey: "secret-s3-access-key", SessionToken: "secret-s3-session-token"}},
+		"local":  {Type: "local", Local: LocalShareConfig{Password: "secret-local-password", SigningKey: "secret-local-signing-key"}},
+		"webdav": {Type: "webdav", WebDAV: WebDAVConfig{Password: "secret-webdav-password"}},
+		"sftp":   {Type: "sftp", SFTP: SFTPConfig{Password: "secret-sftp-password", PrivateKeyPassphrase: "secret-sftp-passphrase"}},
+		"mail":   {Type: "smtp", SMTP: SMTPConfig{Password: "secret-smtp-password"}},
+	}
+	config.Security.AdminKey = "secret-admin-key"
+	config.Security.APIKeys = []APIKeyConfig{{Key: "secret-api-key", Tenant: "acme"}}
+
+	var out bytes.Buffer
+	if err := config.WriteYAML(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "secret-") {
+			t.Errorf("secret printed: %s", strings.TrimSpace(line))
+		}
+	}
+	if got := strings.Count(out.String(), "REDACTED"); got != 11 {
+		t.Errorf("%d values redacted, want 11", got)
+	}
+	if !strings.Contains(out.String(), "tenant: acme") {
+		t.Errorf("API key tenant not printed")
+	}
+}
+
+// writeConfigFile writes a config file named name and returns its path
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := writeConfigFile(t, "config.yaml", "server:\n  listen: \":9000\"\n  request_timeout_seconds: 45\nrender:\n  page_size: Letter\n")
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		listen   string
+		timeout  int
+		pageSize string // Not overridden, kept from the file or the defaults
+	}{
+		{"defaults", nil, nil, DefaultPort, DefaultTimeoutSeconds, DefaultPageSize},
+		{"environment without file", map[string]string{"WEB_LISTEN": ":9100"}, nil, ":9100", DefaultTimeoutSeconds, DefaultPageSize},
+		{"file", nil, []string{"-config", file}, ":9000", 45, "Letter"},
+		{"file from WEB_CONFIG", map[string]string{"WEB_CONFIG": file}, nil, ":9000", 45, "Letter"},
+		{"environment over file", map[string]string{"WEB_LISTEN": ":9100"}, []string{"-config", file}, ":9100", 45, "Letter"},
+		{"flag over environment", map[string]string{"WEB_LISTEN": ":9100", "WEB_TIME_OUT_SECOND": "50"}, []string{"-config", file, "-listen", ":9200"}, ":9200", 50, "Letter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config, _, err := loadConfig(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Server.Listen != tt.listen || config.Server.RequestTimeoutSeconds != tt.timeout || config.Render.PageSize != tt.pageSize {
+				t.Errorf("listen %q timeout %d page size %q, want %q %d %q", config.Server.Listen, config.Server.RequestTimeoutSeconds, config.Render.PageSize, tt.listen, tt.timeout, tt.pageSize)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	yamlFile := writeConfigFile(t, "config.yaml", `
+server:
+  listen: ":9000"
+  listeners:
+    - address: "unix:/tmp/pdf.sock"
+      routes: [api, health]
+render:
+  default_options:
+    dpi: 150
+share:
+  reports:
+    type: s3
+    endpoint: https://s3.example.com
+    s3:
+      bucket: reports
+      region: eu-west-1
+      access_key_id: AKID
+      secret_access_key: secret
+security:
+  api_keys:
+    - key: k1
+      tenant: acme
+  trusted_proxies: [10.0.0.0/8]
+`)
+	tomlFile := writeConfigFile(t, "config.toml", `
+[server]
+listen = ":9000"
+
+[[server.listeners]]
+address = "unix:/tmp/pdf.sock"
+routes = ["api", "health"]
+
+[render.default_options]
+dpi = 150
+
+[share.reports]
+type = "s3"
+endpoint = "https://s3.example.com"
+
+[share.reports.s3]
+bucket = "reports"
+region = "eu-west-1"
+access_key_id = "AKID"
+secret_access_key = "secret"
+
+[security]
+trusted_proxies = ["10.0.0.0/8"]
+
+[[security.api_keys]]
+key = "k1"
+tenant = "acme"
+`)
+	fromYAML, _, err := loadConfig([]string{"-config", yamlFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromTOML, _, err := loadConfig([]string{"-config", tomlFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var yamlOut, tomlOut bytes.Buffer
+	fromYAML.WriteYAML(&yamlOut)
+	fromTOML.WriteYAML(&tomlOut)
+	if yamlOut.String() != tomlOut.String() {
+		t.Errorf("TOML config differs from YAML:\n%s\nwant\n%s", tomlOut.String(), yamlOut.String())
+	}
+	if fromTOML.Share["reports"].S3.Bucket != "reports" || len(fromTOML.Server.Listeners) != 1 {
+		t.Errorf("TOML config %+v", fromTOML)
+	}
+}
+
+func TestLoadConfigRejectsInvalidConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // Named config.toml when it starts with #toml
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{"unknown YAML field", "server:\n  listne: \":9000\"\n", nil, nil, "field listne not found"},
+		{"unknown TOML field", "#toml\n[server]\nlistne = \":9000\"\n", nil, nil, "field listne not found"},
+		{"invalid TOML", "#toml\n[server\n", nil, nil, "failed to parse config file"},
+		{"missing file", "", nil, []string{"-config", "/nonexistent/config.yaml"}, "failed to read config file"},
+		{"invalid environment value", "", map[string]string{"WEB_MAX_UPLOAD_MB": "lots"}, nil, "invalid WEB_MAX_UPLOAD_MB"},
+		{"invalid flag value", "", nil, []string{"-strict-options", "maybe"}, "invalid -strict-options"},
+		{"zero timeout", "server:\n  request_timeout_seconds: 0\n", nil, nil, "server.request_timeout_seconds must be positive"},
+		{"log format", "log:\n  format: xml\n", nil, nil, "log.format must be json or text"},
+		{"log level", "log:\n  level: loud\n", nil, nil, "log.level"},
+		{"trace exporter", "trace:\n  exporter: jaeger\n", nil, nil, "unsupported trace.exporter: jaeger"},
+		{"temp dir", "render:\n  temp_dir: /nonexistent\n", nil, nil, "render.temp_dir \"/nonexistent\" is not a directory"},
+		{"negative retries", "outbound:\n  max_retries: -1\n", nil, nil, "outbound values must not be negative"},
+		{"API key without key", "security:\n  api_keys:\n    - tenant: acme\n", nil, nil, "security.api_keys[0].key is required"},
+		{"client cert without tenant", "security:\n  client_certs:\n    - common_name: svc\n", nil, nil, "security.client_certs[0] requires common_name and tenant"},
+		{"trusted proxy", "security:\n  trusted_proxies: [proxy.internal]\n", nil, nil, "security.trusted_proxies[0]"},
+		{"share type", "share:\n  vault:\n    type: ftp\n", nil, nil, "ftp"},
+		{"several errors", "server:\n  drain_timeout_seconds: -1\nrender:\n  max_upload_mb: 0\n", nil, nil, "server.drain_timeout_seconds must be positive\nrender.max_upload_mb must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				name := "config.yaml"
+				if strings.HasPrefix(tt.file, "#toml") {
+					name = "config.toml"
+				}
+				args = append([]string{"-config", writeConfigFile(t, name, tt.file)}, args...)
+			}
+			_, _, err := loadConfig(args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -13,10 +15,12 @@ import (
 )
 
 // processUploadedFiles processes uploaded files
-func (s *PDFService) processUploadedFiles(r *http.Request, tempDir string) (*UploadedFileInfo, error) {
+func (s *PDFService) processUploadedFiles(r *http.Request, tempDir string) (fileInfo *UploadedFileInfo, err error) {
+	ctx, span := tracer.Start(r.Context(), "processUploadedFiles")
+	defer func() { endSpan(span, err) }()
+
 	form := r.MultipartForm
-	fileInfo := &UploadedFileInfo{
-		Options:      getDefaultOptions(),
+	fileInfo = &UploadedFileInfo{
 		Filename:     "document.pdf", // Default filename
 		ShareService: NoShare,        // Default no sharing
 	}
@@ -34,16 +38,16 @@ func (s *PDFService) processUploadedFiles(r *http.Request, tempDir string) (*Upl
 	}
 
 	// Get sharing service parameter
-	if shareServiceValue := r.URL.Query().Get("share_service"); len(shareServiceValue) > 0 {
-		shareService := strings.TrimSpace(shareServiceValue)
-		switch shareService {
-		case string(FileIO):
-			fileInfo.ShareService = FileIO
-		case string(KITC):
-			fileInfo.ShareService = KITC
-		case string(CVSH):
-			fileInfo.ShareService = CVSH
+	if shareService := strings.TrimSpace(r.URL.Query().Get("share_service")); shareService != "" {
+		if _, err := s.shareProvider(FileShareService(shareService)); err != nil {
+			return nil, err
 		}
+		fileInfo.ShareService = FileShareService(shareService)
+	}
+	var fallbacks []string
+	setList(&fallbacks, r.URL.Query().Get("share_fallbacks"))
+	if fileInfo.ShareTo, err = s.shareServices(fileInfo.ShareService, fallbacks); err != nil {
+		return nil, err
 	}
 
 	// Iterate through all file fields
@@ -51,7 +55,7 @@ func (s *PDFService) processUploadedFiles(r *http.Request, tempDir string) (*Upl
 		for _, fileHeader := range files {
 			filePath, err := s.saveUploadedFile(fileHeader, tempDir)
 			if err != nil {
-				return nil, fmt.Errorf("failed to save file: %v", err)
+				return nil, withProblemCode(CodeInternalError, fmt.Errorf("failed to save file: %v", err))
 			}
 
 			// Classify files by field name
@@ -60,7 +64,7 @@ func (s *PDFService) processUploadedFiles(r *http.Request, tempDir string) (*Upl
 				fileInfo.HTMLPath = filePath
 			case strings.HasPrefix(fieldName, "css."):
 				fileInfo.CSSPaths = append(fileInfo.CSSPaths, filePath)
-			case strings.HasPrefix(fieldName, "asset."):
+			case strings.HasPrefix(fieldName, "asset."), strings.HasPrefix(fieldName, "attachment."):
 				fileInfo.Attachments = append(fileInfo.Attachments, filePath)
 			}
 		}
@@ -70,22 +74,73 @@ func (s *PDFService) processUploadedFiles(r *http.Request, tempDir string) (*Upl
 	if optionsValues, exists := form.Value["options"]; exists && len(optionsValues) > 0 {
 		var optionsMap map[string]interface{}
 		if err := json.Unmarshal([]byte(optionsValues[0]), &optionsMap); err != nil {
-			s.logger.Printf("Failed to parse options: %v", err)
-			return nil, fmt.Errorf("invalid JSON format options")
+			s.logger.WarnContext(ctx, "Failed to parse options", "error", err)
+			return nil, withProblemCode(CodeInvalidJSON, errors.New("invalid JSON format options"))
+		}
+		fileInfo.Options, fileInfo.Ignored = s.validateOptions(ctx, optionsMap)
+	} else {
+		fileInfo.Options, _ = s.validateOptions(ctx, nil)
+	}
+	if strictValues := form.Value["strict_options"]; len(strictValues) > 0 {
+		fileInfo.StrictOptions = strictValues[0] == "true"
+	}
+
+	// Process output field
+	if outputValues := form.Value["output"]; len(outputValues) > 0 {
+		if fileInfo.ShareService != NoShare {
+			return nil, fmt.Errorf("output and share_service cannot be combined")
+		}
+		if fileInfo.Output, err = s.parseOutputTarget(outputValues[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Process email field
+	if emailValues := form.Value["email"]; len(emailValues) > 0 {
+		if fileInfo.Email, err = parseEmailMessage(emailValues[0]); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.validateEmail(ctx, fileInfo.ShareTo, fileInfo.Email, fileInfo.Filename); err != nil {
+		return nil, err
+	}
+
+	// Process share_options field
+	if optionsValues := form.Value["share_options"]; len(optionsValues) > 0 {
+		if fileInfo.ShareOptions, err = parseShareOptions(optionsValues[0]); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.validateShareOptions(fileInfo.ShareTo, fileInfo.ShareOptions); err != nil {
+		return nil, err
+	}
+	if fileInfo.ShareKey, err = s.newShareEncryption(fileInfo.ShareTo, r.URL.Query().Get("encrypt_share") == "true"); err != nil {
+		return nil, err
+	}
+
+	// Process outputs field
+	if outputsValues := form.Value["outputs"]; len(outputsValues) > 0 {
+		if fileInfo.Output != nil || len(fileInfo.ShareTo) > 0 || fileInfo.Email != nil || fileInfo.ShareOptions != nil || fileInfo.ShareKey != nil {
+			return nil, errOutputsCombined
+		}
+		if fileInfo.Outputs, err = parseOutputDestinations(outputsValues[0]); err != nil {
+			return nil, err
+		}
+		if err := s.validateOutputDestinations(ctx, fileInfo.Outputs, fileInfo.Filename); err != nil {
+			return nil, err
 		}
-		fileInfo.Options = s.validateOptions(optionsMap)
 	}
 
 	// Validate required files
 	if fileInfo.HTMLPath == "" {
-		return nil, fmt.Errorf("missing HTML file")
+		return nil, withProblemCode(CodeMissingHTML, errors.New("missing HTML file"))
 	}
 
 	// If no CSS files, create default one
 	if len(fileInfo.CSSPaths) == 0 {
 		defaultCSSPath, err := s.createDefaultCSS(tempDir)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create default CSS: %v", err)
+			return nil, withProblemCode(CodeInternalError, fmt.Errorf("failed to create default CSS: %v", err))
 		}
 		fileInfo.CSSPaths = append(fileInfo.CSSPaths, defaultCSSPath)
 	}
@@ -116,27 +171,57 @@ func (s *PDFService) saveUploadedFile(fileHeader *multipart.FileHeader, tempDir
 }
 
 // createTempDir creates temporary directory
-func (s *PDFService) createTempDir() (string, error) {
-	tempDir, err := os.MkdirTemp("", "pdfgen-*")
+func (s *PDFService) createTempDir(ctx context.Context) (string, error) {
+	tempDir, err := os.MkdirTemp(s.cfg().Render.TempDir, "pdfgen-*")
 	if err != nil {
 		return "", err
 	}
-	s.logger.Printf("Created temporary directory: %s", tempDir)
+	s.tempPaths.Store(tempDir, struct{}{})
+	s.logger.DebugContext(ctx, "Created temporary directory", "dir", tempDir)
 	return tempDir, nil
 }
 
 // cleanupTempDir cleans up temporary directory
-func (s *PDFService) cleanupTempDir(tempDir string) {
-	if err := os.RemoveAll(tempDir); err != nil {
-		s.logger.Printf("Failed to cleanup temporary directory: %v", err)
+func (s *PDFService) cleanupTempDir(ctx context.Context, tempDir string) {
+	if err := s.removeTemp(tempDir); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to cleanup temporary directory", "dir", tempDir, "error", err)
 	} else {
-		s.logger.Printf("Cleaned up temporary directory: %s", tempDir)
+		s.logger.DebugContext(ctx, "Cleaned up temporary directory", "dir", tempDir)
+	}
+}
+
+// createTempFile creates a temporary file that is removed on shutdown if still present
+func (s *PDFService) createTempFile(pattern string) (*os.File, error) {
+	file, err := os.CreateTemp(s.cfg().Render.TempDir, pattern)
+	if err != nil {
+		return nil, err
 	}
+	s.tempPaths.Store(file.Name(), struct{}{})
+	return file, nil
+}
+
+// removeTemp removes a temporary file or directory created by the service
+func (s *PDFService) removeTemp(path string) error {
+	s.tempPaths.Delete(path)
+	return os.RemoveAll(path)
+}
+
+// cleanupAllTemp removes all temporary files and directories still in use
+func (s *PDFService) cleanupAllTemp(ctx context.Context) {
+	s.tempPaths.Range(func(key, _ any) bool {
+		path := key.(string)
+		if err := s.removeTemp(path); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to remove temporary path", "path", path, "error", err)
+		} else {
+			s.logger.InfoContext(ctx, "Removed leftover temporary path", "path", path)
+		}
+		return true
+	})
 }
 
 // createDefaultCSS creates default CSS file
 func (s *PDFService) createDefaultCSS(tempDir string) (string, error) {
-	defaultCSS := fmt.Sprintf("@page { size: %s; margin: %s; }", DefaultPageSize, DefaultPageMargin)
+	defaultCSS := fmt.Sprintf("@page { size: %s; margin: %s; }", s.cfg().Render.PageSize, s.cfg().Render.PageMargin)
 	cssPath := filepath.Join(tempDir, "default.css")
 
 	if err := os.WriteFile(cssPath, []byte(defaultCSS), 0644); err != nil {
@@ -146,18 +231,39 @@ func (s *PDFService) createDefaultCSS(tempDir string) (string, error) {
 	return cssPath, nil
 }
 
-// setPDFHeaders sets PDF response headers
-func (s *PDFService) setPDFHeaders(w http.ResponseWriter, filename string) {
-	w.Header().Set("Content-Type", "application/pdf")
+// pdfResponseWriter streams a PDF as the response, setting its headers with the first bytes
+type pdfResponseWriter struct {
+	w        http.ResponseWriter
+	filename string
+	written  bool // Part of the PDF was sent
+}
+
+func (p *pdfResponseWriter) Write(b []byte) (int, error) {
+	if !p.written && len(b) > 0 {
+		p.w.Header().Set("Content-Type", "application/pdf")
+		p.w.Header().Set("Content-Disposition", pdfDisposition(p.filename))
+		p.written = true
+	}
+	return p.w.Write(b)
+}
+
+// fail answers a failed render with a problem. Once part of the PDF was sent the status cannot
+// change, so the connection is aborted instead and the client sees a truncated response
+func (p *pdfResponseWriter) fail(ctx context.Context, err error) {
+	if p.written {
+		panic(http.ErrAbortHandler)
+	}
+	writeRenderProblem(ctx, p.w, err)
+}
 
+// pdfDisposition returns the attachment Content-Disposition of a PDF named filename
+func pdfDisposition(filename string) string {
 	// Use url.PathEscape instead of url.QueryEscape
 	// PathEscape encodes spaces as %20, not +
 	encodedFilename := url.PathEscape(filename)
 
-	// Set Content-Disposition header
 	// filename= parameter for old browsers that don't support RFC 5987
 	// filename*= parameter for modern browsers that support UTF-8
-	disposition := fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s",
+	return fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s",
 		filename, encodedFilename)
-	w.Header().Set("Content-Disposition", disposition)
 }
@@ -1,99 +1,161 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 )
 
 // HandleFileUpload handles file upload and generates PDF
 func (s *PDFService) HandleFileUpload(w http.ResponseWriter, r *http.Request) {
+	defer s.beginRender()()
+
+	outcome := OutcomeClientError
+	defer func() { s.metrics.observeRender("file", outcome) }()
+
+	r = withRenderID(w, r)
+	ctx := r.Context()
+
+	if err := validateQueryParameters(r); err != nil {
+		writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
+		return
+	}
+
 	// Validate request type
 	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
-		http.Error(w, "multipart/form-data request required", http.StatusBadRequest)
+		writeProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, "multipart/form-data request required")
 		return
 	}
 
 	// Parse form
-	if err := r.ParseMultipartForm(MaxUploadSize); err != nil {
-		s.logger.Printf("Failed to parse form: %v", err)
-		http.Error(w, "Form parsing failed: "+err.Error(), http.StatusBadRequest)
+	start := time.Now()
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg().MaxUploadBytes())
+	if err := r.ParseMultipartForm(s.cfg().MaxUploadBytes()); err != nil {
+		s.logger.WarnContext(ctx, "Failed to parse form", "error", err)
+		writeBodyProblem(ctx, w, CodeInvalidUpload, "Form parsing failed", err)
+		return
+	}
+	s.metrics.observePhase(PhaseUploadParse, time.Since(start).Seconds())
+
+	if err := validateMultipartForm(r.MultipartForm, "FileUploadForm"); err != nil {
+		writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
 		return
 	}
 
 	// Create temporary directory
-	tempDir, err := s.createTempDir()
+	tempDir, err := s.createTempDir(ctx)
 	if err != nil {
-		s.logger.Printf("Failed to create temporary directory: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		s.logger.ErrorContext(ctx, "Failed to create temporary directory", "error", err)
+		writeProblem(ctx, w, http.StatusInternalServerError, CodeInternalError, "Internal server error")
+		outcome = OutcomeServerError
 		return
 	}
-	defer s.cleanupTempDir(tempDir)
+	defer s.cleanupTempDir(ctx, tempDir)
 
 	// Process uploaded files
+	start = time.Now()
 	fileInfo, err := s.processUploadedFiles(r, tempDir)
+	s.metrics.observePhase(PhaseTempWrite, time.Since(start).Seconds())
 	if err != nil {
-		s.logger.Printf("Failed to process uploaded files: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// If sharing is needed, use temporary file instead of writing directly to response
-	if fileInfo.ShareService != NoShare {
-		tempFile, err := os.CreateTemp("", "pdfshare-*.pdf")
-		if err != nil {
-			s.logger.Printf("Failed to create temporary PDF file: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		s.logger.WarnContext(ctx, "Failed to process uploaded files", "error", err)
+		if problemCode(err, "") == CodeInternalError {
+			writeProblem(ctx, w, http.StatusInternalServerError, CodeInternalError, "Internal server error")
+			outcome = OutcomeServerError
 			return
 		}
-		defer os.Remove(tempFile.Name())
+		writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
+		return
+	}
+	if err := s.checkIgnoredOptions(ctx, w, fileInfo.Ignored, s.strictOptions(r, fileInfo.StrictOptions)); err != nil {
+		writeErrorProblem(ctx, w, http.StatusUnprocessableEntity, CodeInvalidOption, err)
+		return
+	}
+	ctx = withEmailMessage(ctx, fileInfo.Email)
+	ctx = withShareOptions(ctx, fileInfo.ShareOptions)
+	ctx = withShareEncryption(ctx, fileInfo.ShareKey)
 
-		// Generate PDF to temporary file
-		if err := s.generatePDFFromFiles(r.Context(), tempFile, fileInfo); err != nil {
-			s.logger.Printf("PDF generation failed: %v", err)
-			http.Error(w, "PDF generation failed", http.StatusInternalServerError)
-			return
-		}
-		tempFile.Close()
+	// Deliver the PDF to every destination of outputs
+	if fileInfo.Outputs != nil {
+		outcome = s.renderToOutputs(ctx, w, fileInfo.Outputs, fileInfo.Filename, func(w io.Writer) error {
+			return s.generatePDFFromFiles(ctx, w, fileInfo)
+		})
+		return
+	}
 
-		// Upload to sharing service
-		response, err := s.uploadToShareService(tempFile.Name(), fileInfo.Filename, fileInfo.ShareService)
-		if err != nil {
-			s.logger.Printf("Failed to upload to sharing service: %v", err)
-			http.Error(w, "Failed to upload to sharing service: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Upload to the client's URL instead of returning the PDF
+	if fileInfo.Output != nil {
+		outcome = s.renderToOutput(ctx, w, fileInfo.Output, func(w io.Writer) error {
+			return s.generatePDFFromFiles(ctx, w, fileInfo)
+		})
+		return
+	}
 
-		// Return JSON response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	// Upload to the sharing service instead of returning the PDF
+	if len(fileInfo.ShareTo) > 0 {
+		outcome = s.renderToShare(ctx, w, fileInfo.ShareTo, fileInfo.Filename, func(ctx context.Context, w io.Writer) error {
+			return s.generatePDFFromFiles(ctx, w, fileInfo)
+		})
 		return
 	}
 
 	// Regular response, return PDF directly
-	s.setPDFHeaders(w, fileInfo.Filename)
-
-	// Generate PDF
-	if err := s.generatePDFFromFiles(r.Context(), w, fileInfo); err != nil {
-		s.logger.Printf("PDF generation failed: %v", err)
-		http.Error(w, "PDF generation failed", http.StatusInternalServerError)
+	pdf := &pdfResponseWriter{w: w, filename: fileInfo.Filename}
+	if err := s.generatePDFFromFiles(ctx, pdf, fileInfo); err != nil {
+		s.logger.ErrorContext(ctx, "PDF generation failed", "error", err, "partial", pdf.written)
+		outcome = OutcomeRenderError
+		pdf.fail(ctx, err)
 		return
 	}
+	outcome = OutcomeSuccess
 }
 
 // HandleHTMLRender handles HTML string rendering
 func (s *PDFService) HandleHTMLRender(w http.ResponseWriter, r *http.Request) {
+	defer s.beginRender()()
+
+	outcome := OutcomeClientError
+	defer func() { s.metrics.observeRender("html", outcome) }()
+
+	r = withRenderID(w, r)
+	ctx := r.Context()
+
+	if err := validateQueryParameters(r); err != nil {
+		writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
+		return
+	}
+
 	var htmlContent string
 	var filename string
 	var options *WeasyPrintOptions
 	var shareService FileShareService = NoShare
+	var shareFallbacks []string
+	var output *OutputTarget
+	var email *EmailMessage
+	var shareOptions *ShareOptions
+	encryptShare := r.URL.Query().Get("encrypt_share") == "true"
+	var outputs []OutputDestination
 
 	if r.Method == "POST" {
 		// Handle JSON request
 		var req HTMLRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "JSON format error: "+err.Error(), http.StatusBadRequest)
+		var body interface{}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg().MaxUploadBytes()))
+		if err == nil {
+			err = json.Unmarshal(data, &body)
+		}
+		if err != nil {
+			writeBodyProblem(ctx, w, CodeInvalidJSON, "JSON format error", err)
+			return
+		}
+		if err := validateJSONRequest(body, "HTMLRequest"); err != nil {
+			writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
+			return
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			writeProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, "invalid request: "+err.Error())
 			return
 		}
 		htmlContent = req.HTML
@@ -105,26 +167,27 @@ func (s *PDFService) HandleHTMLRender(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Process options
-		if req.Options != nil {
-			options = s.validateOptions(req.Options)
-		} else {
-			options = getDefaultOptions()
+		var ignored []string
+		options, ignored = s.validateOptions(ctx, req.Options)
+		if err := s.checkIgnoredOptions(ctx, w, ignored, s.strictOptions(r, req.StrictOptions)); err != nil {
+			writeErrorProblem(ctx, w, http.StatusUnprocessableEntity, CodeInvalidOption, err)
+			return
 		}
 
 		// Handle sharing service
-		shareServiceParam := req.ShareService
-		if shareServiceParam == "" {
-			shareServiceParam = r.URL.Query().Get("share_service")
+		shareService = FileShareService(req.ShareService)
+		if shareService == NoShare {
+			shareService = FileShareService(r.URL.Query().Get("share_service"))
 		}
-
-		switch shareServiceParam {
-		case string(FileIO):
-			shareService = FileIO
-		case string(KITC):
-			shareService = KITC
-		case string(CVSH):
-			shareService = CVSH
+		shareFallbacks = req.ShareFallbacks
+		if shareFallbacks == nil {
+			setList(&shareFallbacks, r.URL.Query().Get("share_fallbacks"))
 		}
+		output = req.Output
+		email = req.Email
+		shareOptions = req.ShareOptions
+		encryptShare = encryptShare || req.EncryptShare
+		outputs = req.Outputs
 
 	} else {
 		// GET request, return example
@@ -148,59 +211,99 @@ func (s *PDFService) HandleHTMLRender(w http.ResponseWriter, r *http.Request) {
 		if filename == "" {
 			filename = "test.pdf" // Default value
 		}
-		options = getDefaultOptions()
+		options, _ = s.validateOptions(ctx, nil)
 
 		// Handle sharing service
-		shareServiceParam := r.URL.Query().Get("share_service")
-		switch shareServiceParam {
-		case string(FileIO):
-			shareService = FileIO
-		case string(KITC):
-			shareService = KITC
-		case string(CVSH):
-			shareService = CVSH
-		}
+		shareService = FileShareService(r.URL.Query().Get("share_service"))
+		setList(&shareFallbacks, r.URL.Query().Get("share_fallbacks"))
 	}
 
-	// If sharing is needed, use temporary file instead of writing directly to response
-	if shareService != NoShare {
-		tempFile, err := os.CreateTemp("", "pdfshare-*.pdf")
-		if err != nil {
-			s.logger.Printf("Failed to create temporary PDF file: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if outputs != nil {
+		if output != nil || shareService != NoShare || len(shareFallbacks) > 0 || email != nil || shareOptions != nil || encryptShare {
+			writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, errOutputsCombined)
+			return
+		}
+		if err := s.validateOutputDestinations(ctx, outputs, filename); err != nil {
+			writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
 			return
 		}
-		defer os.Remove(tempFile.Name())
+	}
+
+	shareTo, err := s.shareServices(shareService, shareFallbacks)
+	if err != nil {
+		writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
+		return
+	}
+
+	if err := s.validateEmail(ctx, shareTo, email, filename); err != nil {
+		writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
+		return
+	}
+	ctx = withEmailMessage(ctx, email)
+
+	if err := s.validateShareOptions(shareTo, shareOptions); err != nil {
+		writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
+		return
+	}
+	ctx = withShareOptions(ctx, shareOptions)
 
-		// Generate PDF to temporary file
-		if err := s.generatePDFFromHTML(r.Context(), tempFile, htmlContent, options); err != nil {
-			s.logger.Printf("PDF generation failed: %v", err)
-			http.Error(w, "PDF generation failed", http.StatusInternalServerError)
+	shareKey, err := s.newShareEncryption(shareTo, encryptShare)
+	if err != nil {
+		writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
+		return
+	}
+	ctx = withShareEncryption(ctx, shareKey)
+
+	if output != nil {
+		if shareService != NoShare {
+			writeProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, "output and share_service cannot be combined")
+			return
+		}
+		if err := s.validateOutputTarget(output); err != nil {
+			writeErrorProblem(ctx, w, http.StatusBadRequest, CodeInvalidRequest, err)
 			return
 		}
-		tempFile.Close()
+	}
 
-		// Upload to sharing service
-		response, err := s.uploadToShareService(tempFile.Name(), filename, shareService)
-		if err != nil {
-			s.logger.Printf("Failed to upload to sharing service: %v", err)
-			http.Error(w, "Failed to upload to sharing service: "+err.Error(), http.StatusInternalServerError)
+	// Check remote URL against allowed hosts
+	if isRemoteURL(htmlContent) {
+		if err := checkAllowedURL(s.cfg().Security.AllowedHosts, htmlContent); err != nil {
+			writeProblem(ctx, w, http.StatusForbidden, CodeURLNotAllowed, "URL not allowed: "+err.Error())
 			return
 		}
+	}
 
-		// Return JSON response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	// Deliver the PDF to every destination of outputs
+	if outputs != nil {
+		outcome = s.renderToOutputs(ctx, w, outputs, filename, func(w io.Writer) error {
+			return s.generatePDFFromHTML(ctx, w, htmlContent, options)
+		})
 		return
 	}
 
-	// Regular response, return PDF directly
-	s.setPDFHeaders(w, filename)
+	// Upload to the client's URL instead of returning the PDF
+	if output != nil {
+		outcome = s.renderToOutput(ctx, w, output, func(w io.Writer) error {
+			return s.generatePDFFromHTML(ctx, w, htmlContent, options)
+		})
+		return
+	}
 
-	// Generate PDF
-	if err := s.generatePDFFromHTML(r.Context(), w, htmlContent, options); err != nil {
-		s.logger.Printf("PDF generation failed: %v", err)
-		http.Error(w, "PDF generation failed", http.StatusInternalServerError)
+	// Upload to the sharing service instead of returning the PDF
+	if len(shareTo) > 0 {
+		outcome = s.renderToShare(ctx, w, shareTo, filename, func(ctx context.Context, w io.Writer) error {
+			return s.generatePDFFromHTML(ctx, w, htmlContent, options)
+		})
+		return
+	}
+
+	// Regular response, return PDF directly
+	pdf := &pdfResponseWriter{w: w, filename: filename}
+	if err := s.generatePDFFromHTML(ctx, pdf, htmlContent, options); err != nil {
+		s.logger.ErrorContext(ctx, "PDF generation failed", "error", err, "partial", pdf.written)
+		outcome = OutcomeRenderError
+		pdf.fail(ctx, err)
 		return
 	}
+	outcome = OutcomeSuccess
 }
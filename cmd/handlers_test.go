@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleFileUploadStrictOptionsField(t *testing.T) {
+	s := newTestService(t, defaultConfig())
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	html, _ := form.CreateFormFile("html", "index.html")
+	html.Write([]byte("<h1>Hi</h1>"))
+	form.WriteField("options", `{"dpi": 2000}`)
+	form.WriteField("strict_options", "true")
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/pdf/render/file", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.HandleFileUpload(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity || !bytes.Contains(w.Body.Bytes(), []byte(CodeInvalidOption)) {
+		t.Errorf("status %d body %s, want 422 %s", w.Code, w.Body, CodeInvalidOption)
+	}
+}
+
+// fakeWeasyPrint writes an executable shell script standing in for weasyprint and returns its path
+func fakeWeasyPrint(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "weasyprint")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHandleHTMLRenderFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		status      int
+		contentType string
+		truncated   bool // Part of the PDF is written before the failure
+	}{
+		{"success", `printf '%%PDF-1.7 complete'`, http.StatusOK, "application/pdf", false},
+		{"fails before output", `echo "ERROR: boom" >&2; exit 1`, http.StatusInternalServerError, problemContentType, false},
+		{"fails mid-stream", `printf '%%PDF-1.7 partial'; exit 1`, 0, "", true},
+		{"times out", `exec sleep 5`, http.StatusGatewayTimeout, problemContentType, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.Render.WeasyPrintBin = fakeWeasyPrint(t, tt.script)
+			config.Server.RequestTimeoutSeconds = 1
+			s := newTestService(t, config)
+			router := setupRouter(s)
+			router.Post("/render", s.HandleHTMLRender)
+
+			var serverLog bytes.Buffer
+			server := httptest.NewUnstartedServer(router)
+			server.Config.ErrorLog = log.New(&serverLog, "", 0)
+			server.Start()
+			defer server.Close()
+
+			resp, err := http.Post(server.URL+"/render", "application/json", strings.NewReader(`{"html": "<h1>Hi</h1>"}`))
+			if tt.truncated {
+				// The connection is aborted, before or after the buffered headers reach the client
+				if err == nil {
+					_, err = io.ReadAll(resp.Body)
+					resp.Body.Close()
+				}
+				if err == nil {
+					t.Errorf("partial PDF was answered as a complete response")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.status || !strings.HasPrefix(resp.Header.Get("Content-Type"), tt.contentType) {
+				t.Errorf("status %d %s, want %d %s", resp.StatusCode, resp.Header.Get("Content-Type"), tt.status, tt.contentType)
+			}
+			if tt.status == http.StatusOK && !bytes.Equal(body, []byte("%PDF-1.7 complete")) {
+				t.Errorf("body %q", body)
+			}
+			server.Close()
+			if strings.Contains(serverLog.String(), "superfluous") {
+				t.Errorf("server log: %s", serverLog.String())
+			}
+		})
+	}
+}
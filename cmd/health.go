@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health check statuses
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+const (
+	weasyPrintCheckTTL     = 30 * time.Second // How long a successful WeasyPrint probe is reused
+	weasyPrintCheckTimeout = 10 * time.Second
+	deepCheckTimeout       = 60 * time.Second
+)
+
+// deepCheckHTML is rendered by the deep health check
+const deepCheckHTML = `<!DOCTYPE html><html><head><meta charset="UTF-8"></head><body><p>health</p></body></html>`
+
+// CheckResult represents the result of a single health check
+type CheckResult struct {
+	Status    string            `json:"status"`
+	Message   string            `json:"message,omitempty"`
+	Details   map[string]uint64 `json:"details,omitempty"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
+
+// HealthResponse represents the health endpoint response
+type HealthResponse struct {
+	Status string                  `json:"status"`
+	Checks map[string]*CheckResult `json:"checks,omitempty"`
+}
+
+// HealthChecker runs readiness checks against the PDF service
+type HealthChecker struct {
+	service          *PDFService
+	minFreeBytes     uint64
+	maxQueuedRenders int64
+	deepInterval     time.Duration
+
+	draining atomic.Bool // Set on shutdown, fails readiness
+
+	mu         sync.Mutex
+	weasyPrint *CheckResult // Cached WeasyPrint probe
+	deep       *CheckResult // Latest deep check, nil until the first run
+}
+
+// NewHealthChecker creates a health checker using the service configuration
+func NewHealthChecker(service *PDFService) *HealthChecker {
+	config := service.cfg().Health
+	return &HealthChecker{
+		service:          service,
+		minFreeBytes:     uint64(config.MinFreeDiskMB) << 20,
+		maxQueuedRenders: int64(config.MaxQueuedRenders),
+		deepInterval:     time.Duration(config.DeepIntervalSeconds) * time.Second,
+	}
+}
+
+// HandleLiveness reports that the process is alive
+func (h *HealthChecker) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, &HealthResponse{Status: HealthOK})
+}
+
+// HandleReadiness reports whether the service can accept renders
+func (h *HealthChecker) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		writeHealth(w, &HealthResponse{Status: HealthFail, Checks: map[string]*CheckResult{
+			"shutdown": {Status: HealthFail, Message: "service is draining", CheckedAt: time.Now()},
+		}})
+		return
+	}
+
+	checks := map[string]*CheckResult{
+		"weasyprint": h.checkWeasyPrint(r.Context()),
+		"temp_dir":   h.checkTempDir(),
+		"queue":      h.checkQueue(),
+	}
+	if deep := h.deepResult(); deep != nil {
+		checks["deep"] = deep
+	}
+
+	response := &HealthResponse{Status: HealthOK, Checks: checks}
+	for _, check := range checks {
+		if check.Status != HealthOK {
+			response.Status = HealthFail
+		}
+	}
+	writeHealth(w, response)
+}
+
+// SetDraining makes readiness fail while the service shuts down
+func (h *HealthChecker) SetDraining() {
+	h.draining.Store(true)
+}
+
+// HandleDeep returns the cached result of the scheduled test render
+func (h *HealthChecker) HandleDeep(w http.ResponseWriter, r *http.Request) {
+	deep := h.deepResult()
+	if deep == nil {
+		deep = &CheckResult{Status: HealthFail, Message: "deep check has not run", CheckedAt: time.Now()}
+		if h.deepInterval == 0 {
+			deep.Message = "deep check disabled"
+		}
+	}
+	writeHealth(w, &HealthResponse{Status: deep.Status, Checks: map[string]*CheckResult{"deep": deep}})
+}
+
+// RunDeepChecks performs a test render every interval until ctx is done, does nothing if disabled
+func (h *HealthChecker) RunDeepChecks(ctx context.Context) {
+	if h.deepInterval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(h.deepInterval)
+	defer ticker.Stop()
+
+	for {
+		h.runDeepCheck(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDeepCheck renders a tiny document and caches the result
+func (h *HealthChecker) runDeepCheck(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, deepCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	output := &countingWriter{w: io.Discard}
+	err := h.service.generatePDFFromHTML(ctx, output, deepCheckHTML, getDefaultOptions())
+
+	result := &CheckResult{Status: HealthOK, CheckedAt: time.Now()}
+	switch {
+	case err != nil:
+		result.Status = HealthFail
+		result.Message = err.Error()
+	case output.n == 0:
+		result.Status = HealthFail
+		result.Message = "test render produced no output"
+	default:
+		result.Details = map[string]uint64{
+			"duration_ms": uint64(time.Since(start).Milliseconds()),
+			"size_bytes":  uint64(output.n),
+		}
+	}
+	if result.Status != HealthOK {
+		h.service.logger.WarnContext(ctx, "Deep health check failed", "error", result.Message)
+	}
+
+	h.mu.Lock()
+	h.deep = result
+	h.mu.Unlock()
+}
+
+// deepResult returns the latest deep check result, nil if none
+func (h *HealthChecker) deepResult() *CheckResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.deep
+}
+
+// checkWeasyPrint verifies the weasyprint binary can be invoked, successful results are cached
+func (h *HealthChecker) checkWeasyPrint(ctx context.Context) *CheckResult {
+	h.mu.Lock()
+	cached := h.weasyPrint
+	h.mu.Unlock()
+	if cached != nil && cached.Status == HealthOK && time.Since(cached.CheckedAt) < weasyPrintCheckTTL {
+		return cached
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, weasyPrintCheckTimeout)
+	defer cancel()
+
+	result := &CheckResult{Status: HealthOK, CheckedAt: time.Now()}
+	if err := exec.CommandContext(ctx, h.service.cfg().Render.WeasyPrintBin, "--version").Run(); err != nil {
+		result.Status = HealthFail
+		result.Message = fmt.Sprintf("weasyprint not invocable: %v", err)
+	}
+
+	h.mu.Lock()
+	h.weasyPrint = result
+	h.mu.Unlock()
+	return result
+}
+
+// checkTempDir verifies the temp dir is writable and has enough free space
+func (h *HealthChecker) checkTempDir() *CheckResult {
+	result := &CheckResult{Status: HealthOK, CheckedAt: time.Now()}
+	tempDir := h.service.cfg().Render.TempDir
+
+	probe, err := os.CreateTemp(tempDir, "pdfgen-probe-*")
+	if err != nil {
+		result.Status = HealthFail
+		result.Message = fmt.Sprintf("temp dir not writable: %v", err)
+		return result
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	free, err := diskFreeBytes(tempDir)
+	if err != nil {
+		result.Status = HealthFail
+		result.Message = fmt.Sprintf("failed to get free space: %v", err)
+		return result
+	}
+
+	result.Details = map[string]uint64{"free_bytes": free, "min_free_bytes": h.minFreeBytes}
+	if free < h.minFreeBytes {
+		result.Status = HealthFail
+		result.Message = "insufficient free space in temp dir"
+	}
+	return result
+}
+
+// checkQueue verifies the render queue is not saturated
+func (h *HealthChecker) checkQueue() *CheckResult {
+	queued := h.service.queued.Load()
+	result := &CheckResult{
+		Status:    HealthOK,
+		Details:   map[string]uint64{"queued": uint64(queued), "max_queued": uint64(h.maxQueuedRenders)},
+		CheckedAt: time.Now(),
+	}
+	if queued >= h.maxQueuedRenders {
+		result.Status = HealthFail
+		result.Message = "render queue saturated"
+	}
+	return result
+}
+
+// writeHealth writes a health response, failing checks return 503
+func writeHealth(w http.ResponseWriter, response *HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status != HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// versionScript is a fake weasyprint answering --version and rendering a PDF otherwise
+const versionScript = `if [ "$1" = --version ]; then echo "WeasyPrint version 66.0"; exit; fi
+printf '%%PDF-1.7'`
+
+// checkHealth calls handler and decodes its health response
+func checkHealth(t *testing.T, handler http.HandlerFunc) (int, HealthResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var response HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, response
+}
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	config := defaultConfig()
+	config.Render.WeasyPrintBin = fakeWeasyPrint(t, versionScript)
+	config.Health.MinFreeDiskMB = 0
+	health := NewHealthChecker(newTestService(t, config))
+
+	status, response := checkHealth(t, health.HandleReadiness)
+	if status != http.StatusOK || response.Status != HealthOK {
+		t.Fatalf("status %d response %+v before draining", status, response)
+	}
+	for _, name := range []string{"weasyprint", "temp_dir", "queue"} {
+		if response.Checks[name] == nil || response.Checks[name].Status != HealthOK {
+			t.Errorf("check %s: %+v", name, response.Checks[name])
+		}
+	}
+
+	health.SetDraining()
+	status, response = checkHealth(t, health.HandleReadiness)
+	if status != http.StatusServiceUnavailable || response.Status != HealthFail || len(response.Checks) != 1 {
+		t.Fatalf("status %d response %+v while draining, want 503", status, response)
+	}
+	if shutdown := response.Checks["shutdown"]; shutdown == nil || shutdown.Status != HealthFail || shutdown.Message != "service is draining" {
+		t.Errorf("shutdown check %+v", shutdown)
+	}
+
+	// The process stays alive while it drains
+	if status, response := checkHealth(t, health.HandleLiveness); status != http.StatusOK || response.Status != HealthOK {
+		t.Errorf("liveness status %d response %+v while draining", status, response)
+	}
+}
+
+func TestReadinessFailsWithoutWeasyPrint(t *testing.T) {
+	config := defaultConfig()
+	config.Render.WeasyPrintBin = fakeWeasyPrint(t, "exit 1")
+	config.Health.MinFreeDiskMB = 0
+	status, response := checkHealth(t, NewHealthChecker(newTestService(t, config)).HandleReadiness)
+	if status != http.StatusServiceUnavailable || response.Checks["weasyprint"].Status != HealthFail {
+		t.Errorf("status %d response %+v, want a failed weasyprint check", status, response)
+	}
+}
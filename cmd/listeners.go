@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Route groups a listener can serve
+const (
+	RoutesAPI     = "api"     // Render API under /api/v1/pdf
+	RoutesHealth  = "health"  // Status, liveness and readiness checks
+	RoutesMetrics = "metrics" // Prometheus metrics
+	RoutesAdmin   = "admin"   // Admin endpoints
+	RoutesDocs    = "docs"    // OpenAPI document and docs page
+	RoutesShare   = "share"   // Downloads of locally shared PDFs under /s/ and the /decrypt page
+)
+
+// allRouteGroups lists the route groups served by listeners without explicit routes
+var allRouteGroups = []string{RoutesAPI, RoutesHealth, RoutesMetrics, RoutesAdmin, RoutesDocs, RoutesShare}
+
+// unixAddressPrefix marks listener addresses that are Unix socket paths
+const unixAddressPrefix = "unix:"
+
+// Listeners returns the configured listeners, defaulting to server.listen serving all routes
+func (c *Config) Listeners() []ListenerConfig {
+	if len(c.Server.Listeners) > 0 {
+		return c.Server.Listeners
+	}
+	return []ListenerConfig{{Address: c.Server.Listen, TLS: c.Server.TLS.Enabled()}}
+}
+
+// validate checks the listener settings for errors, i is its position in server.listeners
+func (l ListenerConfig) validate(i int, tlsEnabled bool) []error {
+	var errs []error
+	if l.Address == "" || l.Address == unixAddressPrefix {
+		errs = append(errs, fmt.Errorf("server.listeners[%d].address is required", i))
+	}
+	if l.SocketMode != "" {
+		if _, isUnix := l.socketPath(); !isUnix {
+			errs = append(errs, fmt.Errorf("server.listeners[%d].socket_mode requires a unix: address", i))
+		} else if _, err := l.fileMode(); err != nil {
+			errs = append(errs, fmt.Errorf("server.listeners[%d].socket_mode: %v", i, err))
+		}
+	}
+	for _, group := range l.Routes {
+		if !slices.Contains(allRouteGroups, group) {
+			errs = append(errs, fmt.Errorf("server.listeners[%d]: unknown route group %q, use one of %s", i, group, strings.Join(allRouteGroups, ", ")))
+		}
+	}
+	if l.TLS && !tlsEnabled {
+		errs = append(errs, fmt.Errorf("server.listeners[%d].tls requires server.tls.cert_file", i))
+	}
+	return errs
+}
+
+// RouteGroups returns the route groups the listener serves
+func (l ListenerConfig) RouteGroups() []string {
+	if len(l.Routes) == 0 {
+		return allRouteGroups
+	}
+	return l.Routes
+}
+
+// socketPath returns the Unix socket path of the listener, if it is one
+func (l ListenerConfig) socketPath() (string, bool) {
+	return strings.CutPrefix(l.Address, unixAddressPrefix)
+}
+
+// fileMode parses the octal socket permissions
+func (l ListenerConfig) fileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(l.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal permission mode", l.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listen opens the listener's TCP address or Unix socket.
+// Stale socket files left by a previous process are replaced
+func (l ListenerConfig) listen() (net.Listener, error) {
+	var listener net.Listener
+	if path, isUnix := l.socketPath(); isUnix {
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		var err error
+		listener, err = net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if l.SocketMode != "" {
+			mode, _ := l.fileMode() // Validated by loadConfig
+			if err := os.Chmod(path, mode); err != nil {
+				listener.Close()
+				return nil, fmt.Errorf("failed to set socket permissions: %v", err)
+			}
+		}
+	} else {
+		var err error
+		listener, err = net.Listen("tcp", l.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// newListenerServer creates the HTTP server of a listener serving only its route groups,
+// tlsConfig is used by TLS listeners
+func newListenerServer(l ListenerConfig, tlsConfig *tls.Config, service *PDFService, health *HealthChecker, reloader *ConfigReloader, requestCtx context.Context) *http.Server {
+	router := setupRouter(service)
+	registerRoutes(router, service, health, reloader, l.RouteGroups())
+	server := &http.Server{
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
+	}
+	if l.TLS {
+		server.TLSConfig = tlsConfig
+	}
+	return server
+}
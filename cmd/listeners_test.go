@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListenerRouteGroups(t *testing.T) {
+	config := defaultConfig()
+	config.Security.AdminKey = "adm"
+	config.Render.WeasyPrintBin = fakeWeasyPrint(t, "echo WeasyPrint version 66.0")
+	s := newTestService(t, config)
+	health := NewHealthChecker(s)
+	reloader := NewConfigReloader(nil, s, &slog.LevelVar{})
+
+	// Status of a request to each route without credentials when its group is served
+	routes := []struct {
+		method, path, group string
+		status              int
+	}{
+		{http.MethodGet, "/api/v1/pdf/version", RoutesAPI, http.StatusOK},
+		{http.MethodGet, "/api/v1/share/providers", RoutesAPI, http.StatusOK},
+		{http.MethodGet, "/healthz", RoutesHealth, http.StatusOK},
+		{http.MethodGet, "/metrics", RoutesMetrics, http.StatusOK},
+		{http.MethodPost, "/admin/reload", RoutesAdmin, http.StatusUnauthorized},
+		{http.MethodGet, "/openapi.json", RoutesDocs, http.StatusOK},
+		{http.MethodGet, "/docs/assets/swagger-ui.css", RoutesDocs, http.StatusOK},
+		{http.MethodGet, "/decrypt", RoutesShare, http.StatusOK},
+	}
+	listeners := []ListenerConfig{
+		{Address: "127.0.0.1:0", Routes: []string{RoutesAPI}},
+		{Address: "127.0.0.1:0", Routes: []string{RoutesHealth, RoutesMetrics}},
+		{Address: "127.0.0.1:0", Routes: []string{RoutesAdmin}},
+		{Address: "127.0.0.1:0", Routes: []string{RoutesDocs, RoutesShare}},
+		{Address: "127.0.0.1:0"},
+	}
+	for _, l := range listeners {
+		server := newListenerServer(l, nil, s, health, reloader, context.Background())
+		groups := l.RouteGroups()
+		for _, route := range routes {
+			w := httptest.NewRecorder()
+			server.Handler.ServeHTTP(w, httptest.NewRequest(route.method, route.path, nil))
+			want := http.StatusNotFound
+			if slices.Contains(groups, route.group) {
+				want = route.status
+			}
+			if w.Code != want {
+				t.Errorf("listener %v: %s %s answered %d, want %d", l.Routes, route.method, route.path, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestListenerOpenAPIListsServedRoutes(t *testing.T) {
+	s := newTestService(t, defaultConfig())
+	server := newListenerServer(ListenerConfig{Routes: []string{RoutesAPI, RoutesDocs}}, nil, s, NewHealthChecker(s), NewConfigReloader(nil, s, &slog.LevelVar{}), context.Background())
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var doc struct {
+		Paths map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Paths["/api/v1/pdf/render/html"] == nil {
+		t.Errorf("API route missing from %v", doc.Paths)
+	}
+	for _, path := range []string{"/admin/reload", "/metrics", "/healthz", localShareRoute} {
+		if doc.Paths[path] != nil {
+			t.Errorf("%s documented on a listener not serving it", path)
+		}
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pdf.sock")
+
+	// A socket left behind by a crashed process
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("stale socket missing: %v", err)
+	}
+
+	l := ListenerConfig{Address: unixAddressPrefix + path, SocketMode: "0660"}
+	listener, err := l.listen()
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode %v, %v, want 0660", info.Mode().Perm(), err)
+	}
+
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }))
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Other files are never removed
+	file := filepath.Join(t.TempDir(), "data.sock")
+	os.WriteFile(file, []byte("data"), 0o600)
+	if listener, err := (ListenerConfig{Address: unixAddressPrefix + file}).listen(); err == nil {
+		listener.Close()
+		t.Errorf("regular file replaced by a socket")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "data" {
+		t.Errorf("regular file modified")
+	}
+}
+
+func TestListenerConfigValidate(t *testing.T) {
+	tests := []struct {
+		listener   ListenerConfig
+		tlsEnabled bool
+		wantErr    string
+	}{
+		{ListenerConfig{Address: ":8080", Routes: []string{RoutesAPI, RoutesHealth}}, false, ""},
+		{ListenerConfig{Address: "unix:/run/pdf.sock", SocketMode: "0660"}, false, ""},
+		{ListenerConfig{Address: ":8443", TLS: true}, true, ""},
+		{ListenerConfig{Address: "unix:"}, false, "address is required"},
+		{ListenerConfig{Address: ":8080", SocketMode: "0660"}, false, "socket_mode requires a unix: address"},
+		{ListenerConfig{Address: "unix:/run/pdf.sock", SocketMode: "rw"}, false, "not an octal permission mode"},
+		{ListenerConfig{Address: ":8080", Routes: []string{"internal"}}, false, `unknown route group "internal"`},
+		{ListenerConfig{Address: ":8443", TLS: true}, false, "tls requires server.tls.cert_file"},
+	}
+	for _, tt := range tests {
+		errs := tt.listener.validate(0, tt.tlsEnabled)
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		switch {
+		case tt.wantErr == "" && len(errs) > 0:
+			t.Errorf("%+v: unexpected errors %q", tt.listener, got)
+		case tt.wantErr != "" && !strings.Contains(strings.Join(got, "\n"), tt.wantErr):
+			t.Errorf("%+v: errors %q, want %q", tt.listener, got, tt.wantErr)
+		}
+	}
+}
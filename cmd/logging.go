@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Supported log formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// Log attribute keys whose values are never written verbatim
+var redactedLogKeys = map[string]bool{
+	"html": true,
+	"body": true,
+}
+
+// Query parameter names (lowercase) that carry secrets
+var secretQueryParams = map[string]bool{
+	"token": true, "access_token": true, "api_key": true, "apikey": true, "key": true,
+	"secret": true, "password": true, "passwd": true, "auth": true, "signature": true,
+	"sig": true, "x-amz-signature": true, "x-amz-credential": true, "x-amz-security-token": true,
+}
+
+// logAttrsKey is the context key for request scoped log attributes
+type logAttrsKey struct{}
+
+// renderIDKey is the context key for the render ID
+type renderIDKey struct{}
+
+// requestBaseURLKey is the context key for the scheme and host the request reached
+type requestBaseURLKey struct{}
+
+// newLogger creates the service logger with the given level and format
+func newLogger(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch format {
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	case LogFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+// contextHandler adds request scoped attributes stored in the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// withLogAttrs returns a context whose log lines carry attrs in addition to existing ones
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(existing)+len(attrs))
+	merged = append(merged, existing...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, merged)
+}
+
+// withRenderID assigns a new render ID to the request, logs and response headers carry it
+func withRenderID(w http.ResponseWriter, r *http.Request) *http.Request {
+	renderID := newRenderID()
+	w.Header().Set("X-Render-ID", renderID)
+	ctx := context.WithValue(r.Context(), renderIDKey{}, renderID)
+	return r.WithContext(withLogAttrs(ctx, slog.String("render_id", renderID)))
+}
+
+// renderIDFromContext returns the render ID of the request, empty outside renders
+func renderIDFromContext(ctx context.Context) string {
+	renderID, _ := ctx.Value(renderIDKey{}).(string)
+	return renderID
+}
+
+// newRenderID generates a random render identifier
+func newRenderID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestContextMiddleware attaches the request ID to the request's log context and records its base URL.
+// It runs before realIPMiddleware, so the forwarded host is only trusted from a trusted proxy peer
+func (s *PDFService) requestContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withLogAttrs(r.Context(), slog.String("request_id", middleware.GetReqID(r.Context())))
+		forwarded := isTrustedProxy(s.cfg().Security.TrustedProxies, clientIP(r))
+		ctx = context.WithValue(ctx, requestBaseURLKey{}, requestBaseURL(r, forwarded))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestBaseURL returns the scheme and host the client used, honouring X-Forwarded-Proto and
+// X-Forwarded-Host when forwarded is set
+func requestBaseURL(r *http.Request, forwarded bool) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if forwarded {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+			host, _, _ = strings.Cut(forwardedHost, ",")
+		}
+	}
+	return scheme + "://" + strings.TrimSpace(host)
+}
+
+// requestBaseURLFromContext returns the base URL of the request, empty outside requests
+func requestBaseURLFromContext(ctx context.Context) string {
+	baseURL, _ := ctx.Value(requestBaseURLKey{}).(string)
+	return baseURL
+}
+
+// accessLogMiddleware writes one structured log line per request
+func accessLogMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			defer func() {
+				logger.InfoContext(r.Context(), "Request completed",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"query", redactQuery(r.URL.Query()),
+					"status", ww.Status(),
+					"bytes", ww.BytesWritten(),
+					"duration", time.Since(start),
+					"remote", r.RemoteAddr,
+				)
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// redactAttr hides sensitive attribute values before they are written
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if redactedLogKeys[a.Key] {
+		if a.Value.Kind() == slog.KindString {
+			return slog.String(a.Key, fmt.Sprintf("[REDACTED %d bytes]", len(a.Value.String())))
+		}
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	return a
+}
+
+// redactURL removes credentials and secret query parameters from a URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return raw
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redactQuery(u.Query())
+	}
+	return u.String()
+}
+
+// redactQuery encodes query values with secret parameters masked
+func redactQuery(values url.Values) string {
+	for name := range values {
+		if secretQueryParams[strings.ToLower(name)] {
+			values[name] = []string{"REDACTED"}
+		}
+	}
+	return values.Encode()
+}
+
+// redactArgs redacts URLs appearing in command arguments
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.Contains(arg, "://") {
+			redacted[i] = redactURL(arg)
+		} else {
+			redacted[i] = arg
+		}
+	}
+	return redacted
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -11,54 +19,240 @@ import (
 )
 
 func main() {
-	logger := log.New(os.Stdout, "PDF-SERVICE: ", log.LstdFlags|log.Lshortfile)
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// runServe starts the HTTP server and blocks until it is shut down, returning the exit code
+func runServe(args []string) int {
+	config, printConfig, err := loadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if printConfig {
+		if err := config.WriteYAML(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	level, _ := config.Log.slogLevel() // Validated by loadConfig
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(level)
+	logger, err := newLogger(os.Stdout, logLevel, config.Log.Format)
+	if err != nil {
+		slog.Error("Logger setup failed", "error", err)
+		return 1
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := setupTracing(context.Background(), config.Trace)
+	if err != nil {
+		logger.Error("Tracing setup failed", "error", err)
+		return 1
+	}
+	defer shutdownTracing(context.Background())
+
+	metrics := NewMetrics(config.Render.TempDir)
+	pdfService, err := NewPDFService(config, logger, metrics)
+	if err != nil {
+		logger.Error("Service setup failed", "error", err)
+		return 1
+	}
+
+	// Stop on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	health := NewHealthChecker(pdfService)
+	go health.RunDeepChecks(ctx)
+	go pdfService.RunShareJanitor(ctx)
+
+	// Reload configuration on SIGHUP
+	reloader := NewConfigReloader(args, pdfService, logLevel)
+	go reloader.WatchSignals(ctx)
+
+	var tlsConfig *tls.Config
+	if config.Server.TLS.Enabled() {
+		tlsConfig, err = newTLSConfig(config.Server.TLS, logger)
+		if err != nil {
+			logger.Error("TLS setup failed", "error", err)
+			return 1
+		}
+	}
+
+	// Request contexts derive from requestCtx so remaining renders can be cancelled after draining
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	// Start one server per listener, each serving its own route groups
+	var servers []*http.Server
+	serverErr := make(chan error, len(config.Listeners()))
+	for _, listenerConfig := range config.Listeners() {
+		listener, err := listenerConfig.listen()
+		if err != nil {
+			logger.Error("Server startup failed", "addr", listenerConfig.Address, "error", err)
+			return 1
+		}
+		server := newListenerServer(listenerConfig, tlsConfig, pdfService, health, reloader, requestCtx)
+		servers = append(servers, server)
+
+		logger.Info("Server started", "addr", listenerConfig.Address, "routes", listenerConfig.RouteGroups(), "tls", listenerConfig.TLS, "timeout", config.RequestTimeout())
+		go func() {
+			if server.TLSConfig != nil {
+				serverErr <- server.ServeTLS(listener, "", "") // Certificates come from TLSConfig
+				return
+			}
+			serverErr <- server.Serve(listener)
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		logger.Error("Server failed", "error", err)
+		return 1
+	case <-ctx.Done():
+	}
 
-	router := setupRouter()
-	pdfService := NewPDFService(logger)
+	gracefulShutdown(servers, pdfService, health, cancelRequests)
+	return 0
+}
+
+// gracefulShutdown fails readiness and keeps serving for the shutdown delay so load balancers stop
+// sending requests, waits for in-flight requests up to the drain timeout, then cancels remaining
+// renders and removes leftover temporary files
+func gracefulShutdown(servers []*http.Server, service *PDFService, health *HealthChecker, cancelRequests context.CancelFunc) {
+	drainTimeout := service.cfg().DrainTimeout()
+	service.logger.Info("Shutting down, draining in-flight requests", "drain_timeout", drainTimeout)
+	health.SetDraining()
+	if delay := service.cfg().ShutdownDelay(); delay > 0 {
+		service.logger.Info("Failing readiness before closing listeners", "shutdown_delay", delay)
+		time.Sleep(delay)
+	}
 
-	// Register routes
-	registerRoutes(router, pdfService)
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
 
-	// Get current timeout setting
-	timeoutSeconds := getTimeoutFromEnv()
+	// Drain all listeners concurrently
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			errs <- server.Shutdown(drainCtx)
+		}()
+	}
+	var drainErr error
+	for range servers {
+		if err := <-errs; err != nil {
+			drainErr = err
+		}
+	}
 
-	logger.Printf("Server started, listening on port %s, request timeout %d seconds", DefaultPort, timeoutSeconds)
-	if err := http.ListenAndServe(DefaultPort, router); err != nil {
-		logger.Fatalf("Server startup failed: %v", err)
+	rendersDone := true
+	if drainErr != nil {
+		service.logger.Warn("Drain timeout exceeded, cancelling remaining renders", "error", drainErr)
+		cancelRequests()
+		for _, server := range servers {
+			server.Close()
+		}
+		rendersDone = service.waitForRenders(weasyPrintWaitDelay + time.Second)
 	}
+
+	// WeasyPrint processes of renders still running may read their temporary files
+	if rendersDone {
+		service.cleanupAllTemp(context.Background())
+	} else {
+		service.logger.Warn("Renders still running after cancellation, keeping temporary files")
+	}
+	service.logger.Info("Server stopped")
 }
 
 // setupRouter configures router middleware
-func setupRouter() *chi.Mux {
+func setupRouter(service *PDFService) *chi.Mux {
 	router := chi.NewRouter()
 
-	// Get timeout setting from environment variables
-	timeoutSeconds := getTimeoutFromEnv()
-	timeout := time.Duration(timeoutSeconds) * time.Second
-
 	// Add middleware
+	router.Use(tracingMiddleware)
 	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(service.requestContextMiddleware)
+	router.Use(service.realIPMiddleware)
+	router.Use(accessLogMiddleware(service.logger))
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.Timeout(timeout))
+	router.Use(timeoutMiddleware(service.cfg().RequestTimeout()))
+	router.NotFound(notFoundHandler)
+	router.MethodNotAllowed(methodNotAllowedHandler)
 
 	return router
 }
 
-// registerRoutes registers all routes
-func registerRoutes(router *chi.Mux, service *PDFService) {
-	// Health check
-	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"status": "ok", "message": "PDF generation service is running"}`))
-	})
-
-	// API route group
-	router.Route("/api/v1/pdf", func(r chi.Router) {
-		r.Post("/render/file", service.HandleFileUpload) // File upload rendering
-		r.Post("/render/html", service.HandleHTMLRender) // HTML string rendering
-		r.Get("/render/html", service.HandleHTMLRender)  // GET test interface
-		r.Get("/version", versionHandler)                // Version information
-	})
+// timeoutMiddleware cancels the request context after timeout. Unlike middleware.Timeout it only
+// answers 504 when the handler wrote no response, so a sent problem or PDF is not followed by a second status
+func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			if ctx.Err() == context.DeadlineExceeded && ww.Status() == 0 {
+				writeProblem(ctx, ww, http.StatusGatewayTimeout, CodeRenderTimeout, "request did not finish in time")
+			}
+		})
+	}
+}
+
+// registerRoutes registers the routes of the given route groups
+func registerRoutes(router *chi.Mux, service *PDFService, health *HealthChecker, reloader *ConfigReloader, groups []string) {
+	if slices.Contains(groups, RoutesHealth) {
+		// Health check
+		router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"status": "ok", "message": "PDF generation service is running"}`))
+		})
+		router.Get("/healthz", health.HandleLiveness)  // Process alive
+		router.Get("/readyz", health.HandleReadiness)  // Ready to accept renders
+		router.Get("/healthz/deep", health.HandleDeep) // Cached scheduled test render
+	}
+
+	if slices.Contains(groups, RoutesMetrics) {
+		// Prometheus metrics
+		router.Method(http.MethodGet, "/metrics", service.metrics.Handler())
+	}
+
+	if slices.Contains(groups, RoutesAdmin) {
+		// Admin endpoints, require the admin key
+		router.Post("/admin/reload", reloader.HandleReload)
+	}
+
+	if slices.Contains(groups, RoutesAPI) {
+		// API route group
+		router.Route("/api/v1/pdf", func(r chi.Router) {
+			r.Use(service.authMiddleware)
+			r.Post("/render/file", service.HandleFileUpload) // File upload rendering
+			r.Post("/render/html", service.HandleHTMLRender) // HTML string rendering
+			r.Get("/render/html", service.HandleHTMLRender)  // GET test interface
+			r.Get("/version", service.versionHandler)        // Version information
+		})
+		router.Route("/api/v1/share", func(r chi.Router) {
+			r.Use(service.authMiddleware)
+			r.Get("/providers", service.HandleShareProviders) // Configured share providers
+		})
+	}
+
+	if slices.Contains(groups, RoutesShare) {
+		// Locally shared PDFs, the signed token authorizes the download
+		router.Get(localShareRoute, service.HandleShareDownload)
+		router.Head(localShareRoute, service.HandleShareDownload)
+		router.Get(decryptRoute, decryptHandler) // Decrypts encrypted shares in the browser
+	}
+
+	if slices.Contains(groups, RoutesDocs) {
+		// API documentation of the routes registered above
+		router.Get("/openapi.json", openAPIHandler(router))
+		router.Get("/docs", docsHandler)
+		router.Method(http.MethodGet, "/docs/assets/*", docsAssetsHandler()) // Vendored Swagger UI
+	}
 }
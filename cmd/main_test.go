@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shutdownTestServer serves every route group of a service rendering with script, returning the
+// server, its URL and the cancel function of request contexts
+func shutdownTestServer(t *testing.T, script string, delay, drain int) (*PDFService, *HealthChecker, *http.Server, string, context.CancelFunc) {
+	t.Helper()
+	config := defaultConfig()
+	config.Render.WeasyPrintBin = fakeWeasyPrint(t, `if [ "$1" = --version ]; then echo "WeasyPrint version 66.0"; exit; fi
+`+script)
+	config.Health.MinFreeDiskMB = 0
+	config.Server.ShutdownDelaySeconds = delay
+	config.Server.DrainTimeoutSeconds = drain
+	s := newTestService(t, config)
+	health := NewHealthChecker(s)
+
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	t.Cleanup(cancelRequests)
+	server := newListenerServer(ListenerConfig{}, nil, s, health, NewConfigReloader(nil, s, &slog.LevelVar{}), requestCtx)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return s, health, server, "http://" + listener.Addr().String(), cancelRequests
+}
+
+// renderResult is the outcome of a render request sent during shutdown
+type renderResult struct {
+	status int
+	body   string
+	err    error
+}
+
+// startRender posts a render to url and returns the channel receiving its result
+func startRender(url string) <-chan renderResult {
+	result := make(chan renderResult, 1)
+	go func() {
+		resp, err := http.Post(url+"/api/v1/pdf/render/html", "application/json", strings.NewReader(`{"html": "<h1>Hi</h1>"}`))
+		if err != nil {
+			result <- renderResult{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		result <- renderResult{resp.StatusCode, string(body), err}
+	}()
+	return result
+}
+
+// waitForRender waits until s runs a render
+func waitForRender(t *testing.T, s *PDFService) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(s.renderSlots) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("render did not start")
+		}
+	}
+}
+
+// tempEntries returns the entries left in the temp dir of s
+func tempEntries(s *PDFService) []string {
+	entries, _ := os.ReadDir(s.cfg().Render.TempDir)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+func TestGracefulShutdownDrainsInFlightRenders(t *testing.T) {
+	s, health, server, url, cancelRequests := shutdownTestServer(t, `sleep 2; printf '%%PDF-1.7 drained'`, 1, 10)
+	render := startRender(url)
+	waitForRender(t, s)
+
+	shutdown := make(chan struct{})
+	start := time.Now()
+	go func() {
+		gracefulShutdown([]*http.Server{server}, s, health, cancelRequests)
+		close(shutdown)
+	}()
+
+	// During the shutdown delay readiness fails while the listener still serves requests
+	time.Sleep(200 * time.Millisecond)
+	resp, err := http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatalf("listener closed during the shutdown delay: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readiness status %d during the shutdown delay, want 503", resp.StatusCode)
+	}
+
+	// The in-flight render completes instead of being cancelled
+	<-shutdown
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("shutdown returned after %v, before the shutdown delay", elapsed)
+	}
+	select {
+	case result := <-render:
+		if result.err != nil || result.status != http.StatusOK || result.body != "%PDF-1.7 drained" {
+			t.Errorf("drained render: status %d body %q err %v", result.status, result.body, result.err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no response to the in-flight render")
+	}
+	if _, err := http.Get(url + "/healthz"); err == nil {
+		t.Errorf("listener still open after shutdown")
+	}
+	if entries := tempEntries(s); len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
+
+func TestGracefulShutdownCancelsRendersAfterDrainTimeout(t *testing.T) {
+	s, health, server, url, cancelRequests := shutdownTestServer(t, `exec sleep 30`, 0, 1)
+	render := startRender(url)
+	waitForRender(t, s)
+
+	start := time.Now()
+	gracefulShutdown([]*http.Server{server}, s, health, cancelRequests)
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("shutdown took %v, want the 1s drain timeout and the render cancelled", elapsed)
+	}
+	if len(s.renderSlots) != 0 {
+		t.Errorf("render still running after shutdown")
+	}
+	if result := <-render; result.err == nil && result.status == http.StatusOK {
+		t.Errorf("cancelled render answered %d %q", result.status, result.body)
+	}
+	if entries := tempEntries(s); len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
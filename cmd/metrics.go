@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Render outcomes used as metric labels
+const (
+	OutcomeSuccess     = "success"
+	OutcomeClientError = "client_error"
+	OutcomeRenderError = "render_error"
+	OutcomeShareError  = "share_error"
+	OutcomeOutputError = "output_error"
+	OutcomeServerError = "server_error"
+)
+
+// Render phases used as metric labels
+const (
+	PhaseUploadParse = "upload_parse"
+	PhaseTempWrite   = "temp_write"
+	PhaseWeasyPrint  = "weasyprint"
+	PhaseShare       = "share"
+	PhaseOutput      = "output"
+)
+
+// Temporary file name prefixes created by the service
+var tempFilePrefixes = []string{"pdfgen-", "pdfshare-", "pdfoutput-"}
+
+// Metrics holds Prometheus collectors for the PDF service
+type Metrics struct {
+	registry *prometheus.Registry
+
+	renders         *prometheus.CounterVec
+	phaseDuration   *prometheus.HistogramVec
+	rendersInFlight prometheus.Gauge
+	rendersQueued   prometheus.Gauge
+	outputSize      prometheus.Histogram
+	exitCodes       *prometheus.CounterVec
+	shareUploads    *prometheus.CounterVec
+	shareRetries    *prometheus.CounterVec
+}
+
+// NewMetrics creates and registers all service metrics, tempDir is the root of service temporary files
+func NewMetrics(tempDir string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		renders: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pdf_renders_total",
+			Help: "Total number of render requests by endpoint and outcome.",
+		}, []string{"endpoint", "outcome"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pdf_render_phase_duration_seconds",
+			Help:    "Duration of each render phase in seconds.",
+			Buckets: []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"phase"}),
+		rendersInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "pdf_renders_in_flight",
+			Help: "Number of WeasyPrint processes currently running.",
+		}),
+		rendersQueued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "pdf_renders_queued",
+			Help: "Number of renders waiting for a free WeasyPrint slot.",
+		}),
+		outputSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "pdf_output_size_bytes",
+			Help:    "Size of generated PDF documents in bytes.",
+			Buckets: prometheus.ExponentialBuckets(16<<10, 4, 8), // 16KB .. 256MB
+		}),
+		exitCodes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weasyprint_exit_codes_total",
+			Help: "WeasyPrint process exit codes.",
+		}, []string{"code"}),
+		shareUploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pdf_share_uploads_total",
+			Help: "Share service uploads by service and outcome.",
+		}, []string{"service", "outcome"}),
+		shareRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pdf_share_retries_total",
+			Help: "Share service upload retries by service.",
+		}, []string{"service"}),
+	}
+
+	tempUsage := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pdf_temp_dir_usage_bytes",
+		Help: "Disk space used by the service's temporary files.",
+	}, func() float64 {
+		return float64(tempDirUsage(tempDir))
+	})
+
+	m.registry.MustRegister(
+		m.renders,
+		m.phaseDuration,
+		m.rendersInFlight,
+		m.rendersQueued,
+		m.outputSize,
+		m.exitCodes,
+		m.shareUploads,
+		m.shareRetries,
+		tempUsage,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler returns the HTTP handler exposing metrics in Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeRender records the outcome of a render request
+func (m *Metrics) observeRender(endpoint, outcome string) {
+	m.renders.WithLabelValues(endpoint, outcome).Inc()
+}
+
+// observePhase records how long a render phase took
+func (m *Metrics) observePhase(phase string, seconds float64) {
+	m.phaseDuration.WithLabelValues(phase).Observe(seconds)
+}
+
+// observeExitCode records a WeasyPrint exit code
+func (m *Metrics) observeExitCode(code int) {
+	m.exitCodes.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+// observeShare records the outcome of a share service upload
+func (m *Metrics) observeShare(service FileShareService, err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = "failure"
+	}
+	m.shareUploads.WithLabelValues(string(service), outcome).Inc()
+}
+
+// observeShareRetry records a retried share service upload
+func (m *Metrics) observeShareRetry(service FileShareService) {
+	m.shareRetries.WithLabelValues(string(service)).Inc()
+}
+
+// observeShareRejected records an upload skipped because the service's circuit is open
+func (m *Metrics) observeShareRejected(service FileShareService) {
+	m.shareUploads.WithLabelValues(string(service), "circuit_open").Inc()
+}
+
+// tempDirUsage sums the size of service temporary files under root
+func tempDirUsage(root string) int64 {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return 0
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if !hasTempPrefix(entry.Name()) {
+			continue
+		}
+		filepath.WalkDir(filepath.Join(root, entry.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, err := d.Info(); err == nil && !d.IsDir() {
+				total += info.Size()
+			}
+			return nil
+		})
+	}
+	return total
+}
+
+// hasTempPrefix reports whether name was created by the service
+func hasTempPrefix(name string) bool {
+	for _, prefix := range tempFilePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// countingWriter counts bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
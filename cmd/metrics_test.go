@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the metrics of s in Prometheus text format
+func scrapeMetrics(s *PDFService) string {
+	w := httptest.NewRecorder()
+	s.metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestMetricsLabels(t *testing.T) {
+	s := newTestService(t, defaultConfig())
+	render := func(script, body string) {
+		config := *s.cfg()
+		config.Render.WeasyPrintBin = fakeWeasyPrint(t, script)
+		s.config.Store(&config)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/pdf/render/html", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		s.HandleHTMLRender(httptest.NewRecorder(), r)
+	}
+	render(`printf '%%PDF-1.7'`, `{"html": "<h1>Hi</h1>"}`)
+	render(`printf '%%PDF-1.7'`, `{"html": "<h1>Hi</h1>"}`)
+	render(`exit 3`, `{"html": "<h1>Hi</h1>"}`)
+	render(`printf '%%PDF-1.7'`, `{"options": {}}`)
+
+	s.metrics.observeShare("fileio", nil)
+	s.metrics.observeShare("fileio", errors.New("upload failed"))
+	s.metrics.observeShareRetry("fileio")
+	s.metrics.observeShareRejected("s3")
+
+	metrics := scrapeMetrics(s)
+	for _, want := range []string{
+		`pdf_renders_total{endpoint="html",outcome="success"} 2`,
+		`pdf_renders_total{endpoint="html",outcome="render_error"} 1`,
+		`pdf_renders_total{endpoint="html",outcome="client_error"} 1`,
+		`pdf_render_phase_duration_seconds_count{phase="weasyprint"} 3`,
+		`pdf_render_phase_duration_seconds_count{phase="temp_write"} 3`,
+		`weasyprint_exit_codes_total{code="0"} 2`,
+		`weasyprint_exit_codes_total{code="3"} 1`,
+		`pdf_output_size_bytes_count 2`,
+		`pdf_share_uploads_total{outcome="success",service="fileio"} 1`,
+		`pdf_share_uploads_total{outcome="failure",service="fileio"} 1`,
+		`pdf_share_uploads_total{outcome="circuit_open",service="s3"} 1`,
+		`pdf_share_retries_total{service="fileio"} 1`,
+		`pdf_renders_in_flight 0`,
+		`pdf_renders_queued 0`,
+	} {
+		if !strings.Contains(metrics, want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+	if t.Failed() {
+		t.Log(metrics)
+	}
+}
+
+func TestTempDirUsageMetric(t *testing.T) {
+	s := newTestService(t, defaultConfig())
+	root := s.cfg().Render.TempDir
+	os.MkdirAll(filepath.Join(root, "pdfgen-1", "assets"), 0o700)
+	os.WriteFile(filepath.Join(root, "pdfgen-1", "index.html"), make([]byte, 100), 0o600)
+	os.WriteFile(filepath.Join(root, "pdfgen-1", "assets", "logo.png"), make([]byte, 20), 0o600)
+	os.WriteFile(filepath.Join(root, "pdfshare-2"), make([]byte, 3), 0o600)
+	os.WriteFile(filepath.Join(root, "unrelated"), make([]byte, 1000), 0o600) // Not created by the service
+
+	if metrics := scrapeMetrics(s); !strings.Contains(metrics, "pdf_temp_dir_usage_bytes 123\n") {
+		t.Errorf("temp dir usage not 123 bytes:\n%s", metrics)
+	}
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// swaggerUI holds the vendored Swagger UI assets of the docs page
+//
+//go:embed swagger-ui/swagger-ui.css swagger-ui/swagger-ui-bundle.js
+var swaggerUI embed.FS
+
+// Schema is an OpenAPI 3.1 (JSON Schema) schema
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	PatternProperties    map[string]*Schema `json:"patternProperties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ContentMediaType     string             `json:"contentMediaType,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"` // Documents responses, requests are not validated against it
+
+	lenient bool // Only the type is validated, values are checked by the handler
+}
+
+// OpenAPI document types
+type (
+	OpenAPIDocument struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       OpenAPIInfo                             `json:"info"`
+		Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+		Components OpenAPIComponents                       `json:"components"`
+	}
+	OpenAPIInfo struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description,omitempty"`
+	}
+	OpenAPIComponents struct {
+		Schemas         map[string]*Schema                `json:"schemas"`
+		SecuritySchemes map[string]map[string]interface{} `json:"securitySchemes"`
+	}
+	OpenAPIOperation struct {
+		Summary     string                      `json:"summary"`
+		Description string                      `json:"description,omitempty"`
+		Tags        []string                    `json:"tags,omitempty"`
+		Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+		RequestBody *OpenAPIBody                `json:"requestBody,omitempty"`
+		Responses   map[string]*OpenAPIResponse `json:"responses"`
+		Security    []map[string][]string       `json:"security,omitempty"`
+	}
+	OpenAPIParameter struct {
+		Name        string  `json:"name"`
+		In          string  `json:"in"`
+		Description string  `json:"description,omitempty"`
+		Required    bool    `json:"required,omitempty"`
+		Schema      *Schema `json:"schema"`
+	}
+	OpenAPIBody struct {
+		Required bool                        `json:"required,omitempty"`
+		Content  map[string]OpenAPIMediaType `json:"content"`
+	}
+	OpenAPIResponse struct {
+		Description string                      `json:"description"`
+		Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+	}
+	OpenAPIMediaType struct {
+		Schema *Schema `json:"schema"`
+	}
+)
+
+// schemaEnums resolves enum=name struct tags to their allowed values
+var schemaEnums = map[string]func() []interface{}{
+	"pdfVariants": func() []interface{} {
+		values := make([]interface{}, len(pdfVariants))
+		for i, variant := range pdfVariants {
+			values[i] = variant
+		}
+		return values
+	},
+	"qrCodeFormats": func() []interface{} {
+		values := make([]interface{}, len(qrCodeFormats))
+		for i, format := range qrCodeFormats {
+			values[i] = format
+		}
+		return values
+	},
+	"destinationTypes": func() []interface{} {
+		values := make([]interface{}, len(destinationTypes))
+		for i, destination := range destinationTypes {
+			values[i] = destination
+		}
+		return values
+	},
+}
+
+// apiSchemas holds component schemas generated from the API types
+var apiSchemas = sync.OnceValue(func() map[string]*Schema {
+	g := &schemaGenerator{components: make(map[string]*Schema)}
+	for _, t := range []reflect.Type{
+		reflect.TypeFor[HTMLRequest](),
+		reflect.TypeFor[WeasyPrintOptions](),
+		reflect.TypeFor[ShareResponse](),
+		reflect.TypeFor[OutputResult](),
+		reflect.TypeFor[OutputsResult](),
+		reflect.TypeFor[HealthResponse](),
+		reflect.TypeFor[ShareProvidersResponse](),
+		reflect.TypeFor[Problem](),
+	} {
+		g.schemaFor(t)
+	}
+
+	// Multipart fields are matched by name prefix, so the form is not a Go type
+	g.components["FileUploadForm"] = &Schema{
+		Type:        "object",
+		Description: "HTML file with optional stylesheets and assets",
+		Properties: map[string]*Schema{
+			"html":           {Type: "string", Format: "binary", Description: "HTML file to render"},
+			"options":        {Type: "string", ContentMediaType: "application/json", Description: "WeasyPrintOptions as JSON"},
+			"output":         {Type: "string", ContentMediaType: "application/json", Description: "OutputTarget as JSON"},
+			"email":          {Type: "string", ContentMediaType: "application/json", Description: "EmailMessage as JSON, required by smtp share providers"},
+			"share_options":  {Type: "string", ContentMediaType: "application/json", Description: "ShareOptions as JSON"},
+			"outputs":        {Type: "string", ContentMediaType: "application/json", Description: "OutputDestination array as JSON"},
+			"strict_options": {Type: "string", Enum: []interface{}{"true", "false"}, Description: "Reject the request with 422 instead of ignoring invalid options"},
+		},
+		PatternProperties: map[string]*Schema{
+			`^css\.`:        {Type: "string", Format: "binary", Description: "Stylesheet, a default page stylesheet is used when none is uploaded"},
+			`^asset\.`:      {Type: "string", Format: "binary", Description: "Image, font or other resource referenced by the HTML"},
+			`^attachment\.`: {Type: "string", Format: "binary", Description: "File attached to the PDF"},
+		},
+		Required: []string{"html"},
+	}
+	return g.components
+})
+
+// renderQueryParameters are the query parameters of the render endpoints
+var renderQueryParameters = []OpenAPIParameter{
+	{Name: "filename", In: "query", Description: "Filename of the PDF", Schema: &Schema{Type: "string"}},
+	{Name: "share_service", In: "query", Description: "Upload the PDF to a share provider listed by /api/v1/share/providers and return a link instead", Schema: &Schema{Type: "string"}},
+	{Name: "share_fallbacks", In: "query", Description: "Comma separated share providers tried in order when share_service fails or is unavailable", Schema: &Schema{Type: "string"}},
+	{Name: "encrypt_share", In: "query", Description: "Encrypt the PDF with AES-256-GCM before uploading it, the link opens a decrypt page with the key in its fragment", Schema: &Schema{Type: "string", Enum: []interface{}{"true", "false"}}},
+	{Name: "strict_options", In: "query", Description: "Reject the request with 422 instead of ignoring invalid options, ignored options are listed in the X-Ignored-Options header otherwise", Schema: &Schema{Type: "string", Enum: []interface{}{"true", "false"}}},
+}
+
+// renderResponses are the responses of the render endpoints
+var renderResponses = map[string]*OpenAPIResponse{
+	"200": {Description: "The PDF, a share link when share_service is set, the upload result when output is set, or the summary of outputs", Content: map[string]OpenAPIMediaType{
+		"application/pdf": {Schema: &Schema{Type: "string", Format: "binary"}},
+		"application/json": {Schema: &Schema{OneOf: []*Schema{
+			{Ref: "#/components/schemas/ShareResponse"},
+			{Ref: "#/components/schemas/OutputResult"},
+			{Ref: "#/components/schemas/OutputsResult"},
+		}}},
+		"multipart/mixed": {Schema: &Schema{Type: "string", Format: "binary", Description: "OutputsResult followed by the PDF when outputs has a response destination"}},
+	}},
+	"207": {Description: "Summary of outputs when some destinations failed", Content: map[string]OpenAPIMediaType{
+		"application/json": {Schema: &Schema{Ref: "#/components/schemas/OutputsResult"}},
+		"multipart/mixed":  {Schema: &Schema{Type: "string", Format: "binary"}},
+	}},
+	"400": problemResponse("Invalid request"),
+	"401": problemResponse("Missing or invalid API key"),
+	"403": problemResponse("URL not allowed"),
+	"413": problemResponse("Request body too large"),
+	"422": problemResponse("Invalid options in strict mode"),
+	"429": problemResponse("Rate limit exceeded"),
+	"500": problemResponse("Rendering failed"),
+	"502": problemResponse("Fetching a resource or uploading the PDF failed"),
+	"503": problemResponse("Share providers unavailable"),
+	"504": problemResponse("Rendering did not finish in time"),
+}
+
+// apiOperations documents routes by "METHOD pattern", routes without an entry get a minimal description
+var apiOperations = map[string]OpenAPIOperation{
+	"POST /api/v1/pdf/render/html": {
+		Summary:     "Render HTML or a URL",
+		Tags:        []string{"render"},
+		Parameters:  renderQueryParameters,
+		RequestBody: &OpenAPIBody{Required: true, Content: map[string]OpenAPIMediaType{"application/json": {Schema: &Schema{Ref: "#/components/schemas/HTMLRequest"}}}},
+		Responses:   renderResponses,
+	},
+	"GET /api/v1/pdf/render/html": {
+		Summary:    "Render a test document",
+		Tags:       []string{"render"},
+		Parameters: renderQueryParameters,
+		Responses:  renderResponses,
+	},
+	"POST /api/v1/pdf/render/file": {
+		Summary:     "Render uploaded files",
+		Description: "Fields named css.* are stylesheets, asset.* are resources referenced by the HTML and attachment.* are attached to the PDF.",
+		Tags:        []string{"render"},
+		Parameters:  renderQueryParameters,
+		RequestBody: &OpenAPIBody{Required: true, Content: map[string]OpenAPIMediaType{"multipart/form-data": {Schema: &Schema{Ref: "#/components/schemas/FileUploadForm"}}}},
+		Responses:   renderResponses,
+	},
+	"GET /api/v1/pdf/version": {
+		Summary: "Service and WeasyPrint versions",
+		Tags:    []string{"info"},
+		Responses: map[string]*OpenAPIResponse{
+			"200": {Description: "Version information", Content: map[string]OpenAPIMediaType{"application/json": {Schema: &Schema{Type: "object", AdditionalProperties: &Schema{Type: "string"}}}}},
+		},
+	},
+	"GET /api/v1/share/providers": {
+		Summary: "List share providers",
+		Tags:    []string{"share"},
+		Responses: map[string]*OpenAPIResponse{
+			"200": {Description: "Configured share providers", Content: map[string]OpenAPIMediaType{"application/json": {Schema: &Schema{Ref: "#/components/schemas/ShareProvidersResponse"}}}},
+		},
+	},
+	"GET " + localShareRoute:  shareDownloadOperation("Download a locally shared PDF"),
+	"HEAD " + localShareRoute: shareDownloadOperation("Check a locally shared PDF"),
+	"GET " + decryptRoute: {
+		Summary:     "Decrypt page of encrypted shares",
+		Description: "Fetches the ciphertext and decrypts it in the browser with the key in the link fragment, falling back to a file picker.",
+		Tags:        []string{"share"},
+		Responses:   map[string]*OpenAPIResponse{"200": {Description: "HTML page", Content: map[string]OpenAPIMediaType{"text/html": {Schema: &Schema{Type: "string"}}}}},
+	},
+	"GET /": {
+		Summary:   "Service status",
+		Tags:      []string{"health"},
+		Responses: map[string]*OpenAPIResponse{"200": {Description: "Service is running"}},
+	},
+	"GET /healthz":      healthOperation("Liveness check"),
+	"GET /readyz":       healthOperation("Readiness check"),
+	"GET /healthz/deep": healthOperation("Result of the scheduled test render"),
+	"GET /metrics": {
+		Summary:   "Prometheus metrics",
+		Tags:      []string{"operations"},
+		Responses: map[string]*OpenAPIResponse{"200": textResponse("Metrics in Prometheus text format")},
+	},
+	"POST /admin/reload": {
+		Summary: "Reload configuration",
+		Tags:    []string{"operations"},
+		Responses: map[string]*OpenAPIResponse{
+			"200": {Description: "Configuration reloaded"},
+			"401": problemResponse("Missing or invalid admin key"),
+			"422": {Description: "Invalid configuration, the current one is kept"},
+		},
+		Security: []map[string][]string{{"apiKey": {}}, {"bearer": {}}},
+	},
+}
+
+// textResponse describes a plain text response
+func textResponse(description string) *OpenAPIResponse {
+	return &OpenAPIResponse{Description: description, Content: map[string]OpenAPIMediaType{"text/plain": {Schema: &Schema{Type: "string"}}}}
+}
+
+// problemResponse describes a problem details error response
+func problemResponse(description string) *OpenAPIResponse {
+	return &OpenAPIResponse{Description: description, Content: map[string]OpenAPIMediaType{problemContentType: {Schema: &Schema{Ref: "#/components/schemas/Problem"}}}}
+}
+
+// shareDownloadOperation describes the download route of local share links
+func shareDownloadOperation(summary string) OpenAPIOperation {
+	return OpenAPIOperation{
+		Summary:     summary,
+		Description: "Supports Range and If-None-Match. Password protected shares take the password as HTTP basic auth password.",
+		Tags:        []string{"share"},
+		Parameters:  []OpenAPIParameter{{Name: "token", In: "path", Required: true, Description: "Signed token of the share link", Schema: &Schema{Type: "string"}}},
+		Responses: map[string]*OpenAPIResponse{
+			"200": {Description: "The PDF", Content: map[string]OpenAPIMediaType{"application/pdf": {Schema: &Schema{Type: "string", Format: "binary"}}}},
+			"206": {Description: "Requested range of the PDF"},
+			"304": {Description: "Not modified"},
+			"401": problemResponse("Password required"),
+			"404": problemResponse("Unknown share"),
+			"410": problemResponse("Share expired or download limit reached"),
+		},
+		Security: []map[string][]string{{"basic": {}}, {}},
+	}
+}
+
+// healthOperation describes a health endpoint
+func healthOperation(summary string) OpenAPIOperation {
+	health := map[string]OpenAPIMediaType{"application/json": {Schema: &Schema{Ref: "#/components/schemas/HealthResponse"}}}
+	return OpenAPIOperation{
+		Summary: summary,
+		Tags:    []string{"health"},
+		Responses: map[string]*OpenAPIResponse{
+			"200": {Description: "Healthy", Content: health},
+			"503": {Description: "Unhealthy", Content: health},
+		},
+	}
+}
+
+// openAPIHandler serves the OpenAPI document describing the routes registered on router
+func openAPIHandler(router chi.Routes) http.HandlerFunc {
+	document := sync.OnceValue(func() []byte {
+		data, _ := json.Marshal(newOpenAPIDocument(router))
+		return data
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document())
+	}
+}
+
+// docsHandler serves the interactive API documentation page
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// docsAssetsHandler serves the Swagger UI assets of the docs page
+func docsAssetsHandler() http.Handler {
+	assets, _ := fs.Sub(swaggerUI, "swagger-ui") // Embedded directory exists
+	return http.StripPrefix("/docs/assets/", http.FileServerFS(assets))
+}
+
+// newOpenAPIDocument builds the OpenAPI document from the routes registered on router
+func newOpenAPIDocument(router chi.Routes) *OpenAPIDocument {
+	document := &OpenAPIDocument{
+		OpenAPI: "3.1.0",
+		Info: OpenAPIInfo{
+			Title:       "rest-weasyprint",
+			Version:     Version,
+			Description: "Render HTML to PDF with WeasyPrint.",
+		},
+		Paths: make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{
+			Schemas: apiSchemas(),
+			SecuritySchemes: map[string]map[string]interface{}{
+				"apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": {"type": "http", "scheme": "bearer"},
+				"basic":  {"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+
+	chi.Walk(router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if route == "/openapi.json" || route == "/docs" || strings.HasPrefix(route, "/docs/") {
+			return nil
+		}
+		operation, ok := apiOperations[method+" "+route]
+		if !ok {
+			operation = OpenAPIOperation{Summary: route, Responses: map[string]*OpenAPIResponse{"200": {Description: "OK"}}}
+		}
+		if strings.HasPrefix(route, "/api/") {
+			operation.Security = []map[string][]string{{"apiKey": {}}, {"bearer": {}}, {}}
+		}
+
+		if document.Paths[route] == nil {
+			document.Paths[route] = make(map[string]*OpenAPIOperation)
+		}
+		document.Paths[route][strings.ToLower(method)] = &operation
+		return nil
+	})
+	return document
+}
+
+// schemaGenerator builds schemas from Go types, named structs become components
+type schemaGenerator struct {
+	components map[string]*Schema
+}
+
+// schemaFor returns the schema of t, struct types are referenced
+func (g *schemaGenerator) schemaFor(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeFor[time.Time]() {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		schema := &Schema{Type: "object"}
+		if t.Elem().Kind() != reflect.Interface {
+			schema.AdditionalProperties = g.schemaFor(t.Elem())
+		}
+		return schema
+	case reflect.Struct:
+		ref := &Schema{Ref: "#/components/schemas/" + t.Name()}
+		if _, ok := g.components[t.Name()]; !ok {
+			g.components[t.Name()] = nil // Reserved against recursion
+			g.components[t.Name()] = g.structSchema(t)
+		}
+		return ref
+	default:
+		return &Schema{}
+	}
+}
+
+// structSchema builds an object schema from the json, doc and schema tags of t's fields
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" || field.Tag.Get("schema") == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		rules := strings.Split(field.Tag.Get("schema"), ",")
+
+		// ref documents loosely typed fields with a component schema
+		property := g.schemaFor(field.Type)
+		for _, rule := range rules {
+			if ref, ok := strings.CutPrefix(rule, "ref="); ok {
+				property = &Schema{Ref: "#/components/schemas/" + ref}
+			}
+		}
+		for _, rule := range rules {
+			key, value, _ := strings.Cut(rule, "=")
+			switch key {
+			case "required":
+				schema.Required = append(schema.Required, name)
+			case "lenient":
+				property.lenient = true
+			case "enum":
+				property.Enum = schemaEnums[value]()
+			case "minimum":
+				minimum, _ := strconv.ParseFloat(value, 64)
+				property.Minimum = &minimum
+			case "maximum":
+				maximum, _ := strconv.ParseFloat(value, 64)
+				property.Maximum = &maximum
+			}
+		}
+		property.Description = field.Tag.Get("doc")
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// multipartFile stands for an uploaded file when validating multipart forms
+type multipartFile struct{}
+
+// validateJSONRequest validates a decoded JSON body against the named component schema
+func validateJSONRequest(body interface{}, component string) error {
+	return validationError(apiSchemas()[component].validate(body, ""))
+}
+
+// validateMultipartForm validates a parsed multipart form against the named component schema
+func validateMultipartForm(form *multipart.Form, component string) error {
+	values := make(map[string]interface{})
+	for name, value := range form.Value {
+		values[name] = value[0]
+	}
+	for name := range form.File {
+		values[name] = multipartFile{}
+	}
+	return validationError(apiSchemas()[component].validate(values, ""))
+}
+
+// validateQueryParameters validates query parameters against the operation of the matched route
+func validateQueryParameters(r *http.Request) error {
+	operation, ok := apiOperations[r.Method+" "+chi.RouteContext(r.Context()).RoutePattern()]
+	if !ok {
+		return nil
+	}
+	return validateQuery(r.URL.Query(), operation.Parameters)
+}
+
+// validateQuery validates query values against parameters
+func validateQuery(query url.Values, parameters []OpenAPIParameter) error {
+	var problems []string
+	for _, parameter := range parameters {
+		if parameter.In != "query" {
+			continue
+		}
+		if !query.Has(parameter.Name) {
+			if parameter.Required {
+				problems = append(problems, parameter.Name+": is required")
+			}
+			continue
+		}
+		problems = append(problems, parameter.Schema.validate(query.Get(parameter.Name), parameter.Name)...)
+	}
+	return validationError(problems)
+}
+
+// validationError joins validation problems into an error, nil when there are none. It is reported as
+// missing_html when html is missing, invalid_option when all problems are options, invalid_request otherwise
+func validationError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	code := CodeInvalidOption
+	for _, problem := range problems {
+		if problem == "html: is required" {
+			code = CodeMissingHTML
+			break
+		}
+		if !strings.HasPrefix(problem, "options") {
+			code = CodeInvalidRequest
+		}
+	}
+	return &problemError{
+		code:   code,
+		err:    fmt.Errorf("request validation failed: %s", strings.Join(problems, "; ")),
+		errors: problems,
+	}
+}
+
+// validate checks a decoded JSON value against the schema, returning every problem found
+func (s *Schema) validate(value interface{}, path string) []string {
+	if s.Ref != "" {
+		resolved := apiSchemas()[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if s.lenient {
+			resolved = &Schema{Type: resolved.Type, lenient: true}
+		}
+		return resolved.validate(value, path)
+	}
+
+	label := path
+	if label == "" {
+		label = "body"
+	}
+
+	if problem := s.checkType(value); problem != "" {
+		return []string{label + ": " + problem}
+	}
+	if s.lenient {
+		return nil
+	}
+
+	var problems []string
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, value) {
+		allowed := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			allowed[i] = fmt.Sprintf("%q", v)
+		}
+		problems = append(problems, fmt.Sprintf("%s: must be one of %s", label, strings.Join(allowed, ", ")))
+	}
+	if number, ok := value.(float64); ok {
+		if s.Minimum != nil && number < *s.Minimum {
+			problems = append(problems, fmt.Sprintf("%s: must be at least %v", label, *s.Minimum))
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			problems = append(problems, fmt.Sprintf("%s: must be at most %v", label, *s.Maximum))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				problems = append(problems, joinPath(path, name)+": is required")
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property := s.propertySchema(name); property != nil {
+				problems = append(problems, property.validate(v[name], joinPath(path, name))...)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				problems = append(problems, s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return problems
+}
+
+// checkType returns a problem when value does not have the schema's type
+func (s *Schema) checkType(value interface{}) string {
+	if s.Format == "binary" {
+		if _, ok := value.(multipartFile); !ok {
+			return "must be a file"
+		}
+		return ""
+	}
+
+	ok := true
+	switch s.Type {
+	case "object":
+		_, ok = value.(map[string]interface{})
+	case "array":
+		_, ok = value.([]interface{})
+	case "string":
+		_, ok = value.(string)
+	case "boolean":
+		_, ok = value.(bool)
+	case "number":
+		_, ok = value.(float64)
+	case "integer":
+		number, isNumber := value.(float64)
+		ok = isNumber && number == math.Trunc(number)
+	}
+	if !ok {
+		return "must be of type " + s.Type
+	}
+	return ""
+}
+
+// propertySchema returns the schema of an object property, nil when unconstrained
+func (s *Schema) propertySchema(name string) *Schema {
+	if property, ok := s.Properties[name]; ok {
+		return property
+	}
+	for pattern, property := range s.PatternProperties {
+		if regexp.MustCompile(pattern).MatchString(name) {
+			return property
+		}
+	}
+	return s.AdditionalProperties
+}
+
+// joinPath appends a property name to a value path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
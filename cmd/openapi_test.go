@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	minimum, maximum := 1.0, 10.0
+	schema := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"name":  {Type: "string"},
+			"count": {Type: "integer", Minimum: &minimum, Maximum: &maximum},
+			"mode":  {Type: "string", Enum: []interface{}{"fast", "slow"}},
+			"tags":  {Type: "array", Items: &Schema{Type: "string"}},
+			"meta":  {Type: "object", AdditionalProperties: &Schema{Type: "boolean"}},
+		},
+		PatternProperties: map[string]*Schema{`^x-`: {Type: "number"}},
+		Required:          []string{"name"},
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"valid", `{"name": "a", "count": 5, "mode": "fast", "tags": ["b"], "meta": {"c": true}, "x-d": 1.5}`, nil},
+		{"required", `{"count": 5}`, []string{"name: is required"}},
+		{"not an object", `[1]`, []string{"body: must be of type object"}},
+		{"wrong type", `{"name": 1}`, []string{"name: must be of type string"}},
+		{"integer", `{"name": "a", "count": 1.5}`, []string{"count: must be of type integer"}},
+		{"minimum", `{"name": "a", "count": 0}`, []string{"count: must be at least 1"}},
+		{"maximum", `{"name": "a", "count": 11}`, []string{"count: must be at most 10"}},
+		{"enum", `{"name": "a", "mode": "medium"}`, []string{`mode: must be one of "fast", "slow"`}},
+		{"array items", `{"name": "a", "tags": ["b", 2]}`, []string{"tags[1]: must be of type string"}},
+		{"additional properties", `{"name": "a", "meta": {"c": "yes"}}`, []string{"meta.c: must be of type boolean"}},
+		{"pattern properties", `{"name": "a", "x-d": "e"}`, []string{"x-d: must be of type number"}},
+		{"unknown fields are ignored", `{"name": "a", "other": {"f": 1}}`, nil},
+		{"every problem", `{"count": 20, "mode": 1}`, []string{"name: is required", "count: must be at most 10", "mode: must be of type string"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			if got := schema.validate(value, ""); !slices.Equal(got, tt.want) {
+				t.Errorf("problems %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateJSONRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		code   string
+		errors []string
+	}{
+		{"valid", `{"html": "<h1>Hi</h1>", "options": {"dpi": 300}}`, "", nil},
+		{"missing html", `{"options": {}}`, CodeMissingHTML, []string{"html: is required"}},
+		{"wrong type", `{"html": "<h1>Hi</h1>", "encrypt_share": "yes"}`, CodeInvalidRequest, []string{"encrypt_share: must be of type boolean"}},
+		{"nested", `{"html": "<h1>Hi</h1>", "output": {}, "share_options": {"max_downloads": -1, "qr_code": "gif"}}`, CodeInvalidRequest, []string{
+			"output.put_url: is required",
+			"share_options.max_downloads: must be at least 0",
+			`share_options.qr_code: must be one of "png", "svg"`,
+		}},
+		{"unknown fields", `{"html": "<h1>Hi</h1>", "colour": "red"}`, "", nil},
+		// options refers to WeasyPrintOptions leniently, the handler checks its values
+		{"lenient options values", `{"html": "<h1>Hi</h1>", "options": {"dpi": 5000, "pdf_variant": "pdf/x", "unknown": true}}`, "", nil},
+		{"lenient options type", `{"html": "<h1>Hi</h1>", "options": "dpi=300"}`, CodeInvalidOption, []string{"options: must be of type object"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body interface{}
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatal(err)
+			}
+			assertValidationError(t, validateJSONRequest(body, "HTMLRequest"), tt.code, tt.errors)
+		})
+	}
+
+	// Without the lenient rule option values are validated
+	options := map[string]interface{}{"dpi": 5000.0, "jpeg_quality": -1.0}
+	assertValidationError(t, validateJSONRequest(options, "WeasyPrintOptions"), CodeInvalidRequest, []string{
+		"dpi: must be at most 600",
+		"jpeg_quality: must be at least 0",
+	})
+}
+
+func TestValidateMultipartForm(t *testing.T) {
+	file := []*multipart.FileHeader{{Filename: "a"}}
+	tests := []struct {
+		name   string
+		form   *multipart.Form
+		code   string
+		errors []string
+	}{
+		{"valid", &multipart.Form{
+			Value: map[string][]string{"options": {`{"dpi": 300}`}, "strict_options": {"true"}},
+			File:  map[string][]*multipart.FileHeader{"html": file, "css.main": file, "asset.logo": file, "attachment.terms": file},
+		}, "", nil},
+		{"missing html", &multipart.Form{File: map[string][]*multipart.FileHeader{"css.main": file}}, CodeMissingHTML, []string{"html: is required"}},
+		{"html not a file", &multipart.Form{Value: map[string][]string{"html": {"<h1>Hi</h1>"}}}, CodeInvalidRequest, []string{"html: must be a file"}},
+		{"stylesheet not a file", &multipart.Form{
+			Value: map[string][]string{"css.main": {"body {}"}},
+			File:  map[string][]*multipart.FileHeader{"html": file},
+		}, CodeInvalidRequest, []string{"css.main: must be a file"}},
+		{"enum", &multipart.Form{
+			Value: map[string][]string{"strict_options": {"yes"}},
+			File:  map[string][]*multipart.FileHeader{"html": file},
+		}, CodeInvalidRequest, []string{`strict_options: must be one of "true", "false"`}},
+		{"unknown fields", &multipart.Form{
+			Value: map[string][]string{"colour": {"red"}},
+			File:  map[string][]*multipart.FileHeader{"html": file, "other": file},
+		}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertValidationError(t, validateMultipartForm(tt.form, "FileUploadForm"), tt.code, tt.errors)
+		})
+	}
+}
+
+func TestValidateQueryParameters(t *testing.T) {
+	var err error
+	router := chi.NewRouter()
+	router.Post("/api/v1/pdf/render/html", func(w http.ResponseWriter, r *http.Request) { err = validateQueryParameters(r) })
+	router.Post("/unknown", func(w http.ResponseWriter, r *http.Request) { err = validateQueryParameters(r) })
+
+	tests := []struct {
+		path   string
+		code   string
+		errors []string
+	}{
+		{"/api/v1/pdf/render/html?filename=a.pdf&encrypt_share=true&strict_options=false", "", nil},
+		{"/api/v1/pdf/render/html?encrypt_share=1&strict_options=yes", CodeInvalidRequest, []string{
+			`encrypt_share: must be one of "true", "false"`,
+			`strict_options: must be one of "true", "false"`,
+		}},
+		{"/api/v1/pdf/render/html?colour=red", "", nil},
+		{"/unknown?encrypt_share=1", "", nil},
+	}
+	for _, tt := range tests {
+		err = errors.New("not validated")
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, tt.path, nil))
+		assertValidationError(t, err, tt.code, tt.errors)
+	}
+
+	// Required parameters are reported when missing
+	required := []OpenAPIParameter{{Name: "id", In: "query", Required: true, Schema: &Schema{Type: "string"}}, {Name: "id", In: "path"}}
+	assertValidationError(t, validateQuery(nil, required), CodeInvalidRequest, []string{"id: is required"})
+}
+
+// assertValidationError checks err is a validation error with the problem code and errors, or nil when code is empty
+func assertValidationError(t *testing.T, err error, code string, problems []string) {
+	t.Helper()
+	if code == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return
+	}
+	var perr *problemError
+	if !errors.As(err, &perr) {
+		t.Fatalf("error %v, want a %s problem", err, code)
+	}
+	if perr.code != code || !slices.Equal(perr.errors, problems) {
+		t.Errorf("code %s errors %q, want %s %q", perr.code, perr.errors, code, problems)
+	}
+}
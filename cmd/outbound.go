@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// maxRetryBackoff caps the delay between share upload attempts
+const maxRetryBackoff = 10 * time.Second
+
+// outboundClient is the HTTP client of share and output uploads with the settings it was built from
+type outboundClient struct {
+	config OutboundConfig
+	client *http.Client
+	public *http.Client // Refuses non-public addresses, for client-supplied output URLs
+}
+
+// outbound holds the shared client, replaced only when the outbound settings change so connections are reused
+var outbound atomic.Pointer[outboundClient]
+
+func init() {
+	configureOutbound(defaultConfig().Outbound)
+}
+
+// configureOutbound builds the shared outbound client from config unless it is unchanged
+func configureOutbound(config OutboundConfig) {
+	if current := outbound.Load(); current != nil && current.config == config {
+		return
+	}
+	dialer := &net.Dialer{Timeout: seconds(config.ConnectTimeoutSeconds), KeepAlive: 30 * time.Second}
+	next := &outboundClient{
+		config: config,
+		client: newOutboundHTTPClient(config, dialer.DialContext),
+		public: newOutboundHTTPClient(config, publicDialContext(dialer)),
+	}
+	if previous := outbound.Swap(next); previous != nil {
+		previous.client.CloseIdleConnections()
+		previous.public.CloseIdleConnections()
+	}
+}
+
+// newOutboundHTTPClient builds an HTTP client with the outbound settings dialing with dial
+func newOutboundHTTPClient(config OutboundConfig, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dial
+	transport.TLSHandshakeTimeout = seconds(config.ConnectTimeoutSeconds)
+	transport.ResponseHeaderTimeout = seconds(config.ResponseTimeoutSeconds)
+	transport.MaxIdleConnsPerHost = 16
+	return &http.Client{
+		Timeout:   seconds(config.UploadTimeoutSeconds),
+		Transport: tracedTransport(transientTransport{transport}),
+	}
+}
+
+// shareHTTPClient returns the shared HTTP client of share and output uploads
+func shareHTTPClient() *http.Client {
+	return outbound.Load().client
+}
+
+// publicHTTPClient returns the shared HTTP client refusing loopback, private and link-local addresses
+func publicHTTPClient() *http.Client {
+	return outbound.Load().public
+}
+
+// errNonPublicAddress is returned for connections publicHTTPClient refuses
+var errNonPublicAddress = errors.New("address is not public")
+
+// nonPublicPrefixes are ranges beside loopback, private and link-local ones that reach internal services,
+// e.g. cloud metadata endpoints in the shared address space
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// isPublicAddress reports whether addr is a global unicast address outside private and internal ranges
+func isPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicDialContext dials like dialer but checks the resolved address of every connection, so host names
+// resolving to internal addresses are refused too. Proxies from the environment are exempt, they resolve the target
+func publicDialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxies := environmentProxyAddresses()
+	public := *dialer
+	public.Control = func(network, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if !isPublicAddress(addrPort.Addr()) {
+			return fmt.Errorf("%s: %w", addrPort.Addr(), errNonPublicAddress)
+		}
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if proxies[addr] {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return public.DialContext(ctx, network, addr)
+	}
+}
+
+// environmentProxyAddresses returns the host:port of the HTTP proxies configured in the environment
+func environmentProxyAddresses() map[string]bool {
+	proxies := make(map[string]bool)
+	config := httpproxy.FromEnvironment()
+	for _, proxy := range []string{config.HTTPProxy, config.HTTPSProxy} {
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		u, err := url.Parse(proxy)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"https": "443", "socks5": "1080"}[u.Scheme]
+		}
+		if port == "" {
+			port = "80"
+		}
+		proxies[net.JoinHostPort(u.Hostname(), port)] = true
+	}
+	return proxies
+}
+
+// connectTimeout returns the outbound connect timeout, for providers dialing themselves
+func connectTimeout() time.Duration {
+	return seconds(outbound.Load().config.ConnectTimeoutSeconds)
+}
+
+// seconds converts a whole number of seconds to a duration
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// uploadAttempt records whether an upload attempt failed in a way worth retrying
+type uploadAttempt struct {
+	transient atomic.Bool
+}
+
+type uploadAttemptKey struct{}
+
+// withUploadAttempt returns a context whose outbound requests report transient failures to the returned attempt
+func withUploadAttempt(ctx context.Context) (context.Context, *uploadAttempt) {
+	attempt := &uploadAttempt{}
+	return context.WithValue(ctx, uploadAttemptKey{}, attempt), attempt
+}
+
+// markTransient records a transient failure of the upload attempt of ctx, for providers not using HTTP
+func markTransient(ctx context.Context) {
+	if attempt, ok := ctx.Value(uploadAttemptKey{}).(*uploadAttempt); ok {
+		attempt.transient.Store(true)
+	}
+}
+
+// transientTransport marks network errors, 429 and 5xx responses as transient failures of the upload attempt
+type transientTransport struct {
+	base http.RoundTripper
+}
+
+func (t transientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil:
+		if req.Context().Err() == nil {
+			markTransient(req.Context())
+		}
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		markTransient(req.Context())
+	}
+	return resp, err
+}
+
+// retryBackoff returns the jittered delay before retry number retry, starting at 1
+func retryBackoff(config OutboundConfig, retry int) time.Duration {
+	delay := time.Duration(config.RetryBackoffMS) * time.Millisecond
+	for i := 1; i < retry && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryBackoff)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// errCircuitOpen fails uploads to providers whose circuit is open
+var errCircuitOpen = errors.New("circuit open after repeated failures")
+
+// circuitBreaker fails uploads fast after consecutive transient failures of a provider.
+// Once the cooldown passed a single trial upload decides whether the circuit closes again.
+// A nil breaker allows all uploads
+type circuitBreaker struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // Zero while closed
+	probing  bool      // A trial upload is in flight
+}
+
+// available reports whether an upload could currently be attempted, without claiming the trial upload
+func (b *circuitBreaker) available(config OutboundConfig) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openedAt.IsZero() || (!b.probing && time.Since(b.openedAt) >= seconds(config.BreakerCooldownSeconds))
+}
+
+// cooldownLeft returns how long an open circuit keeps failing fast, zero when an upload could be attempted
+func (b *circuitBreaker) cooldownLeft(config OutboundConfig) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return 0
+	}
+	return max(seconds(config.BreakerCooldownSeconds)-time.Since(b.openedAt), 0)
+}
+
+// allow reports whether an upload may start, claiming the trial upload of an open circuit past its cooldown
+func (b *circuitBreaker) allow(config OutboundConfig) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < seconds(config.BreakerCooldownSeconds) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the circuit with the result of an allowed upload, only transient failures count
+func (b *circuitBreaker) record(config OutboundConfig, transient bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !transient {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+	b.failures++
+	if !b.openedAt.IsZero() || b.failures >= config.BreakerFailures {
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends an allowed upload that was abandoned without a result
+func (b *circuitBreaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// circuitBreakers holds the breakers of share providers by name, kept across configuration reloads
+type circuitBreakers struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// get returns the breaker of provider name, nil when circuit breaking is disabled
+func (c *circuitBreakers) get(config OutboundConfig, name FileShareService) *circuitBreaker {
+	if config.BreakerFailures == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.breakers == nil {
+		c.breakers = make(map[string]*circuitBreaker)
+	}
+	breaker, ok := c.breakers[string(name)]
+	if !ok {
+		breaker = &circuitBreaker{}
+		c.breakers[string(name)] = breaker
+	}
+	return breaker
+}
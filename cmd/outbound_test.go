@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	config := OutboundConfig{BreakerFailures: 2, BreakerCooldownSeconds: 30}
+	b := &circuitBreaker{}
+	cooledDown := func() { b.openedAt = time.Now().Add(-seconds(config.BreakerCooldownSeconds)) }
+
+	// Only consecutive transient failures count
+	b.record(config, true)
+	b.record(config, false)
+	b.record(config, true)
+	if !b.allow(config) {
+		t.Fatalf("circuit opened before %d consecutive failures", config.BreakerFailures)
+	}
+	b.record(config, true)
+	if b.available(config) || b.allow(config) {
+		t.Fatalf("circuit still closed after %d consecutive failures", config.BreakerFailures)
+	}
+	if left := b.cooldownLeft(config); left <= 29*time.Second || left > 30*time.Second {
+		t.Errorf("cooldown left %v", left)
+	}
+
+	// Past the cooldown a single trial upload is allowed, failing it opens the circuit again
+	cooledDown()
+	if !b.available(config) || !b.allow(config) {
+		t.Fatalf("no trial upload after the cooldown")
+	}
+	if b.available(config) || b.allow(config) {
+		t.Fatalf("second upload allowed during the trial")
+	}
+	b.record(config, true)
+	if b.allow(config) {
+		t.Fatalf("failed trial closed the circuit")
+	}
+
+	// An abandoned trial frees the slot, a successful one closes the circuit
+	cooledDown()
+	b.allow(config)
+	b.release()
+	if !b.allow(config) {
+		t.Fatalf("released trial kept the slot")
+	}
+	b.record(config, false)
+	if !b.allow(config) || b.cooldownLeft(config) != 0 {
+		t.Fatalf("successful trial left the circuit open")
+	}
+	b.record(config, true)
+	if !b.allow(config) {
+		t.Errorf("failures before the recovery still counted")
+	}
+}
+
+func TestCircuitBreakersDisabled(t *testing.T) {
+	var breakers circuitBreakers
+	b := breakers.get(OutboundConfig{}, "fileio")
+	if b != nil {
+		t.Fatalf("breaker returned with breaker_failures 0")
+	}
+	b.record(OutboundConfig{}, true)
+	if !b.available(OutboundConfig{}) || !b.allow(OutboundConfig{}) {
+		t.Errorf("nil breaker refused an upload")
+	}
+
+	config := OutboundConfig{BreakerFailures: 1}
+	if breakers.get(config, "fileio") != breakers.get(config, "fileio") || breakers.get(config, "fileio") == breakers.get(config, "s3") {
+		t.Errorf("breakers are not kept per provider")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	config := OutboundConfig{RetryBackoffMS: 100}
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{20, maxRetryBackoff / 2, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			if d := retryBackoff(config, tt.retry); d < tt.min || d > tt.max {
+				t.Fatalf("retry %d: backoff %v not in [%v, %v]", tt.retry, d, tt.min, tt.max)
+			}
+		}
+	}
+	if d := retryBackoff(OutboundConfig{}, 1); d != 0 {
+		t.Errorf("backoff %v with retry_backoff_ms 0", d)
+	}
+}
+
+func TestTransientTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: transientTransport{base: http.DefaultTransport}}
+
+	for status, transient := range map[int]bool{200: false, 400: false, 404: false, 413: false, 429: true, 500: true, 502: true, 503: true} {
+		ctx, attempt := withUploadAttempt(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPut, server.URL+"?status="+strconv.Itoa(status), nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if attempt.transient.Load() != transient {
+			t.Errorf("status %d: transient %v, want %v", status, attempt.transient.Load(), transient)
+		}
+	}
+
+	// Network errors are transient unless the request was cancelled
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	ctx, attempt := withUploadAttempt(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, closed.URL, nil)
+	if _, err := client.Do(req); err == nil || !attempt.transient.Load() {
+		t.Errorf("refused connection: err %v transient %v", err, attempt.transient.Load())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx, attempt = withUploadAttempt(ctx)
+	req, _ = http.NewRequestWithContext(ctx, http.MethodPut, server.URL+"?status=200", nil)
+	if _, err := client.Do(req); err == nil || attempt.transient.Load() {
+		t.Errorf("cancelled request: err %v transient %v", err, attempt.transient.Load())
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http/httpguts"
+)
+
+// OutputTarget is a client-supplied destination the PDF is uploaded to instead of being returned
+type OutputTarget struct {
+	PutURL  string            `json:"put_url" doc:"Presigned http(s) URL the PDF is uploaded to with PUT" schema:"required"`
+	Headers map[string]string `json:"headers,omitempty" doc:"Extra upload headers, e.g. those the URL was signed with"`
+}
+
+// OutputResult describes a PDF uploaded to an output target
+type OutputResult struct {
+	Success bool   `json:"success" schema:"required"`
+	Size    int64  `json:"size" doc:"PDF size in bytes" schema:"required"`
+	SHA256  string `json:"sha256" doc:"Hex SHA-256 of the PDF" schema:"required"`
+	Pages   int    `json:"pages" doc:"Page count, 0 if it could not be determined" schema:"required"`
+	Status  int    `json:"status" doc:"HTTP status of the upload" schema:"required"`
+	ETag    string `json:"etag,omitempty" doc:"ETag returned by the upload target"`
+}
+
+// forbiddenOutputHeaders are set by the service or hop-by-hop and cannot be supplied
+var forbiddenOutputHeaders = []string{
+	"Host", "Content-Length", "Transfer-Encoding", "Connection", "Keep-Alive", "Expect",
+	"Te", "Trailer", "Upgrade", "Proxy-Authorization", "Proxy-Connection",
+}
+
+// validateOutputTarget checks the target URL against the allowed output hosts and its headers
+func (s *PDFService) validateOutputTarget(target *OutputTarget) error {
+	u, err := url.Parse(target.PutURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("output.put_url must be an http(s) URL")
+	}
+	security := s.cfg().Security
+	if err := checkAllowedURL(security.AllowedOutputHosts, target.PutURL); err != nil {
+		return fmt.Errorf("output.put_url not allowed: %v", err)
+	}
+	if !security.AllowPrivateOutput {
+		// Host names are checked once resolved, when connecting
+		addr, err := netip.ParseAddr(strings.Trim(u.Hostname(), "[]"))
+		if strings.EqualFold(u.Hostname(), "localhost") || (err == nil && !isPublicAddress(addr)) {
+			return fmt.Errorf("output.put_url not allowed: %s is not a public address", u.Hostname())
+		}
+	}
+	for name, value := range target.Headers {
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("output.headers: invalid header %q", name)
+		}
+		for _, forbidden := range forbiddenOutputHeaders {
+			if strings.EqualFold(name, forbidden) {
+				return fmt.Errorf("output.headers: %s cannot be set", http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return nil
+}
+
+// parseOutputTarget decodes and validates an output target given as JSON, e.g. a form field
+func (s *PDFService) parseOutputTarget(data string) (*OutputTarget, error) {
+	var body interface{}
+	if err := json.Unmarshal([]byte(data), &body); err != nil {
+		return nil, withProblemCode(CodeInvalidJSON, errors.New("invalid JSON format output"))
+	}
+	if err := validateJSONRequest(body, "OutputTarget"); err != nil {
+		return nil, err
+	}
+	var target OutputTarget
+	if err := json.Unmarshal([]byte(data), &target); err != nil {
+		return nil, withProblemCode(CodeInvalidRequest, fmt.Errorf("output: %w", err))
+	}
+	if err := s.validateOutputTarget(&target); err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// renderToOutput generates the PDF with generate, uploads it to target and writes the result.
+// It returns the render outcome
+func (s *PDFService) renderToOutput(ctx context.Context, w http.ResponseWriter, target *OutputTarget, generate func(w io.Writer) error) string {
+	tempFile, err := s.createTempFile("pdfoutput-*.pdf")
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to create temporary PDF file", "error", err)
+		writeProblem(ctx, w, http.StatusInternalServerError, CodeInternalError, "Internal server error")
+		return OutcomeServerError
+	}
+	defer s.removeTemp(tempFile.Name())
+	defer tempFile.Close()
+
+	hash := sha256.New()
+	if err := generate(io.MultiWriter(tempFile, hash)); err != nil {
+		s.logger.ErrorContext(ctx, "PDF generation failed", "error", err)
+		writeRenderProblem(ctx, w, err)
+		return OutcomeRenderError
+	}
+
+	result := &OutputResult{SHA256: hex.EncodeToString(hash.Sum(nil))}
+	if result.Pages, err = countPDFPages(tempFile.Name()); err != nil {
+		s.logger.WarnContext(ctx, "Failed to count PDF pages", "error", err)
+	}
+
+	start := time.Now()
+	err = s.uploadToOutput(ctx, tempFile, target, result)
+	s.metrics.observePhase(PhaseOutput, time.Since(start).Seconds())
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Output upload failed", "host", outputHost(target.PutURL), "error", err)
+		if problemCode(err, "") == CodeURLNotAllowed {
+			writeErrorProblem(ctx, w, http.StatusForbidden, CodeURLNotAllowed, err)
+			return OutcomeOutputError
+		}
+		writeProblem(ctx, w, http.StatusBadGateway, CodeOutputFailed, "Output upload failed: "+err.Error())
+		return OutcomeOutputError
+	}
+
+	result.Success = true
+	s.logger.InfoContext(ctx, "PDF uploaded to output", "host", outputHost(target.PutURL), "size", result.Size, "pages", result.Pages)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+	return OutcomeSuccess
+}
+
+// uploadToOutput streams file to the target URL, recording size, status and ETag in result
+func (s *PDFService) uploadToOutput(ctx context.Context, file *os.File, target *OutputTarget, result *OutputResult) (err error) {
+	ctx, span := tracer.Start(ctx, "uploadToOutput",
+		trace.WithAttributes(attribute.String("output.host", outputHost(target.PutURL))))
+	defer func() { endSpan(span, err) }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	result.Size = info.Size()
+
+	// Presigned URLs usually reject chunked bodies, so the length is sent up front.
+	// ReadAt leaves the offset of file alone, fanned out renders upload it concurrently
+	body := io.NopCloser(io.NewSectionReader(file, 0, result.Size))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.PutURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.ContentLength = result.Size
+	req.Header.Set("Content-Type", "application/pdf")
+	for name, value := range target.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := *publicHTTPClient()
+	if s.cfg().Security.AllowPrivateOutput {
+		client = *shareHTTPClient()
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(req)
+	if errors.Is(err, errNonPublicAddress) {
+		return withProblemCode(CodeURLNotAllowed, fmt.Errorf("output.put_url resolves to a non-public address"))
+	}
+	if err != nil {
+		return fmt.Errorf("request failed: %v", redactURLError(err))
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	result.ETag = resp.Header.Get("ETag")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if s3Err := parseS3Error(data); s3Err != nil {
+			return fmt.Errorf("%s: %v", resp.Status, s3Err)
+		}
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// outputHost returns the host of an output URL, logs leave out the signature in the query
+func outputHost(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// redactURLError removes the URL, carrying presigned credentials, from client errors
+func redactURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
+
+// PDF structure patterns used for counting pages
+var (
+	pdfPagesObject  = regexp.MustCompile(`/Type\s*/Pages(?:[^A-Za-z0-9_]|$)`)
+	pdfPageObject   = regexp.MustCompile(`/Type\s*/Page(?:[^A-Za-z0-9_]|$)`)
+	pdfPageCount    = regexp.MustCompile(`/Count\s+(\d+)`)
+	pdfObjectStream = regexp.MustCompile(`/Type\s*/ObjStm(?:[^A-Za-z0-9_]|$)`)
+	pdfStreamStart  = regexp.MustCompile(`>>\s*stream\r?\n`)
+)
+
+// Page counting reads the PDF in chunks and inflates at most a bounded amount of object streams
+const (
+	pdfScanChunk       = 1 << 20
+	pdfScanOverlap     = 64 << 10 // Longest page tree dictionary found across chunk boundaries
+	maxPDFObjectStream = 4 << 20  // Inflated bytes read from an object stream
+)
+
+// countPDFPages returns the /Count of the root of the page tree of the PDF at path, the largest count of its
+// page tree nodes. Nodes are looked up in the file and, when compressed, in its object streams. PDFs without
+// page tree nodes fall back to counting their page objects
+func countPDFPages(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	pages, pageObjects := 0, 0
+	var objectStreams []int64
+	buf := make([]byte, 0, pdfScanChunk+pdfScanOverlap)
+	var offset, scanned int64 // File offset of buf and end of the matches already counted
+	for {
+		n, err := io.ReadFull(file, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		final := err != nil
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		// Matches ending in the overlap are counted with the next chunk, which holds their whole dictionary
+		limit := len(buf) - pdfScanOverlap
+		if final {
+			limit = len(buf)
+		}
+		pages = max(pages, pageTreeCount(buf, int(scanned-offset), limit))
+		for _, loc := range pdfPageObject.FindAllIndex(buf, -1) {
+			if start := offset + int64(loc[0]); start >= scanned && loc[0] < limit {
+				pageObjects++
+			}
+		}
+		for _, loc := range pdfObjectStream.FindAllIndex(buf, -1) {
+			if start := offset + int64(loc[0]); start >= scanned && loc[0] < limit {
+				if data := pdfStreamStart.FindIndex(buf[loc[1]:]); data != nil {
+					objectStreams = append(objectStreams, start+int64(loc[1]-loc[0]+data[1]))
+				}
+			}
+		}
+		if final {
+			break
+		}
+		scanned = offset + int64(limit)
+		offset += int64(len(buf) - pdfScanOverlap)
+		buf = buf[:copy(buf, buf[len(buf)-pdfScanOverlap:])]
+	}
+
+	// Compressed page tree nodes are looked up only when the file has none
+	for _, start := range objectStreams {
+		if pages > 0 {
+			break
+		}
+		reader, err := zlib.NewReader(io.NewSectionReader(file, start, 1<<62))
+		if err != nil {
+			continue
+		}
+		content, _ := io.ReadAll(io.LimitReader(reader, maxPDFObjectStream))
+		reader.Close()
+		pages = pageTreeCount(content, 0, len(content))
+	}
+
+	switch {
+	case pages > 0:
+		return pages, nil
+	case pageObjects > 0:
+		return pageObjects, nil
+	}
+	return 0, fmt.Errorf("no page tree found")
+}
+
+// pageTreeCount returns the largest /Count of the page tree nodes of data starting in [from, to)
+func pageTreeCount(data []byte, from, to int) int {
+	count := 0
+	for _, loc := range pdfPagesObject.FindAllIndex(data, -1) {
+		if loc[0] < from || loc[0] >= to {
+			continue
+		}
+		// The node's dictionary encloses the match, /Kids holds only references
+		start := bytes.LastIndex(data[:loc[0]], []byte("<<"))
+		end := bytes.Index(data[loc[1]-1:], []byte(">>"))
+		if start < 0 || end < 0 {
+			continue
+		}
+		if m := pdfPageCount.FindSubmatch(data[start : loc[1]-1+end]); m != nil {
+			if n, err := strconv.Atoi(string(m[1])); err == nil {
+				count = max(count, n)
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestPDF writes data to a temporary PDF and returns its path
+func writeTestPDF(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.pdf")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// objectStreamPDF returns a PDF whose objects are compressed into an object stream
+func objectStreamPDF(objects string) []byte {
+	var stream bytes.Buffer
+	w := zlib.NewWriter(&stream)
+	w.Write([]byte(objects))
+	w.Close()
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.7\n1 0 obj\n<</Type/ObjStm/N 3/First 12/Filter/FlateDecode>>\nstream\n")
+	pdf.Write(stream.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestCountPDFPages(t *testing.T) {
+	padding := strings.Repeat("%", pdfScanChunk-20) + "\n"
+	tests := []struct {
+		name  string
+		pdf   []byte
+		pages int
+	}{
+		{"page tree", []byte("%PDF-1.7\n2 0 obj\n<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>\nendobj\n3 0 obj\n<< /Type /Page >>\nendobj\n%%EOF\n"), 2},
+		{"root node count", []byte("%PDF-1.7\n<</Count 7/Type/Pages/Kids[5 0 R 6 0 R]>>\n<</Type/Pages/Parent 2 0 R/Count 4>>\n<</Type/Pages/Parent 2 0 R/Count 3>>\n<</Type/Outlines/Count 12>>\n%%EOF\n"), 7},
+		{"node across chunks", []byte("%PDF-1.7\n" + padding + "<< /Type /Pages /Kids [3 0 R] /Count 5 >>\n%%EOF\n"), 5},
+		{"object stream", objectStreamPDF("<</Type/Pages/Kids[3 0 R 4 0 R 5 0 R]/Count 3>> <</Type/Page/Parent 2 0 R>> <</Type/Catalog/Pages 2 0 R>>"), 3},
+		{"page objects only", []byte("%PDF-1.7\n<< /Type /Page >>\n<< /Type/Page/Parent 2 0 R >>\n%%EOF\n"), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := countPDFPages(writeTestPDF(t, tt.pdf))
+			if err != nil || pages != tt.pages {
+				t.Errorf("countPDFPages = %d, %v, want %d", pages, err, tt.pages)
+			}
+		})
+	}
+
+	if _, err := countPDFPages(writeTestPDF(t, []byte("%PDF-1.7\n%%EOF\n"))); err == nil {
+		t.Errorf("PDF without pages counted")
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700::1111":      true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"100.100.100.200":      false,
+		"0.0.0.0":              false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:93.184.216.34": true,
+		"224.0.0.1":            false,
+	}
+	for address, want := range tests {
+		if got := isPublicAddress(netip.MustParseAddr(address)); got != want {
+			t.Errorf("isPublicAddress(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func newTestOutputService(security SecurityConfig) *PDFService {
+	config := defaultConfig()
+	config.Security = security
+	s := &PDFService{}
+	s.config.Store(config)
+	return s
+}
+
+func TestValidateOutputTargetRejectsPrivateAddresses(t *testing.T) {
+	s := newTestOutputService(SecurityConfig{})
+	for _, putURL := range []string{"http://localhost/x", "http://127.0.0.1:9000/x", "http://[::1]/x", "http://169.254.169.254/latest/meta-data"} {
+		if err := s.validateOutputTarget(&OutputTarget{PutURL: putURL}); err == nil {
+			t.Errorf("%s accepted", putURL)
+		}
+	}
+	if err := s.validateOutputTarget(&OutputTarget{PutURL: "https://bucket.s3.amazonaws.com/x"}); err != nil {
+		t.Errorf("public URL rejected: %v", err)
+	}
+
+	s = newTestOutputService(SecurityConfig{AllowPrivateOutput: true})
+	if err := s.validateOutputTarget(&OutputTarget{PutURL: "http://127.0.0.1:9000/x"}); err != nil {
+		t.Errorf("private URL rejected with allow_private_output: %v", err)
+	}
+}
+
+func TestUploadToOutputChecksResolvedAddress(t *testing.T) {
+	uploads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads++
+		w.Header().Set("ETag", `"abc"`)
+	}))
+	defer server.Close()
+	file, err := os.Open(writeTestPDF(t, []byte("%PDF")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Validation is skipped, the connection itself is refused
+	s := newTestOutputService(SecurityConfig{})
+	err = s.uploadToOutput(context.Background(), file, &OutputTarget{PutURL: server.URL}, &OutputResult{})
+	if problemCode(err, "") != CodeURLNotAllowed {
+		t.Errorf("err = %v, want %s", err, CodeURLNotAllowed)
+	}
+	if uploads != 0 {
+		t.Errorf("upload reached the server")
+	}
+
+	s = newTestOutputService(SecurityConfig{AllowPrivateOutput: true})
+	result := &OutputResult{}
+	if err := s.uploadToOutput(context.Background(), file, &OutputTarget{PutURL: server.URL}, result); err != nil {
+		t.Fatal(err)
+	}
+	if uploads != 1 || result.Status != http.StatusOK || result.ETag != `"abc"` || result.Size != 4 {
+		t.Errorf("uploads %d result %+v", uploads, result)
+	}
+}
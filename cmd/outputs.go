@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"sync"
+	"time"
+)
+
+// Destination types of render outputs
+const (
+	DestinationResponse = "response" // The PDF is returned in the response
+	DestinationShare    = "share"    // Uploaded to a share provider
+	DestinationPut      = "put"      // Uploaded to a client-supplied URL
+
+	maxOutputDestinations = 10
+)
+
+// errOutputsCombined rejects outputs next to the single-destination fields it replaces
+var errOutputsCombined = errors.New("outputs cannot be combined with output, share_service, share_fallbacks, email, share_options or encrypt_share")
+
+// destinationTypes lists the accepted destination types
+var destinationTypes = []string{DestinationResponse, DestinationShare, DestinationPut}
+
+// OutputDestination is one of the destinations a single render is fanned out to
+type OutputDestination struct {
+	Type           string            `json:"type" doc:"response returns the PDF, share uploads it to share_service, put uploads it to put_url" schema:"required,enum=destinationTypes"`
+	ShareService   string            `json:"share_service,omitempty" doc:"Share provider of share destinations"`
+	ShareFallbacks []string          `json:"share_fallbacks,omitempty" doc:"Share providers tried in order when share_service fails or is unavailable"`
+	ShareOptions   *ShareOptions     `json:"share_options,omitempty" doc:"Link settings of share destinations"`
+	EncryptShare   bool              `json:"encrypt_share,omitempty" doc:"Encrypt the PDF uploaded to share destinations"`
+	Email          *EmailMessage     `json:"email,omitempty" doc:"Email of share destinations with smtp providers"`
+	PutURL         string            `json:"put_url,omitempty" doc:"Presigned http(s) URL of put destinations"`
+	Headers        map[string]string `json:"headers,omitempty" doc:"Extra upload headers of put destinations"`
+
+	shareTo  []FileShareService // ShareService followed by its fallbacks
+	shareKey []byte             // Key of encrypted shares
+}
+
+// OutputsResult summarizes a render fanned out to several destinations
+type OutputsResult struct {
+	Success bool                `json:"success" doc:"All destinations succeeded" schema:"required"`
+	Size    int64               `json:"size" doc:"PDF size in bytes" schema:"required"`
+	SHA256  string              `json:"sha256" doc:"Hex SHA-256 of the PDF" schema:"required"`
+	Pages   int                 `json:"pages" doc:"Page count, 0 if it could not be determined" schema:"required"`
+	Results []DestinationResult `json:"results" doc:"Results in the order of outputs" schema:"required"`
+}
+
+// DestinationResult is the result of one destination of a fanned out render
+type DestinationResult struct {
+	Type    string         `json:"type" schema:"required"`
+	Success bool           `json:"success" schema:"required"`
+	Error   string         `json:"error,omitempty" doc:"Why the destination failed"`
+	Share   *ShareResponse `json:"share,omitempty" doc:"Share response of share destinations"`
+	Output  *OutputResult  `json:"output,omitempty" doc:"Upload result of put destinations"`
+}
+
+// parseOutputDestinations decodes output destinations given as JSON, e.g. a form field
+func parseOutputDestinations(data string) ([]OutputDestination, error) {
+	var body interface{}
+	if err := json.Unmarshal([]byte(data), &body); err != nil {
+		return nil, withProblemCode(CodeInvalidJSON, errors.New("invalid JSON format outputs"))
+	}
+	schema := &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/OutputDestination"}}
+	if err := validationError(schema.validate(body, "outputs")); err != nil {
+		return nil, err
+	}
+	var destinations []OutputDestination
+	if err := json.Unmarshal([]byte(data), &destinations); err != nil {
+		return nil, withProblemCode(CodeInvalidRequest, fmt.Errorf("outputs: %w", err))
+	}
+	return destinations, nil
+}
+
+// validateOutputDestinations checks every destination the way its single-destination request is checked
+func (s *PDFService) validateOutputDestinations(ctx context.Context, destinations []OutputDestination, filename string) error {
+	if len(destinations) == 0 {
+		return errors.New("outputs must list at least one destination")
+	}
+	if len(destinations) > maxOutputDestinations {
+		return fmt.Errorf("outputs allows at most %d destinations", maxOutputDestinations)
+	}
+	responses := 0
+	for i := range destinations {
+		d := &destinations[i]
+		if err := s.validateOutputDestination(ctx, d, filename); err != nil {
+			return fmt.Errorf("outputs[%d]: %w", i, err)
+		}
+		if d.Type == DestinationResponse {
+			responses++
+		}
+	}
+	if responses > 1 {
+		return errors.New("outputs allows one response destination")
+	}
+	return nil
+}
+
+// validateOutputDestination checks d and resolves its share providers and encryption key
+func (s *PDFService) validateOutputDestination(ctx context.Context, d *OutputDestination, filename string) error {
+	shareFields := d.ShareService != "" || len(d.ShareFallbacks) > 0 || d.ShareOptions != nil || d.EncryptShare || d.Email != nil
+	putFields := d.PutURL != "" || len(d.Headers) > 0
+	switch d.Type {
+	case DestinationResponse:
+		if shareFields || putFields {
+			return errors.New("response destinations take no settings")
+		}
+	case DestinationShare:
+		if putFields {
+			return errors.New("put_url and headers require type put")
+		}
+		if d.ShareService == "" {
+			return errors.New("share destinations require share_service")
+		}
+		var err error
+		if d.shareTo, err = s.shareServices(FileShareService(d.ShareService), d.ShareFallbacks); err != nil {
+			return err
+		}
+		if err := s.validateEmail(ctx, d.shareTo, d.Email, filename); err != nil {
+			return err
+		}
+		if err := s.validateShareOptions(d.shareTo, d.ShareOptions); err != nil {
+			return err
+		}
+		if d.shareKey, err = s.newShareEncryption(d.shareTo, d.EncryptShare); err != nil {
+			return err
+		}
+	case DestinationPut:
+		if shareFields {
+			return errors.New("share settings require type share")
+		}
+		return s.validateOutputTarget(&OutputTarget{PutURL: d.PutURL, Headers: d.Headers})
+	}
+	return nil
+}
+
+// renderToOutputs generates the PDF once with generate, delivers it to all destinations concurrently and
+// writes the summary, followed by the PDF as a multipart/mixed response when a destination is the response.
+// It returns the render outcome
+func (s *PDFService) renderToOutputs(ctx context.Context, w http.ResponseWriter, destinations []OutputDestination, filename string, generate func(w io.Writer) error) string {
+	tempFile, err := s.createTempFile("pdfoutputs-*.pdf")
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to create temporary PDF file", "error", err)
+		writeProblem(ctx, w, http.StatusInternalServerError, CodeInternalError, "Internal server error")
+		return OutcomeServerError
+	}
+	defer s.removeTemp(tempFile.Name())
+	defer tempFile.Close()
+
+	hash := sha256.New()
+	if err := generate(io.MultiWriter(tempFile, hash)); err != nil {
+		s.logger.ErrorContext(ctx, "PDF generation failed", "error", err)
+		writeRenderProblem(ctx, w, err)
+		return OutcomeRenderError
+	}
+	info, err := tempFile.Stat()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to read rendered PDF", "error", err)
+		writeProblem(ctx, w, http.StatusInternalServerError, CodeInternalError, "Internal server error")
+		return OutcomeServerError
+	}
+
+	summary := &OutputsResult{Success: true, Size: info.Size(), SHA256: hex.EncodeToString(hash.Sum(nil))}
+	if summary.Pages, err = countPDFPages(tempFile.Name()); err != nil {
+		s.logger.WarnContext(ctx, "Failed to count PDF pages", "error", err)
+	}
+
+	// Uploads read the rendered file concurrently with ReadAt
+	summary.Results = make([]DestinationResult, len(destinations))
+	var wg sync.WaitGroup
+	for i, d := range destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			summary.Results[i] = s.deliverOutput(ctx, tempFile, summary, d, filename)
+		}()
+	}
+	wg.Wait()
+
+	respond, failed := false, 0
+	for _, result := range summary.Results {
+		respond = respond || result.Type == DestinationResponse
+		if !result.Success {
+			summary.Success = false
+			failed++
+		}
+	}
+	status, outcome := http.StatusOK, OutcomeSuccess
+	if !summary.Success {
+		status, outcome = http.StatusMultiStatus, OutcomeOutputError
+		s.logger.WarnContext(ctx, "Some outputs failed", "failed", failed, "destinations", len(destinations))
+	}
+
+	if !respond {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(summary)
+		return outcome
+	}
+
+	// The summary comes first so clients know the results before reading the PDF
+	writer := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	w.WriteHeader(status)
+	part, _ := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	json.NewEncoder(part).Encode(summary)
+	part, _ = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"application/pdf"},
+		"Content-Disposition": {pdfDisposition(filename)},
+	})
+	if _, err := io.Copy(part, io.NewSectionReader(tempFile, 0, summary.Size)); err != nil {
+		s.logger.WarnContext(ctx, "Failed to write PDF", "error", err)
+		return outcome
+	}
+	writer.Close()
+	return outcome
+}
+
+// deliverOutput delivers the rendered PDF in file to destination d
+func (s *PDFService) deliverOutput(ctx context.Context, file *os.File, summary *OutputsResult, d OutputDestination, filename string) DestinationResult {
+	result := DestinationResult{Type: d.Type, Success: true}
+	var err error
+	switch d.Type {
+	case DestinationShare:
+		result.Share, err = s.deliverShare(ctx, file, summary.Size, d, filename)
+	case DestinationPut:
+		target := &OutputTarget{PutURL: d.PutURL, Headers: d.Headers}
+		result.Output = &OutputResult{SHA256: summary.SHA256, Pages: summary.Pages}
+		start := time.Now()
+		err = s.uploadToOutput(ctx, file, target, result.Output)
+		s.metrics.observePhase(PhaseOutput, time.Since(start).Seconds())
+		if err != nil {
+			s.logger.ErrorContext(ctx, "Output upload failed", "host", outputHost(target.PutURL), "error", err)
+		} else {
+			result.Output.Success = true
+		}
+	}
+	if err != nil {
+		result.Success = false
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// deliverShare uploads the rendered PDF in file to the share providers of d, encrypting it first when asked
+func (s *PDFService) deliverShare(ctx context.Context, file *os.File, size int64, d OutputDestination, filename string) (*ShareResponse, error) {
+	ctx = withEmailMessage(ctx, d.Email)
+	ctx = withShareOptions(ctx, d.ShareOptions)
+	ctx = withShareEncryption(ctx, d.shareKey)
+
+	source := io.NewSectionReader(file, 0, size)
+	if d.shareKey != nil {
+		encrypted, err := s.createTempFile("pdfoutputs-*.enc")
+		if err != nil {
+			return nil, err
+		}
+		defer s.removeTemp(encrypted.Name())
+		defer encrypted.Close()
+		err = encryptedGenerate(d.shareKey, func(ctx context.Context, w io.Writer) error {
+			_, err := io.Copy(w, source)
+			return err
+		})(ctx, encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt PDF: %v", err)
+		}
+		file = encrypted
+		filename += encryptedShareExtension
+	}
+
+	response, err := s.uploadToShareServices(ctx, d.shareTo, filename, newFileShareSource(file))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to upload to sharing service", "services", d.shareTo, "error", err)
+		return nil, err
+	}
+	return response, nil
+}
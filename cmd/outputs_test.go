@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// outputsPDF is the PDF rendered by the fake weasyprint of the outputs tests
+const outputsPDF = "%PDF-1.7\n<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>\n%%EOF\n"
+
+// newOutputsTestService returns a service rendering outputsPDF with a scripted share provider "share"
+// and a put server answering the status in its status query parameter
+func newOutputsTestService(t *testing.T) (*PDFService, string) {
+	t.Helper()
+	put := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, _ := io.ReadAll(r.Body); string(body) != outputsPDF {
+			t.Errorf("put received %q", body)
+		}
+		var status int
+		fmt.Sscan(r.URL.Query().Get("status"), &status)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(put.Close)
+
+	s := newScriptedShareService(t, defaultConfig().Outbound, map[string]scriptedShareProvider{
+		"share": func(ctx context.Context, file io.Reader) (*ShareResponse, error) {
+			io.Copy(io.Discard, file)
+			return &ShareResponse{Success: true, Link: "https://share.example/a.pdf"}, nil
+		},
+	})
+	config := *s.cfg()
+	config.Render.WeasyPrintBin = fakeWeasyPrint(t, "cat "+writeTestPDF(t, []byte(outputsPDF)))
+	config.Security.AllowPrivateOutput = true
+	s.config.Store(&config)
+	return s, put.URL
+}
+
+// renderOutputs posts an HTML render with outputs to s
+func renderOutputs(s *PDFService, outputs []map[string]string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"html": "<h1>Hi</h1>", "outputs": outputs})
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/pdf/render/html?filename=invoice.pdf", strings.NewReader(string(body)))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.HandleHTMLRender(w, r)
+	return w
+}
+
+func TestRenderToOutputsRejectsInvalidDestinations(t *testing.T) {
+	s, putURL := newOutputsTestService(t)
+	eleven := make([]map[string]string, maxOutputDestinations+1)
+	for i := range eleven {
+		eleven[i] = map[string]string{"type": "put", "put_url": putURL + "?status=200"}
+	}
+
+	tests := []struct {
+		name    string
+		outputs []map[string]string
+		want    string
+	}{
+		{"too many destinations", eleven, "at most 10 destinations"},
+		{"two responses", []map[string]string{{"type": "response"}, {"type": "response"}}, "one response destination"},
+		{"empty", []map[string]string{}, "at least one destination"},
+		{"unknown type", []map[string]string{{"type": "fax"}}, "type"},
+		{"share without provider", []map[string]string{{"type": "share"}}, "require share_service"},
+		{"put settings on a share", []map[string]string{{"type": "share", "share_service": string(scriptedServices(t, "share")[0]), "put_url": putURL}}, "require type put"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := renderOutputs(s, tt.outputs)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("status %d body %s, want 400 with %q", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderToOutputsSummary(t *testing.T) {
+	s, putURL := newOutputsTestService(t)
+	w := renderOutputs(s, []map[string]string{
+		{"type": "put", "put_url": putURL + "?status=200"},
+		{"type": "share", "share_service": string(scriptedServices(t, "share")[0])},
+	})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d %s: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	var summary OutputsResult
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if !summary.Success || summary.Size != int64(len(outputsPDF)) || summary.Pages != 2 || len(summary.Results) != 2 {
+		t.Fatalf("summary %+v", summary)
+	}
+	if put, share := summary.Results[0], summary.Results[1]; put.Type != DestinationPut || !put.Output.Success || share.Type != DestinationShare || share.Share.Link != "https://share.example/a.pdf" {
+		t.Errorf("results %+v %+v", put, share)
+	}
+}
+
+func TestRenderToOutputsPartialFailureWithResponse(t *testing.T) {
+	s, putURL := newOutputsTestService(t)
+	w := renderOutputs(s, []map[string]string{
+		{"type": "put", "put_url": putURL + "?status=403"},
+		{"type": "response"},
+		{"type": "put", "put_url": putURL + "?status=201"},
+	})
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status %d, want 207: %s", w.Code, w.Body)
+	}
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type %q: %v", w.Header().Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil || part.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("summary part %v: %v", part.Header, err)
+	}
+	var summary OutputsResult
+	if err := json.NewDecoder(part).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Success || len(summary.Results) != 3 {
+		t.Fatalf("summary %+v", summary)
+	}
+	failed, response, put := summary.Results[0], summary.Results[1], summary.Results[2]
+	if failed.Success || failed.Output.Status != http.StatusForbidden || failed.Error == "" {
+		t.Errorf("failed put %+v", failed)
+	}
+	if response.Type != DestinationResponse || !response.Success || !put.Success || put.Output.Status != http.StatusCreated {
+		t.Errorf("results %+v %+v", response, put)
+	}
+
+	part, err = mr.NextPart()
+	if err != nil || part.Header.Get("Content-Type") != "application/pdf" || part.FileName() != "invoice.pdf" {
+		t.Fatalf("PDF part %v: %v", part.Header, err)
+	}
+	if pdf, _ := io.ReadAll(part); string(pdf) != outputsPDF {
+		t.Errorf("PDF part %q", pdf)
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("further parts: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cxjava/rest-weasyprint/client"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Problem codes are stable, clients match them instead of the detail text. They are defined by the client
+// package, so server and client cannot drift apart
+const (
+	CodeInvalidJSON         = client.CodeInvalidJSON
+	CodeInvalidRequest      = client.CodeInvalidRequest
+	CodeInvalidOption       = client.CodeInvalidOption
+	CodeMissingHTML         = client.CodeMissingHTML
+	CodeInvalidUpload       = client.CodeInvalidUpload
+	CodePayloadTooLarge     = client.CodePayloadTooLarge
+	CodeURLNotAllowed       = client.CodeURLNotAllowed
+	CodeUnauthorized        = client.CodeUnauthorized
+	CodeRateLimited         = client.CodeRateLimited
+	CodeRenderFailed        = client.CodeRenderFailed
+	CodeRenderTimeout       = client.CodeRenderTimeout
+	CodeResourceFetchFailed = client.CodeResourceFetchFailed
+	CodeShareFailed         = client.CodeShareFailed
+	CodeShareUnavailable    = client.CodeShareUnavailable
+	CodeOutputFailed        = client.CodeOutputFailed
+	CodeShareNotFound       = client.CodeShareNotFound
+	CodeShareExpired        = client.CodeShareExpired
+	CodePasswordRequired    = client.CodePasswordRequired
+	CodeNotFound            = client.CodeNotFound
+	CodeMethodNotAllowed    = client.CodeMethodNotAllowed
+	CodeInternalError       = client.CodeInternalError
+
+	problemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:rest-weasyprint:problem:"
+	maxDiagnostics     = 20
+	maxDiagnosticLen   = 500
+)
+
+// Problem is an RFC 7807 problem details response
+type Problem struct {
+	Type        string   `json:"type" doc:"urn:rest-weasyprint:problem: followed by code" schema:"required"`
+	Title       string   `json:"title" doc:"HTTP status text" schema:"required"`
+	Status      int      `json:"status" schema:"required"`
+	Detail      string   `json:"detail" doc:"Human readable explanation, may change between versions" schema:"required"`
+	Code        string   `json:"code" doc:"Stable machine readable error code" schema:"required"`
+	RequestID   string   `json:"request_id,omitempty" doc:"Request ID of the server logs"`
+	RenderID    string   `json:"render_id,omitempty" doc:"Render ID, also in the X-Render-ID header"`
+	Errors      []string `json:"errors,omitempty" doc:"Every validation problem of invalid requests"`
+	Diagnostics []string `json:"diagnostics,omitempty" doc:"WeasyPrint messages of failed renders"`
+}
+
+// problemError is an error with the problem code it is reported with
+type problemError struct {
+	code        string
+	err         error
+	errors      []string // Validation problems
+	diagnostics []string // WeasyPrint messages
+}
+
+func (e *problemError) Error() string { return e.err.Error() }
+
+func (e *problemError) Unwrap() error { return e.err }
+
+// withProblemCode returns err reported with code
+func withProblemCode(code string, err error) error {
+	return &problemError{code: code, err: err}
+}
+
+// problemCode returns the code err is reported with, fallback when it has none
+func problemCode(err error, fallback string) string {
+	var problem *problemError
+	if errors.As(err, &problem) {
+		return problem.code
+	}
+	return fallback
+}
+
+// writeProblem writes a problem response, replacing headers set for a PDF response
+func writeProblem(ctx context.Context, w http.ResponseWriter, status int, code, detail string) {
+	writeProblemDetails(ctx, w, &Problem{Status: status, Code: code, Detail: detail})
+}
+
+// writeErrorProblem writes err as a problem response, errors carrying a code are reported with it
+func writeErrorProblem(ctx context.Context, w http.ResponseWriter, status int, code string, err error) {
+	problem := &Problem{Status: status, Code: code, Detail: err.Error()}
+	var coded *problemError
+	if errors.As(err, &coded) {
+		problem.Code, problem.Errors, problem.Diagnostics = coded.code, coded.errors, coded.diagnostics
+	}
+	writeProblemDetails(ctx, w, problem)
+}
+
+// writeBodyProblem writes the problem response of a request body that could not be read
+func writeBodyProblem(ctx context.Context, w http.ResponseWriter, code, detail string, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(ctx, w, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	writeProblem(ctx, w, http.StatusBadRequest, code, detail+": "+err.Error())
+}
+
+// writeRenderProblem writes the problem response of a failed render
+func writeRenderProblem(ctx context.Context, w http.ResponseWriter, err error) {
+	problem := &Problem{Status: http.StatusInternalServerError, Code: CodeRenderFailed, Detail: "PDF generation failed"}
+	var coded *problemError
+	if errors.As(err, &coded) {
+		problem.Code, problem.Diagnostics = coded.code, coded.diagnostics
+	}
+	switch problem.Code {
+	case CodeRenderTimeout:
+		problem.Status, problem.Detail = http.StatusGatewayTimeout, "PDF generation did not finish in time"
+	case CodeResourceFetchFailed:
+		problem.Status, problem.Detail = http.StatusBadGateway, "PDF generation failed to fetch a resource"
+	case CodeURLNotAllowed:
+		problem.Status, problem.Detail = http.StatusForbidden, "URL not allowed: "+err.Error()
+	}
+	writeProblemDetails(ctx, w, problem)
+}
+
+// writeProblemDetails completes problem from the request context and writes it
+func writeProblemDetails(ctx context.Context, w http.ResponseWriter, problem *Problem) {
+	problem.Type = problemTypePrefix + problem.Code
+	problem.Title = http.StatusText(problem.Status)
+	problem.RequestID = middleware.GetReqID(ctx)
+	problem.RenderID = renderIDFromContext(ctx)
+
+	h := w.Header()
+	h.Del("Content-Disposition")
+	h.Del("Content-Length")
+	h.Set("Content-Type", problemContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// notFoundHandler and methodNotAllowedHandler replace the plain text router defaults
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(r.Context(), w, http.StatusNotFound, CodeNotFound, "no route for "+r.URL.Path)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(r.Context(), w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not supported by "+r.URL.Path)
+}
+
+// weasyPrintDiagnostics returns the messages WeasyPrint wrote to stderr without traceback frames
+func weasyPrintDiagnostics(stderr string) []string {
+	var diagnostics []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "Traceback ") {
+			continue
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if len(diagnostics) == maxDiagnostics {
+			diagnostics[maxDiagnostics-1] = "…"
+			break
+		}
+		if len(line) > maxDiagnosticLen {
+			line = line[:maxDiagnosticLen] + "…"
+		}
+		diagnostics = append(diagnostics, line)
+	}
+	return diagnostics
+}
+
+// renderFailureCode classifies a failed WeasyPrint run by its context and stderr
+func renderFailureCode(ctx context.Context, stderr string) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return CodeRenderTimeout
+	}
+	for _, marker := range []string{"URLError", "HTTPError", "Failed to load", "urlopen error"} {
+		if strings.Contains(stderr, marker) {
+			return CodeResourceFetchFailed
+		}
+	}
+	return CodeRenderFailed
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigReloader reloads the configuration at runtime, applying only settings that are safe to change
+type ConfigReloader struct {
+	args     []string // Command-line arguments, flags keep precedence on reload
+	service  *PDFService
+	logLevel *slog.LevelVar
+	mu       sync.Mutex // Serializes reloads
+}
+
+// ReloadResponse represents the admin reload endpoint response
+type ReloadResponse struct {
+	Success        bool     `json:"success"`
+	Message        string   `json:"message,omitempty"`
+	Changed        []string `json:"changed"`
+	RequireRestart []string `json:"require_restart,omitempty"`
+}
+
+// NewConfigReloader creates a reloader rebuilding the configuration from args
+func NewConfigReloader(args []string, service *PDFService, logLevel *slog.LevelVar) *ConfigReloader {
+	return &ConfigReloader{
+		args:     args,
+		service:  service,
+		logLevel: logLevel,
+	}
+}
+
+// Reload loads and validates the configuration and swaps in its reloadable settings.
+// An invalid configuration is rejected and the current one kept
+func (cr *ConfigReloader) Reload(ctx context.Context) (*ReloadResponse, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	loaded, _, err := loadConfig(cr.args)
+	if err != nil {
+		cr.service.logger.ErrorContext(ctx, "Configuration reload rejected, keeping current configuration", "error", err)
+		return nil, err
+	}
+
+	current := cr.service.cfg()
+	next := applyReloadable(current, loaded)
+
+	response := &ReloadResponse{
+		Success:        true,
+		Changed:        diffConfig(current, next),
+		RequireRestart: diffConfig(next, loaded),
+	}
+
+	if err := cr.service.setConfig(next); err != nil {
+		cr.service.logger.ErrorContext(ctx, "Configuration reload rejected, keeping current configuration", "error", err)
+		return nil, err
+	}
+	level, _ := next.Log.slogLevel() // Validated by loadConfig
+	cr.logLevel.Set(level)
+
+	for _, change := range response.Changed {
+		cr.service.logger.InfoContext(ctx, "Configuration changed", "change", change)
+	}
+	for _, change := range response.RequireRestart {
+		cr.service.logger.WarnContext(ctx, "Configuration change requires restart, ignored", "change", change)
+	}
+	cr.service.logger.InfoContext(ctx, "Configuration reloaded", "changes", len(response.Changed))
+
+	return response, nil
+}
+
+// WatchSignals reloads the configuration on every SIGHUP until ctx is done
+func (cr *ConfigReloader) WatchSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			cr.service.logger.InfoContext(ctx, "SIGHUP received, reloading configuration")
+			cr.Reload(ctx)
+		}
+	}
+}
+
+// HandleReload reloads the configuration, requires the configured admin key
+func (cr *ConfigReloader) HandleReload(w http.ResponseWriter, r *http.Request) {
+	adminKey := cr.service.cfg().Security.AdminKey
+	if adminKey == "" {
+		writeProblem(r.Context(), w, http.StatusNotFound, CodeNotFound, "admin endpoints are disabled")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(adminKey), []byte(requestAPIKey(r))) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="rest-weasyprint-admin"`)
+		writeProblem(r.Context(), w, http.StatusUnauthorized, CodeUnauthorized, "invalid or missing admin key")
+		return
+	}
+
+	response, err := cr.Reload(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(&ReloadResponse{Success: false, Message: err.Error(), Changed: []string{}})
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// applyReloadable returns a copy of current with the settings that are safe to change taken from loaded
+func applyReloadable(current, loaded *Config) *Config {
+	next := *current
+	next.Security = loaded.Security
+	next.Share = loaded.Share
+	next.Outbound = loaded.Outbound
+	next.Render.DefaultOptions = loaded.Render.DefaultOptions
+	next.Render.StrictOptions = loaded.Render.StrictOptions
+	next.Render.PageSize = loaded.Render.PageSize
+	next.Render.PageMargin = loaded.Render.PageMargin
+	next.Log.Level = loaded.Log.Level
+	return &next
+}
+
+// diffConfig lists the settings that differ between two configurations, secret values are not shown
+func diffConfig(from, to *Config) []string {
+	before, after := flattenConfig(from), flattenConfig(to)
+
+	keys := make(map[string]bool, len(before)+len(after))
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	changes := []string{}
+	for key := range keys {
+		oldValue, hadOld := before[key]
+		newValue, hasNew := after[key]
+		if hadOld && hasNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		switch {
+		case isSecretConfigKey(key):
+			changes = append(changes, key+" changed")
+		case !hadOld:
+			changes = append(changes, fmt.Sprintf("%s added: %v", key, newValue))
+		case !hasNew:
+			changes = append(changes, fmt.Sprintf("%s removed: %v", key, oldValue))
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", key, oldValue, newValue))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// flattenConfig maps dotted setting paths to values
+func flattenConfig(c *Config) map[string]interface{} {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil
+	}
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil
+	}
+
+	flat := make(map[string]interface{})
+	flattenInto(flat, "", tree)
+	return flat
+}
+
+// flattenInto adds the leaves of value to flat under prefix
+func flattenInto(flat map[string]interface{}, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			flattenInto(flat, path, child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenInto(flat, fmt.Sprintf("%s[%d]", prefix, i), child)
+		}
+	default:
+		flat[prefix] = v
+	}
+}
+
+// secretConfigSuffixes are the endings of setting paths holding secrets
+var secretConfigSuffixes = []string{".key", "api_key", "admin_key", "secret_access_key", "session_token", "password", "passphrase", "signing_key"}
+
+// isSecretConfigKey reports whether the setting at path holds a secret
+func isSecretConfigKey(path string) bool {
+	for _, suffix := range secretConfigSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyReloadableKeepsRestartSettings(t *testing.T) {
+	current := defaultConfig()
+	loaded := defaultConfig()
+	loaded.Server.Listen = ":9999"
+	loaded.Render.MaxConcurrentRenders = 99
+	loaded.Log.Format = LogFormatText
+	loaded.Render.PageSize = "Letter"
+	loaded.Render.StrictOptions = true
+	loaded.Log.Level = "debug"
+	loaded.Outbound.MaxRetries = 5
+	loaded.Security.AllowedHosts = []string{"example.com"}
+
+	next := applyReloadable(current, loaded)
+	if next.Server.Listen != current.Server.Listen || next.Render.MaxConcurrentRenders != current.Render.MaxConcurrentRenders || next.Log.Format != current.Log.Format {
+		t.Errorf("restart settings changed: listen %q renders %d log format %q", next.Server.Listen, next.Render.MaxConcurrentRenders, next.Log.Format)
+	}
+	if next.Render.PageSize != "Letter" || !next.Render.StrictOptions || next.Log.Level != "debug" || next.Outbound.MaxRetries != 5 || len(next.Security.AllowedHosts) != 1 {
+		t.Errorf("reloadable settings not applied: %+v", next)
+	}
+	if current.Render.PageSize != DefaultPageSize {
+		t.Errorf("current configuration modified")
+	}
+
+	want := []string{
+		"log.format: json -> text",
+		fmt.Sprintf("render.max_concurrent_renders: %d -> 99", current.Render.MaxConcurrentRenders),
+		"server.listen: :8080 -> :9999",
+	}
+	if restart := diffConfig(next, loaded); !slices.Equal(restart, want) {
+		t.Errorf("settings requiring a restart %q, want %q", restart, want)
+	}
+}
+
+func TestDiffConfigMasksSecrets(t *testing.T) {
+	from := defaultConfig()
+	from.Security.AdminKey = "old-admin-secret"
+	from.Share = map[string]ShareServiceConfig{"mail": {SMTP: SMTPConfig{Password: "old-smtp-secret"}}}
+
+	to := defaultConfig()
+	to.Security.AdminKey = "new-admin-secret"
+	to.Security.APIKeys = []APIKeyConfig{{Key: "new-api-secret", Tenant: "acme"}}
+	to.Share = map[string]ShareServiceConfig{
+		"mail":    {SMTP: SMTPConfig{Password: "new-smtp-secret"}},
+		"reports": {S3: S3Config{Bucket: "reports", SecretAccessKey: "new-s3-secret", SessionToken: "new-token-secret"}},
+	}
+
+	changes := diffConfig(from, to)
+	for _, change := range changes {
+		if strings.Contains(change, "-secret") {
+			t.Errorf("secret shown: %s", change)
+		}
+	}
+	for _, want := range []string{
+		"security.admin_key changed",
+		"security.api_keys[0].key changed",
+		"security.api_keys[0].tenant added: acme",
+		"share.mail.smtp.password changed",
+		"share.reports.s3.bucket added: reports",
+		"share.reports.s3.secret_access_key changed",
+		"share.reports.s3.session_token changed",
+	} {
+		if !slices.Contains(changes, want) {
+			t.Errorf("changes %q lack %q", changes, want)
+		}
+	}
+}
+
+func TestHandleReload(t *testing.T) {
+	file := writeConfigFile(t, "config.yaml", "security:\n  admin_key: adm\n")
+	args := []string{"-config", file, "-temp-dir", t.TempDir()}
+	config, _, err := loadConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t, config)
+	reloader := NewConfigReloader(args, s, &slog.LevelVar{})
+
+	reload := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		reloader.HandleReload(w, r)
+		return w
+	}
+
+	for _, key := range []string{"", "wrong"} {
+		if w := reload(key); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("key %q: status %d, want 401", key, w.Code)
+		}
+	}
+
+	os.WriteFile(file, []byte("server:\n  listen: \":9999\"\nrender:\n  page_size: Letter\nsecurity:\n  admin_key: adm\n"), 0o600)
+	w := reload("adm")
+	var response ReloadResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil || w.Code != http.StatusOK || !response.Success {
+		t.Fatalf("status %d response %+v: %v", w.Code, response, err)
+	}
+	if !slices.Equal(response.Changed, []string{"render.page_size: A4 -> Letter"}) || !slices.Equal(response.RequireRestart, []string{"server.listen: :8080 -> :9999"}) {
+		t.Errorf("changed %q require restart %q", response.Changed, response.RequireRestart)
+	}
+	if s.cfg().Render.PageSize != "Letter" || s.cfg().Server.Listen != DefaultPort {
+		t.Errorf("page size %q listen %q after reload", s.cfg().Render.PageSize, s.cfg().Server.Listen)
+	}
+
+	// An invalid configuration is rejected and the current one kept
+	os.WriteFile(file, []byte("render:\n  page_size: A3\n  max_upload_mb: 0\nsecurity:\n  admin_key: adm\n"), 0o600)
+	if w := reload("adm"); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "max_upload_mb") {
+		t.Errorf("status %d body %s, want 422", w.Code, w.Body)
+	}
+	if s.cfg().Render.PageSize != "Letter" {
+		t.Errorf("invalid configuration applied")
+	}
+
+	// Without an admin key the endpoint does not exist, also once reloaded without one
+	os.WriteFile(file, []byte("render:\n  page_size: Letter\n"), 0o600)
+	if w := reload("adm"); w.Code != http.StatusOK {
+		t.Fatalf("status %d removing the admin key", w.Code)
+	}
+	for _, key := range []string{"", "adm"} {
+		if w := reload(key); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), CodeNotFound) {
+			t.Errorf("key %q: status %d body %s, want 404", key, w.Code, w.Body)
+		}
+	}
+}
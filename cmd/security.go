@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRateLimitClients bounds the number of tracked clients before idle ones are pruned
+const maxRateLimitClients = 10000
+
+// tenantKey and verifiedTenantKey are the context keys of the request's tenant
+type (
+	tenantKey         struct{}
+	verifiedTenantKey struct{}
+)
+
+// tenantFromContext returns the tenant of the request, empty if unknown. Without authentication it is
+// the X-Tenant-ID header, so it only labels logs, share paths and metadata
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// verifiedTenantFromContext returns the tenant authenticated by API key or client certificate,
+// empty when the request was not authenticated
+func verifiedTenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(verifiedTenantKey{}).(string)
+	return tenant
+}
+
+// withTenant stores the tenant in the context and its log attributes, verified tenants were authenticated
+func withTenant(ctx context.Context, tenant string, verified bool) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, tenantKey{}, tenant)
+	if verified {
+		ctx = context.WithValue(ctx, verifiedTenantKey{}, tenant)
+	}
+	return withLogAttrs(ctx, slog.String("tenant", tenant))
+}
+
+// authMiddleware authenticates API requests and applies rate limits.
+// A verified client certificate authenticates its mapped tenant. Otherwise API keys are required when
+// configured, and without them the tenant is taken from the X-Tenant-ID header. Rate limits apply per
+// authenticated tenant, requests without one are limited per client address
+func (s *PDFService) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		security := s.cfg().Security
+
+		tenant, verified := strings.TrimSpace(r.Header.Get("X-Tenant-ID")), false
+		if certTenant, ok := clientCertTenant(r, security.ClientCerts); ok {
+			tenant, verified = certTenant, true
+		} else if len(security.APIKeys) > 0 {
+			key, ok := authenticate(security.APIKeys, requestAPIKey(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="rest-weasyprint"`)
+				writeProblem(r.Context(), w, http.StatusUnauthorized, CodeUnauthorized, "invalid or missing API key")
+				return
+			}
+			tenant, verified = key.Tenant, true
+		}
+
+		clientKey := "tenant:" + tenant
+		if !verified || tenant == "" {
+			clientKey = "ip:" + clientIP(r)
+		}
+		if retryAfter, ok := s.rateLimiter.Allow(clientKey, security.RateLimit); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+1)))
+			writeProblem(r.Context(), w, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant, verified)))
+	})
+}
+
+// realIPMiddleware sets RemoteAddr to the client address forwarded by a trusted proxy. X-Forwarded-For
+// is read from the right, skipping trusted proxies, and X-Real-IP is used without it. Requests from
+// other peers keep their connection address, so clients cannot choose the address they are limited by
+func (s *PDFService) realIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trusted := s.cfg().Security.TrustedProxies
+		if len(trusted) == 0 || !isTrustedProxy(trusted, clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := ""
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+			if err != nil {
+				break
+			}
+			client = addr.String()
+			if !isTrustedProxy(trusted, client) {
+				break
+			}
+		}
+		if client == "" {
+			if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+				client = addr.String()
+			}
+		}
+		if client != "" {
+			r.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isTrustedProxy reports whether ip matches one of the trusted proxy addresses or CIDR ranges
+func isTrustedProxy(trusted []string, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range trusted {
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+		} else if proxyAddr, err := netip.ParseAddr(proxy); err == nil && proxyAddr.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// clientCertTenant maps the verified client certificate subject to a tenant.
+// Unmapped subjects use their common name
+func clientCertTenant(r *http.Request, mappings []ClientCertConfig) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, mapping := range mappings {
+		if mapping.CommonName == commonName {
+			return mapping.Tenant, true
+		}
+	}
+	return commonName, commonName != ""
+}
+
+// requestAPIKey extracts the API key from the X-API-Key or Authorization header
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// authenticate finds the configured key matching presented in constant time
+func authenticate(keys []APIKeyConfig, presented string) (APIKeyConfig, bool) {
+	if presented == "" {
+		return APIKeyConfig{}, false
+	}
+	var match APIKeyConfig
+	found := false
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(presented)) == 1 {
+			match, found = key, true
+		}
+	}
+	return match, found
+}
+
+// clientIP returns the client address without port, realIPMiddleware has already applied trusted proxy headers
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkAllowedURL verifies a remote URL's host is permitted by the configured allowed hosts.
+// Entries match exactly or, with a leading "*.", any subdomain
+func checkAllowedURL(allowedHosts []string, rawURL string) error {
+	if len(allowedHosts) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return nil
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok && strings.HasSuffix(host, "."+suffix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not allowed", host)
+}
+
+// RateLimiter is a token bucket rate limiter per client key
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket tracks available tokens of a single client
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates an empty rate limiter
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token for key, returns how long to wait when the limit is exceeded
+func (l *RateLimiter) Allow(key string, limit RateLimitConfig) (time.Duration, bool) {
+	if limit.RequestsPerMinute <= 0 {
+		return 0, true
+	}
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = float64(limit.RequestsPerMinute)
+	}
+	perSecond := float64(limit.RequestsPerMinute) / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitClients {
+			l.prune(now, burst, perSecond)
+		}
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*perSecond)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second)), false
+	}
+	bucket.tokens--
+	return 0, true
+}
+
+// prune removes clients whose buckets have refilled completely
+func (l *RateLimiter) prune(now time.Time, burst, perSecond float64) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*perSecond >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
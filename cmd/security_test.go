@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues client certificates for mutual TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// writePEM writes the CA certificate to a file and returns its path
+func (ca *testCA) writePEM(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600)
+	return path
+}
+
+// clientCert issues a client certificate for commonName
+func (ca *testCA) clientCert(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCertTenant(t *testing.T) {
+	ca := newTestCA(t)
+	config := defaultConfig()
+	config.Security.APIKeys = []APIKeyConfig{{Key: "key-1", Tenant: "acme"}}
+	config.Security.ClientCerts = []ClientCertConfig{{CommonName: "billing-svc", Tenant: "billing"}}
+	s := newTestService(t, config)
+
+	// The server's own certificate comes from httptest, client certificates are verified against ca
+	tlsConfig := &tls.Config{ClientCAs: x509.NewCertPool(), ClientAuth: tls.VerifyClientCertIfGiven}
+	tlsConfig.ClientCAs.AddCert(ca.cert)
+	server := httptest.NewUnstartedServer(s.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, tenantFromContext(r.Context())+"/"+verifiedTenantFromContext(r.Context()))
+	})))
+	server.TLS = tlsConfig
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // Rejected handshakes are expected
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		cert   tls.Certificate
+		apiKey string
+		status int
+		tenant string // Tenant and verified tenant
+	}{
+		{"mapped certificate", ca.clientCert(t, "billing-svc"), "", http.StatusOK, "billing/billing"},
+		{"unmapped certificate uses its common name", ca.clientCert(t, "reports"), "", http.StatusOK, "reports/reports"},
+		{"certificate wins over an API key", ca.clientCert(t, "billing-svc"), "key-1", http.StatusOK, "billing/billing"},
+		{"certificate without common name needs an API key", ca.clientCert(t, ""), "", http.StatusUnauthorized, ""},
+		{"API key without certificate", tls.Certificate{}, "key-1", http.StatusOK, "acme/acme"},
+		{"neither", tls.Certificate{}, "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := server.Client().Transport.(*http.Transport).Clone()
+			if tt.cert.Certificate != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{tt.cert}
+			}
+			r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			r.Header.Set("X-Tenant-ID", "spoofed") // Ignored once a tenant is authenticated
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			resp, err := (&http.Client{Transport: transport}).Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status || (tt.status == http.StatusOK && string(body) != tt.tenant) {
+				t.Errorf("status %d tenant %q, want %d %q", resp.StatusCode, body, tt.status, tt.tenant)
+			}
+		})
+	}
+
+	// Certificates of other CAs are rejected in the handshake
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{newTestCA(t).clientCert(t, "billing-svc")}
+	if resp, err := (&http.Client{Transport: transport}).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("certificate of an unknown CA accepted with status %d", resp.StatusCode)
+	}
+}
+
+func TestNewTLSConfigClientAuth(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert := ca.clientCert(t, "server")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+	keyDER, _ := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	for mode, want := range map[string]tls.ClientAuthType{
+		ClientAuthNone:    tls.NoClientCert,
+		ClientAuthRequest: tls.VerifyClientCertIfGiven,
+		ClientAuthRequire: tls.RequireAndVerifyClientCert,
+		"":                tls.RequireAndVerifyClientCert,
+	} {
+		config, err := newTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: ca.writePEM(t), ClientAuth: mode}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.ClientAuth != want || config.ClientCAs == nil {
+			t.Errorf("client_auth %q: %v, want %v", mode, config.ClientAuth, want)
+		}
+	}
+}
@@ -1,17 +1,102 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // PDFService encapsulates PDF generation related logic
 type PDFService struct {
-	logger *log.Logger
+	config          atomic.Pointer[Config] // Swapped on configuration reload
+	shares          atomic.Pointer[shareRegistry]
+	breakers        circuitBreakers // Share provider circuits, kept across reloads
+	logger          *slog.Logger
+	metrics         *Metrics
+	renderSlots     chan struct{} // Limits concurrent WeasyPrint processes
+	queued          atomic.Int64  // Renders waiting for a slot
+	tempPaths       sync.Map      // Temporary files and directories in use
+	renders         sync.WaitGroup
+	rateLimiter     *RateLimiter
+	passwordClients *RateLimiter // Password attempts of local shares per client
+	passwordShares  *RateLimiter // Password attempts of local shares per share
 }
 
 // NewPDFService creates a new PDF service instance
-func NewPDFService(logger *log.Logger) *PDFService {
-	return &PDFService{
-		logger: logger,
+func NewPDFService(config *Config, logger *slog.Logger, metrics *Metrics) (*PDFService, error) {
+	s := &PDFService{
+		logger:          logger,
+		metrics:         metrics,
+		renderSlots:     make(chan struct{}, config.Render.MaxConcurrentRenders),
+		rateLimiter:     NewRateLimiter(),
+		passwordClients: NewRateLimiter(),
+		passwordShares:  NewRateLimiter(),
+	}
+	if err := s.setConfig(config); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// cfg returns the current configuration
+func (s *PDFService) cfg() *Config {
+	return s.config.Load()
+}
+
+// setConfig swaps in a configuration, the share providers built from it and the outbound client settings
+func (s *PDFService) setConfig(config *Config) error {
+	shares, err := newShareRegistry(config.Share)
+	if err != nil {
+		return err
+	}
+	s.shares.Store(shares)
+	s.config.Store(config)
+	configureOutbound(config.Outbound)
+	return nil
+}
+
+// acquireRenderSlot waits for a free WeasyPrint slot, returns a release function
+func (s *PDFService) acquireRenderSlot(ctx context.Context) (func(), error) {
+	s.metrics.rendersQueued.Inc()
+	s.queued.Add(1)
+	defer func() {
+		s.metrics.rendersQueued.Dec()
+		s.queued.Add(-1)
+	}()
+
+	select {
+	case s.renderSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	s.metrics.rendersInFlight.Inc()
+	return func() {
+		s.metrics.rendersInFlight.Dec()
+		<-s.renderSlots
+	}, nil
+}
+
+// beginRender registers an in-flight render request, call the returned function when done
+func (s *PDFService) beginRender() func() {
+	s.renders.Add(1)
+	return s.renders.Done
+}
+
+// waitForRenders waits until in-flight render requests have returned or timeout elapses
+func (s *PDFService) waitForRenders(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.renders.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
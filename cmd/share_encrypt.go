@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cxjava/rest-weasyprint/client"
+)
+
+// Encrypted shares are uploaded with this extension and served with shareEncryptedContentType
+const (
+	encryptedShareExtension   = ".enc"
+	shareEncryptedContentType = "application/octet-stream"
+	decryptRoute              = "/decrypt"
+)
+
+//go:embed decrypt.html
+var decryptPage []byte
+
+// ShareEncryption describes how an encrypted share is retrieved, the key is only known to the requester
+type ShareEncryption struct {
+	Algorithm    string `json:"algorithm" doc:"Cipher of the uploaded file, AES-256-GCM in 64 KiB segments" schema:"required"`
+	Key          string `json:"key" doc:"Base64url encoded key, also in the fragment of link" schema:"required"`
+	DownloadLink string `json:"download_link" doc:"Provider link of the ciphertext" schema:"required"`
+	Command      string `json:"decrypt_command" doc:"Command decrypting the share with the rest-weasyprint CLI"`
+}
+
+// shareEncryptionKey is the context key of the encryption key of a request's share
+type shareEncryptionKey struct{}
+
+// withShareEncryption stores the key the request's share is encrypted with in the context
+func withShareEncryption(ctx context.Context, key []byte) context.Context {
+	if key == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, shareEncryptionKey{}, key)
+}
+
+// shareEncryptionFromContext returns the encryption key of the request's share, nil when it is not encrypted
+func shareEncryptionFromContext(ctx context.Context) []byte {
+	key, _ := ctx.Value(shareEncryptionKey{}).([]byte)
+	return key
+}
+
+// newShareEncryption checks that services can store an encrypted share and returns a new key,
+// nil when encrypt is not set
+func (s *PDFService) newShareEncryption(services []FileShareService, encrypt bool) ([]byte, error) {
+	if !encrypt {
+		return nil, nil
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("encrypt_share requires share_service")
+	}
+	for _, service := range services {
+		provider, err := s.shareProvider(service)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := provider.(*smtpProvider); ok {
+			return nil, fmt.Errorf("share_service %s: encrypt_share is not supported for email delivery", service)
+		}
+	}
+	key, err := client.NewEncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encryption key: %v", err)
+	}
+	return key, nil
+}
+
+// encryptedGenerate returns a generate function encrypting the PDF of generate with key
+func encryptedGenerate(key []byte, generate func(ctx context.Context, w io.Writer) error) func(ctx context.Context, w io.Writer) error {
+	return func(ctx context.Context, w io.Writer) error {
+		encrypter, err := client.NewEncrypter(w, key)
+		if err != nil {
+			return err
+		}
+		if err := generate(ctx, encrypter); err != nil {
+			return err
+		}
+		return encrypter.Close()
+	}
+}
+
+// encryptShareResponse replaces the link of response by the decrypt page link below baseURL carrying key in its fragment
+func encryptShareResponse(response *ShareResponse, baseURL string, key []byte) {
+	filename := strings.TrimSuffix(response.Filename, encryptedShareExtension)
+	link := client.DecryptLink(baseURL+decryptRoute, response.Link, filename, key)
+	response.Encryption = &ShareEncryption{
+		Algorithm:    client.EncryptionAlgorithm,
+		Key:          client.EncodeKey(key),
+		DownloadLink: response.Link,
+		Command:      "rest-weasyprint decrypt -o " + shellQuote(filename) + " " + shellQuote(link),
+	}
+	response.Link = link
+}
+
+// shellQuote quotes s for POSIX shells unless it only contains safe characters
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-+/") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shareContentType returns the content type providers store a shared file with
+func shareContentType(filename string) string {
+	if strings.HasSuffix(filename, encryptedShareExtension) {
+		return shareEncryptedContentType
+	}
+	return "application/pdf"
+}
+
+// decryptHandler serves the page decrypting encrypted shares in the browser, the key never reaches a server
+func decryptHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src *; img-src blob:")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Write(decryptPage)
+}
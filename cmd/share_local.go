@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Local share defaults
+const (
+	defaultLocalShareExpiry   = 24 * time.Hour
+	localShareJanitorInterval = time.Minute
+	localShareRoute           = "/s/{token}"
+	localShareKeyFile         = ".signing-key"
+	localSharePasswordRounds  = 100000
+	localShareOrphanAge       = time.Hour // Age after which PDFs without metadata are removed
+)
+
+// Password checks of local shares derive a PBKDF2 key each, they are limited per client and per share
+var (
+	localSharePasswordClientLimit = RateLimitConfig{RequestsPerMinute: 10, Burst: 5}
+	localSharePasswordShareLimit  = RateLimitConfig{RequestsPerMinute: 30, Burst: 10}
+)
+
+// Local share lookup errors
+var (
+	errLocalShareNotFound = errors.New("share not found")
+	errLocalShareGone     = errors.New("share expired")
+)
+
+// localShareMu serializes metadata updates, providers are recreated on reload while downloads continue
+var localShareMu sync.Mutex
+
+func init() {
+	RegisterShareProvider("local", newLocalShareProvider)
+}
+
+// localShareProvider stores PDFs on the service's disk and serves them at /s/{token}
+type localShareProvider struct {
+	name       string
+	config     LocalShareConfig
+	expiry     time.Duration
+	signingKey func() ([]byte, error)
+}
+
+// localShareMeta is stored next to each shared PDF
+type localShareMeta struct {
+	Filename     string    `json:"filename"`
+	Tenant       string    `json:"tenant,omitempty"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	SHA256       string    `json:"sha256"`
+	PasswordSalt []byte    `json:"password_salt,omitempty"`
+	PasswordHash []byte    `json:"password_hash,omitempty"`
+}
+
+// exhausted reports whether the share can no longer be downloaded at now
+func (m *localShareMeta) exhausted(now time.Time) bool {
+	return !now.Before(m.Expires) || (m.MaxDownloads > 0 && m.Downloads >= m.MaxDownloads)
+}
+
+// newLocalShareProvider creates a local provider, expires is a Go duration such as 72h
+func newLocalShareProvider(name string, config ShareServiceConfig) (ShareProvider, error) {
+	c := config.Local
+	if c.Directory == "" {
+		c.Directory = filepath.Join(os.TempDir(), "rest-weasyprint-shares")
+	}
+
+	var errs []error
+	expiry := defaultLocalShareExpiry
+	if config.Expires != "" {
+		var err error
+		if expiry, err = time.ParseDuration(config.Expires); err != nil || expiry <= 0 {
+			errs = append(errs, fmt.Errorf("expires must be a positive duration such as 72h"))
+		}
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("local.public_url must be an http(s) URL"))
+		}
+	}
+	if c.MaxDownloads < 0 {
+		errs = append(errs, fmt.Errorf("local.max_downloads must not be negative"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	p := &localShareProvider{name: name, config: c, expiry: expiry}
+	p.signingKey = sync.OnceValues(p.loadSigningKey)
+	return p, nil
+}
+
+// loadSigningKey returns the configured key or the one generated into the share directory on first use
+func (p *localShareProvider) loadSigningKey() ([]byte, error) {
+	if p.config.SigningKey != "" {
+		return []byte(p.config.SigningKey), nil
+	}
+	if err := os.MkdirAll(p.config.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create share directory: %v", err)
+	}
+
+	path := filepath.Join(p.config.Directory, localShareKeyFile)
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) >= 32 {
+		return key, nil
+	}
+	key = make([]byte, 32)
+	rand.Read(key)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		// Another provider instance created it first
+		return os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing key: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(key); err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %v", err)
+	}
+	return key, nil
+}
+
+// Upload stores the PDF and its metadata and returns a signed link
+func (p *localShareProvider) Upload(ctx context.Context, file io.Reader, filename string) (*ShareResponse, error) {
+	if err := os.MkdirAll(p.config.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create share directory: %v", err)
+	}
+	key, err := p.signingKey()
+	if err != nil {
+		return nil, err
+	}
+
+	idBytes := make([]byte, 16)
+	rand.Read(idBytes)
+	id := base64.RawURLEncoding.EncodeToString(idBytes)
+
+	tmp, err := os.CreateTemp(p.config.Directory, ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share file: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after the rename
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), file); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to store PDF: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to store PDF: %v", err)
+	}
+
+	// Request share options can only tighten the provider's settings, see checkShareOptions
+	expiry, maxDownloads, password := p.expiry, p.config.MaxDownloads, p.config.Password
+	if opts := shareOptionsFromContext(ctx); opts != nil {
+		if opts.expiry > 0 {
+			expiry = min(expiry, opts.expiry)
+		}
+		if opts.MaxDownloads > 0 && (maxDownloads == 0 || opts.MaxDownloads < maxDownloads) {
+			maxDownloads = opts.MaxDownloads
+		}
+		if opts.AutoDelete {
+			maxDownloads = 1 // Exhausted shares are removed
+		}
+		if opts.Password != "" && password == "" {
+			password = opts.Password
+		}
+	}
+
+	now := time.Now().UTC()
+	meta := &localShareMeta{
+		Filename:     filename,
+		Tenant:       tenantFromContext(ctx),
+		Created:      now,
+		Expires:      now.Add(expiry),
+		MaxDownloads: maxDownloads,
+		SHA256:       hex.EncodeToString(hash.Sum(nil)),
+	}
+	if password != "" {
+		meta.PasswordSalt = make([]byte, 16)
+		rand.Read(meta.PasswordSalt)
+		meta.PasswordHash = hashSharePassword(password, meta.PasswordSalt)
+	}
+
+	// Metadata first, the janitor removes PDFs without it
+	if err := p.writeMeta(id, meta); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), p.pdfPath(id)); err != nil {
+		os.Remove(p.metaPath(id))
+		return nil, fmt.Errorf("failed to store PDF: %v", err)
+	}
+
+	token := id + "." + signShareID(key, id)
+	return &ShareResponse{
+		Success: true,
+		Link:    p.baseURL(ctx) + "/s/" + token,
+		Expires: &meta.Expires,
+	}, nil
+}
+
+// checkShareOptions accepts all share options within the provider's settings, which are upper limits:
+// a shorter expiry, fewer downloads, and a password only when the provider sets none
+func (p *localShareProvider) checkShareOptions(opts *ShareOptions) error {
+	var errs []error
+	if opts.expiry > p.expiry {
+		errs = append(errs, fmt.Errorf("expires must be at most %s", p.expiry))
+	}
+	if limit := p.config.MaxDownloads; limit > 0 && opts.MaxDownloads > limit {
+		errs = append(errs, fmt.Errorf("max_downloads must be at most %d", limit))
+	}
+	if opts.Password != "" && p.config.Password != "" {
+		errs = append(errs, errors.New("password is set by the provider"))
+	}
+	return errors.Join(errs...)
+}
+
+// baseURL returns the public URL links start with, defaulting to the URL the request reached
+func (p *localShareProvider) baseURL(ctx context.Context) string {
+	if p.config.PublicURL != "" {
+		return strings.TrimSuffix(p.config.PublicURL, "/")
+	}
+	return requestBaseURLFromContext(ctx)
+}
+
+func (p *localShareProvider) pdfPath(id string) string {
+	return filepath.Join(p.config.Directory, id+".pdf")
+}
+func (p *localShareProvider) metaPath(id string) string {
+	return filepath.Join(p.config.Directory, id+".json")
+}
+
+// readMeta loads the metadata of share id
+func (p *localShareProvider) readMeta(id string) (*localShareMeta, error) {
+	data, err := os.ReadFile(p.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errLocalShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta localShareMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid share metadata: %v", err)
+	}
+	return &meta, nil
+}
+
+// writeMeta atomically replaces the metadata of share id
+func (p *localShareProvider) writeMeta(id string, meta *localShareMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(p.config.Directory, ".meta-*")
+	if err != nil {
+		return fmt.Errorf("failed to write share metadata: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write share metadata: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write share metadata: %v", err)
+	}
+	return os.Rename(tmp.Name(), p.metaPath(id))
+}
+
+// remove deletes share id
+func (p *localShareProvider) remove(id string) {
+	os.Remove(p.pdfPath(id))
+	os.Remove(p.metaPath(id))
+}
+
+// lookup verifies token and returns the share ID and metadata, errLocalShareNotFound for foreign tokens
+func (p *localShareProvider) lookup(token string) (string, *localShareMeta, error) {
+	id, signature, ok := strings.Cut(token, ".")
+	if !ok || len(id) != 22 {
+		return "", nil, errLocalShareNotFound
+	}
+	key, err := p.signingKey()
+	if err != nil {
+		return "", nil, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(signShareID(key, id))) {
+		return "", nil, errLocalShareNotFound
+	}
+
+	meta, err := p.readMeta(id)
+	if err != nil {
+		return "", nil, err
+	}
+	if meta.exhausted(time.Now()) {
+		p.remove(id)
+		return "", nil, errLocalShareGone
+	}
+	return id, meta, nil
+}
+
+// claimDownload counts a download of share id, it fails when the share was exhausted meanwhile
+func (p *localShareProvider) claimDownload(id string) (*localShareMeta, error) {
+	localShareMu.Lock()
+	defer localShareMu.Unlock()
+
+	meta, err := p.readMeta(id)
+	if err != nil {
+		return nil, err
+	}
+	if meta.exhausted(time.Now()) {
+		return nil, errLocalShareGone
+	}
+	meta.Downloads++
+	if err := p.writeMeta(id, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// removeExpired deletes exhausted shares and PDFs left without metadata
+func (p *localShareProvider) removeExpired(now time.Time) (removed int) {
+	entries, err := os.ReadDir(p.config.Directory)
+	if err != nil {
+		return 0
+	}
+
+	localShareMu.Lock()
+	defer localShareMu.Unlock()
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, ".json"):
+			id := strings.TrimSuffix(name, ".json")
+			meta, err := p.readMeta(id)
+			if errors.Is(err, errLocalShareNotFound) || (err == nil && !meta.exhausted(now)) {
+				continue
+			}
+			p.remove(id)
+			removed++
+		case strings.HasSuffix(name, ".pdf") || strings.HasPrefix(name, ".upload-") || strings.HasPrefix(name, ".meta-"):
+			id := strings.TrimSuffix(name, ".pdf")
+			if _, err := os.Stat(p.metaPath(id)); err == nil && id != name {
+				continue
+			}
+			if info, err := entry.Info(); err == nil && now.Sub(info.ModTime()) > localShareOrphanAge {
+				os.Remove(filepath.Join(p.config.Directory, name))
+			}
+		}
+	}
+	return removed
+}
+
+// signShareID returns the signature of a share ID in links
+func signShareID(key []byte, id string) string {
+	return base64.RawURLEncoding.EncodeToString(hmacSHA256(key, id)[:16])
+}
+
+// hashSharePassword derives the stored hash of a share password
+func hashSharePassword(password string, salt []byte) []byte {
+	hash, _ := pbkdf2.Key(sha256.New, password, salt, localSharePasswordRounds, 32)
+	return hash
+}
+
+// localShareProviders returns the configured local share providers
+func (s *PDFService) localShareProviders() []*localShareProvider {
+	var providers []*localShareProvider
+	for _, provider := range s.shares.Load().providers {
+		if local, ok := provider.(*localShareProvider); ok {
+			providers = append(providers, local)
+		}
+	}
+	return providers
+}
+
+// HandleShareDownload serves a locally shared PDF, honouring expiry, download limit and password
+func (s *PDFService) HandleShareDownload(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	ctx := r.Context()
+
+	var provider *localShareProvider
+	var id string
+	var meta *localShareMeta
+	err := errLocalShareNotFound
+	for _, local := range s.localShareProviders() {
+		id, meta, err = local.lookup(token)
+		if !errors.Is(err, errLocalShareNotFound) {
+			provider = local
+			break
+		}
+	}
+	switch {
+	case errors.Is(err, errLocalShareNotFound):
+		writeProblem(ctx, w, http.StatusNotFound, CodeShareNotFound, "Share not found")
+		return
+	case errors.Is(err, errLocalShareGone):
+		writeProblem(ctx, w, http.StatusGone, CodeShareExpired, "Share expired")
+		return
+	case err != nil:
+		s.logger.ErrorContext(ctx, "Share lookup failed", "error", err)
+		writeProblem(ctx, w, http.StatusInternalServerError, CodeInternalError, "Share lookup failed")
+		return
+	}
+
+	if meta.PasswordHash != nil {
+		_, password, ok := r.BasicAuth()
+		if ok {
+			if retryAfter, allowed := s.allowPasswordCheck(r, id); !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+1)))
+				writeProblem(ctx, w, http.StatusTooManyRequests, CodeRateLimited, "Too many password attempts")
+				return
+			}
+		}
+		if !ok || subtle.ConstantTimeCompare(hashSharePassword(password, meta.PasswordSalt), meta.PasswordHash) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="shared document", charset="UTF-8"`)
+			writeProblem(ctx, w, http.StatusUnauthorized, CodePasswordRequired, "Password required")
+			return
+		}
+	}
+
+	file, err := os.Open(provider.pdfPath(id))
+	if err != nil {
+		writeProblem(ctx, w, http.StatusNotFound, CodeShareNotFound, "Share not found")
+		return
+	}
+	defer file.Close()
+
+	etag := `"` + meta.SHA256 + `"`
+	if countsAsDownload(r, etag) {
+		if meta, err = provider.claimDownload(id); err != nil {
+			writeProblem(ctx, w, http.StatusGone, CodeShareExpired, "Share expired")
+			return
+		}
+		s.logger.InfoContext(ctx, "Share downloaded", "provider", provider.name, "tenant", meta.Tenant, "downloads", meta.Downloads)
+		if meta.exhausted(time.Now()) {
+			// The open file stays readable after removal
+			defer provider.remove(id)
+		}
+	}
+
+	w.Header().Set("Content-Type", shareContentType(meta.Filename))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": meta.Filename}))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	http.ServeContent(w, r, meta.Filename, meta.Created, file)
+}
+
+// countsAsDownload reports whether r transfers content of the PDF, every GET except a revalidation
+// answered with 304. Range requests count too, so max_downloads cannot be bypassed piecewise
+func countsAsDownload(r *http.Request, etag string) bool {
+	return r.Method == http.MethodGet && r.Header.Get("If-None-Match") != etag
+}
+
+// allowPasswordCheck takes a password attempt of the client of r for share id,
+// returning how long to wait when the client or share has too many. Clients and shares have
+// their own limiters as a limiter prunes its buckets by the limit of the key being taken
+func (s *PDFService) allowPasswordCheck(r *http.Request, id string) (time.Duration, bool) {
+	if retryAfter, ok := s.passwordClients.Allow(clientIP(r), localSharePasswordClientLimit); !ok {
+		return retryAfter, false
+	}
+	return s.passwordShares.Allow(id, localSharePasswordShareLimit)
+}
+
+// RunShareJanitor removes expired local shares until ctx is done
+func (s *PDFService) RunShareJanitor(ctx context.Context) {
+	ticker := time.NewTicker(localShareJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, provider := range s.localShareProviders() {
+			if removed := provider.removeExpired(time.Now()); removed > 0 {
+				s.logger.Info("Removed expired shares", "provider", provider.name, "count", removed)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
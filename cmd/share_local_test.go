@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPasswordCheckLimits(t *testing.T) {
+	s := newTestService(t, defaultConfig())
+	attempt := func(ip, id string) (time.Duration, bool) {
+		r := httptest.NewRequest(http.MethodGet, "/s/"+id, nil)
+		r.RemoteAddr = ip + ":1234"
+		return s.allowPasswordCheck(r, id)
+	}
+
+	// A client runs out of attempts before the share does
+	for i := range localSharePasswordClientLimit.Burst {
+		if _, ok := attempt("10.0.0.1", "a"); !ok {
+			t.Fatalf("attempt %d of the client denied", i+1)
+		}
+	}
+	if retryAfter, ok := attempt("10.0.0.1", "a"); ok || retryAfter <= 0 {
+		t.Fatalf("attempt over the client limit allowed, retry after %v", retryAfter)
+	}
+	if _, ok := attempt("10.0.0.1", "b"); ok {
+		t.Errorf("limited client allowed on another share")
+	}
+
+	// Other clients share the remaining attempts of the share, denied ones do not count
+	for i := range localSharePasswordShareLimit.Burst - localSharePasswordClientLimit.Burst {
+		if _, ok := attempt(fmt.Sprintf("10.0.1.%d", i), "a"); !ok {
+			t.Fatalf("attempt %d of another client denied", i+1)
+		}
+	}
+	if retryAfter, ok := attempt("10.0.2.1", "a"); ok || retryAfter <= 0 || retryAfter > 2*time.Second {
+		t.Fatalf("attempt over the share limit: allowed %v, retry after %v", ok, retryAfter)
+	}
+	if _, ok := attempt("10.0.2.1", "c"); !ok {
+		t.Errorf("client denied on another share after a share limit")
+	}
+
+	// Each limiter only tracks its own key type, so pruning one never resets the other
+	for key := range s.passwordClients.buckets {
+		if key == "a" || key == "b" || key == "c" {
+			t.Errorf("client limiter tracks share %s", key)
+		}
+	}
+	if len(s.passwordShares.buckets) != 2 {
+		t.Errorf("share limiter tracks %d keys, want shares a and c", len(s.passwordShares.buckets))
+	}
+}
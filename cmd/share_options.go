@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR code formats of share responses
+const (
+	QRCodePNG = "png"
+	QRCodeSVG = "svg"
+
+	qrCodePNGSize = 256 // Pixels
+
+	maxShareExpiry = 365 * 24 * time.Hour // Longest expires accepted
+)
+
+// qrCodeFormats lists the accepted qr_code values
+var qrCodeFormats = []string{QRCodePNG, QRCodeSVG}
+
+// ShareOptions are per-request settings of the share link, each provider accepts the ones it supports
+type ShareOptions struct {
+	Expires      string `json:"expires,omitempty" doc:"Lifetime of the link as a duration such as 12h, 7d or 2w"`
+	MaxDownloads int    `json:"max_downloads,omitempty" doc:"Downloads after which the link stops working" schema:"minimum=0"`
+	Password     string `json:"password,omitempty" doc:"Password required to download the PDF, never echoed back"`
+	AutoDelete   bool   `json:"auto_delete,omitempty" doc:"Delete the PDF after its first download"`
+	QRCode       string `json:"qr_code,omitempty" doc:"Return a QR code of the link as a data URI in this format, supported by all providers" schema:"enum=qrCodeFormats"`
+
+	expiry time.Duration // Parsed Expires
+}
+
+// shareOptionsProvider is implemented by providers accepting share options, others support qr_code only
+type shareOptionsProvider interface {
+	// checkShareOptions returns an error for options the provider does not support or cannot honour
+	checkShareOptions(opts *ShareOptions) error
+}
+
+// shareOptionsKey is the context key of the request's share options
+type shareOptionsKey struct{}
+
+// withShareOptions stores the share options of a request in the context
+func withShareOptions(ctx context.Context, opts *ShareOptions) context.Context {
+	if opts == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, shareOptionsKey{}, opts)
+}
+
+// shareOptionsFromContext returns the share options of the request, nil if it has none
+func shareOptionsFromContext(ctx context.Context) *ShareOptions {
+	opts, _ := ctx.Value(shareOptionsKey{}).(*ShareOptions)
+	return opts
+}
+
+// parseShareOptions decodes share options given as JSON, e.g. a form field
+func parseShareOptions(data string) (*ShareOptions, error) {
+	var body interface{}
+	if err := json.Unmarshal([]byte(data), &body); err != nil {
+		return nil, withProblemCode(CodeInvalidJSON, errors.New("invalid JSON format share_options"))
+	}
+	if err := validateJSONRequest(body, "ShareOptions"); err != nil {
+		return nil, err
+	}
+	var opts ShareOptions
+	if err := json.Unmarshal([]byte(data), &opts); err != nil {
+		return nil, withProblemCode(CodeInvalidRequest, fmt.Errorf("share_options: %w", err))
+	}
+	return &opts, nil
+}
+
+// validateShareOptions checks opts and that every provider of services supports them
+func (s *PDFService) validateShareOptions(services []FileShareService, opts *ShareOptions) error {
+	if opts == nil {
+		return nil
+	}
+	if len(services) == 0 {
+		return errors.New("share_options requires share_service")
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	for _, service := range services {
+		provider, err := s.shareProvider(service)
+		if err != nil {
+			return err
+		}
+		if p, ok := provider.(shareOptionsProvider); ok {
+			err = p.checkShareOptions(opts)
+		} else {
+			err = unsupportedShareOptions(opts)
+		}
+		if err != nil {
+			return fmt.Errorf("share_service %s: %v", service, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the values of o and parses its expiry
+func (o *ShareOptions) validate() error {
+	if o.Expires != "" {
+		expiry, err := parseShareExpiry(o.Expires)
+		if err != nil {
+			return fmt.Errorf("share_options.expires: %v", err)
+		}
+		o.expiry = expiry
+	}
+	if o.MaxDownloads < 0 {
+		return errors.New("share_options.max_downloads must not be negative")
+	}
+	if o.AutoDelete && o.MaxDownloads > 1 {
+		return errors.New("share_options.auto_delete cannot be combined with max_downloads above 1")
+	}
+	if o.QRCode != "" && o.QRCode != QRCodePNG && o.QRCode != QRCodeSVG {
+		return fmt.Errorf("share_options.qr_code must be %s or %s", QRCodePNG, QRCodeSVG)
+	}
+	return nil
+}
+
+// requested lists the provider options set in o
+func (o *ShareOptions) requested() []string {
+	var names []string
+	if o.Expires != "" {
+		names = append(names, "expires")
+	}
+	if o.MaxDownloads > 0 {
+		names = append(names, "max_downloads")
+	}
+	if o.Password != "" {
+		names = append(names, "password")
+	}
+	if o.AutoDelete {
+		names = append(names, "auto_delete")
+	}
+	return names
+}
+
+// echo returns the options to report in the share response, without the password
+func (o *ShareOptions) echo() *ShareOptions {
+	echoed := *o
+	echoed.Password = ""
+	return &echoed
+}
+
+// unsupportedShareOptions returns an error naming the options set in opts that are not in supported
+func unsupportedShareOptions(opts *ShareOptions, supported ...string) error {
+	var unsupported []string
+	for _, name := range opts.requested() {
+		if !slices.Contains(supported, name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("unsupported share_options: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// parseShareExpiry parses a positive Go duration, or whole days or weeks such as 7d or 2w, of at most a year
+func parseShareExpiry(value string) (time.Duration, error) {
+	var expiry time.Duration
+	var err error
+	if unit := value[len(value)-1:]; unit == "d" || unit == "w" {
+		day := 24 * time.Hour
+		if unit == "w" {
+			day *= 7
+		}
+		var n int
+		if n, err = strconv.Atoi(value[:len(value)-1]); err == nil {
+			// Checked before multiplying, large counts would overflow
+			if n > int(maxShareExpiry/day) {
+				return 0, fmt.Errorf("must be at most 365d")
+			}
+			expiry = time.Duration(n) * day
+		}
+	} else {
+		expiry, err = time.ParseDuration(value)
+	}
+	if err != nil || expiry <= 0 {
+		return 0, fmt.Errorf("must be a positive duration such as 12h, 7d or 2w")
+	}
+	if expiry > maxShareExpiry {
+		return 0, fmt.Errorf("must be at most 365d")
+	}
+	return expiry, nil
+}
+
+// qrCodeDataURI encodes link as a QR code data URI in format
+func qrCodeDataURI(link, format string) (string, error) {
+	code, err := qrcode.New(link, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	if format == QRCodeSVG {
+		return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(qrCodeSVG(code.Bitmap()))), nil
+	}
+	png, err := code.PNG(qrCodePNGSize)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// qrCodeSVG draws a QR code bitmap, including its quiet zone, as an SVG with one unit per module
+func qrCodeSVG(bitmap [][]bool) string {
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	size := len(bitmap)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`, size, size, path.String())
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseShareExpiry(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr string
+	}{
+		{"12h", 12 * time.Hour, ""},
+		{"7d", 7 * 24 * time.Hour, ""},
+		{"2w", 14 * 24 * time.Hour, ""},
+		{"365d", maxShareExpiry, ""},
+		{"366d", 0, "at most 365d"},
+		{"53w", 0, "at most 365d"},
+		{"8784h", 0, "at most 365d"},
+		{"1000000000000000w", 0, "at most 365d"}, // Would overflow when multiplied
+		{"9223372036854775807d", 0, "at most 365d"},
+		{"0d", 0, "positive duration"},
+		{"-1w", 0, "positive duration"},
+		{"1x", 0, "positive duration"},
+	}
+	for _, tt := range tests {
+		got, err := parseShareExpiry(tt.value)
+		switch {
+		case tt.wantErr == "" && (err != nil || got != tt.want):
+			t.Errorf("parseShareExpiry(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("parseShareExpiry(%q) = %v, %v, want error %q", tt.value, got, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLocalShareOptionsAreLimitedByProvider(t *testing.T) {
+	provider, err := newLocalShareProvider("local", ShareServiceConfig{
+		Expires: "48h",
+		Local:   LocalShareConfig{Directory: t.TempDir(), MaxDownloads: 5, Password: "operator"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := provider.(*localShareProvider)
+
+	tests := []struct {
+		name    string
+		opts    ShareOptions
+		wantErr string
+	}{
+		{"within limits", ShareOptions{Expires: "1d", MaxDownloads: 3}, ""},
+		{"auto delete", ShareOptions{AutoDelete: true}, ""},
+		{"longer expiry", ShareOptions{Expires: "3d"}, "expires must be at most 48h0m0s"},
+		{"more downloads", ShareOptions{MaxDownloads: 6}, "max_downloads must be at most 5"},
+		{"replaced password", ShareOptions{Password: "mine"}, "password is set by the provider"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.validate(); err != nil {
+				t.Fatal(err)
+			}
+			err := local.checkShareOptions(&tt.opts)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
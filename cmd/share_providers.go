@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ShareProvider uploads rendered PDFs to a file sharing target
+type ShareProvider interface {
+	// Upload stores the PDF read from file and returns its link
+	Upload(ctx context.Context, file io.Reader, filename string) (*ShareResponse, error)
+}
+
+// rereadingShareProvider is implemented by providers reading the PDF more than once, they are
+// given the rendered file instead of a stream of the render
+type rereadingShareProvider interface {
+	rereadsUploads() bool
+}
+
+// replayableBody returns a function reading the PDF from its start and the PDF's size. Rendered
+// files are re-read from disk, streams are buffered in memory
+func replayableBody(file io.Reader) (func() io.Reader, int64, error) {
+	if section, ok := file.(*io.SectionReader); ok {
+		return func() io.Reader { return io.NewSectionReader(section, 0, section.Size()) }, section.Size(), nil
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	return func() io.Reader { return bytes.NewReader(data) }, int64(len(data)), nil
+}
+
+// ShareProviderFactory creates a provider named name from its configuration, validating it
+type ShareProviderFactory func(name string, config ShareServiceConfig) (ShareProvider, error)
+
+// shareProviderTypes holds the registered provider types by name
+var shareProviderTypes = map[string]ShareProviderFactory{}
+
+// RegisterShareProvider registers a provider type, share config entries select it with type
+// or, without type, by their name
+func RegisterShareProvider(kind string, factory ShareProviderFactory) {
+	if _, exists := shareProviderTypes[kind]; exists {
+		panic("share provider type registered twice: " + kind)
+	}
+	shareProviderTypes[kind] = factory
+}
+
+// defaultSharePathTemplate is the default object key or remote path template of storage providers
+const defaultSharePathTemplate = "{{.Date}}/{{.RenderID}}/{{.Filename}}"
+
+// sharePathData holds the fields available to object key, remote path and email templates
+type sharePathData struct {
+	Filename string
+	Tenant   string
+	RenderID string
+	Date     string // 2006/01/02
+	Time     time.Time
+}
+
+// newSharePathData returns the template fields of an upload of filename in the request of ctx
+func newSharePathData(ctx context.Context, filename string) sharePathData {
+	now := time.Now().UTC()
+	return sharePathData{
+		Filename: filename,
+		Tenant:   tenantFromContext(ctx),
+		RenderID: renderIDFromContext(ctx),
+		Date:     now.Format("2006/01/02"),
+		Time:     now,
+	}
+}
+
+// parseSharePathTemplate parses an object key or remote path template
+func parseSharePathTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// renderSharePath renders tmpl for an upload of filename, rejecting empty paths and .. segments
+func renderSharePath(ctx context.Context, tmpl *template.Template, filename string) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, newSharePathData(ctx, filename)); err != nil {
+		return "", fmt.Errorf("failed to render path: %v", err)
+	}
+	rendered := b.String()
+	if strings.Trim(rendered, "/") == "" {
+		return "", fmt.Errorf("path template rendered an empty path")
+	}
+	if slices.Contains(strings.Split(rendered, "/"), "..") {
+		return "", fmt.Errorf("path template rendered a path with .. segments")
+	}
+	return rendered, nil
+}
+
+// ShareProviderInfo describes a configured share provider
+type ShareProviderInfo struct {
+	Name    string `json:"name" doc:"Value of share_service selecting the provider" schema:"required"`
+	Type    string `json:"type" doc:"Provider type" schema:"required"`
+	Expires string `json:"expires,omitempty" doc:"Lifetime of uploaded files"`
+}
+
+// ShareProvidersResponse lists the configured share providers
+type ShareProvidersResponse struct {
+	Providers []ShareProviderInfo `json:"providers" schema:"required"`
+}
+
+// shareRegistry holds the providers built from the share configuration
+type shareRegistry struct {
+	providers   map[string]ShareProvider
+	infos       []ShareProviderInfo // Sorted by name
+	fileUploads map[string]bool     // Providers given the rendered file instead of a stream
+	publicURL   string              // public_url of the first local provider setting one, base of decrypt links
+}
+
+// newShareRegistry creates the providers of all enabled share config entries
+func newShareRegistry(configs map[string]ShareServiceConfig) (*shareRegistry, error) {
+	registry := &shareRegistry{providers: make(map[string]ShareProvider), fileUploads: make(map[string]bool)}
+	var errs []error
+	for name, config := range configs {
+		if config.Disabled {
+			continue
+		}
+		kind := config.ProviderType(name)
+		factory, ok := shareProviderTypes[kind]
+		if !ok {
+			errs = append(errs, fmt.Errorf("share.%s: unknown provider type %q", name, kind))
+			continue
+		}
+		provider, err := factory(name, config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("share.%s: %v", name, err))
+			continue
+		}
+		registry.providers[name] = provider
+		rereading, ok := provider.(rereadingShareProvider)
+		registry.fileUploads[name] = config.ContentLength || (ok && rereading.rereadsUploads())
+		registry.infos = append(registry.infos, ShareProviderInfo{Name: name, Type: kind, Expires: config.Expires})
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return nil, errors.Join(errs...)
+	}
+	sort.Slice(registry.infos, func(i, j int) bool { return registry.infos[i].Name < registry.infos[j].Name })
+	for _, info := range registry.infos {
+		if publicURL := configs[info.Name].Local.PublicURL; info.Type == "local" && publicURL != "" {
+			registry.publicURL = strings.TrimSuffix(publicURL, "/")
+			break
+		}
+	}
+	return registry, nil
+}
+
+// shareProvider returns the configured provider named name
+func (s *PDFService) shareProvider(name FileShareService) (ShareProvider, error) {
+	provider, ok := s.shares.Load().providers[string(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sharing service: %s", name)
+	}
+	return provider, nil
+}
+
+// HandleShareProviders lists the configured share providers
+func (s *PDFService) HandleShareProviders(w http.ResponseWriter, r *http.Request) {
+	providers := s.shares.Load().infos
+	if providers == nil {
+		providers = []ShareProviderInfo{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&ShareProvidersResponse{Providers: providers})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandleShareProviders(t *testing.T) {
+	tests := []struct {
+		name  string
+		share map[string]ShareServiceConfig
+		want  []ShareProviderInfo
+	}{
+		{
+			"sorted by name without disabled providers",
+			map[string]ShareServiceConfig{
+				string(FileIO): {Endpoint: "https://file.io", Expires: "1d"},
+				string(KITC):   {Endpoint: "https://ki.tc/file/u/", Disabled: true},
+				"archive":      {Type: "local", Local: LocalShareConfig{Directory: t.TempDir()}},
+				string(CVSH):   {Endpoint: "https://c-v.sh"},
+			},
+			[]ShareProviderInfo{
+				{Name: "archive", Type: "local"},
+				{Name: string(CVSH), Type: string(CVSH)},
+				{Name: string(FileIO), Type: string(FileIO), Expires: "1d"},
+			},
+		},
+		{"no providers", nil, []ShareProviderInfo{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.Share = tt.share
+			s := newTestService(t, config)
+
+			w := httptest.NewRecorder()
+			s.HandleShareProviders(w, httptest.NewRequest(http.MethodGet, "/api/v1/share/providers", nil))
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("status %d content type %q", w.Code, w.Header().Get("Content-Type"))
+			}
+			body := w.Body.String()
+			var response ShareProvidersResponse
+			if err := json.Unmarshal([]byte(body), &response); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(response.Providers, tt.want) {
+				t.Errorf("providers %+v, want %+v", response.Providers, tt.want)
+			}
+			// Clients get an empty list rather than null, and no expires without one configured
+			if len(tt.want) == 0 && !strings.Contains(body, `"providers":[]`) {
+				t.Errorf("body %s, want an empty providers list", body)
+			}
+			if strings.Contains(body, `"expires":""`) {
+				t.Errorf("body %s lists empty expires", body)
+			}
+		})
+	}
+}
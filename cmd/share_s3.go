@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// S3 defaults
+const (
+	defaultS3Region     = "us-east-1"
+	defaultS3PartSizeMB = 16
+	minS3PartSizeMB     = 5      // S3 rejects smaller parts except the last
+	maxS3Parts          = 10000  // Parts per multipart upload
+	maxS3PresignSeconds = 604800 // Seven days
+)
+
+// Server-side encryption modes
+const (
+	S3EncryptionAES256 = "AES256"
+	S3EncryptionKMS    = "aws:kms"
+)
+
+func init() {
+	RegisterShareProvider("s3", newS3Provider)
+}
+
+// s3Provider uploads PDFs to an S3-compatible bucket
+type s3Provider struct {
+	name        string
+	config      S3Config
+	endpoint    *url.URL
+	keyTemplate *template.Template
+	partSize    int
+	signer      *sigV4Signer
+}
+
+// newS3Provider creates an S3 provider, credentials fall back to the AWS environment variables
+func newS3Provider(name string, config ShareServiceConfig) (ShareProvider, error) {
+	c := config.S3
+	if c.Bucket == "" {
+		return nil, fmt.Errorf("s3.bucket is required")
+	}
+	if c.Region == "" {
+		c.Region = defaultS3Region
+	}
+	if c.AccessKeyID == "" {
+		c.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if c.SecretAccessKey == "" {
+		c.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if c.SessionToken == "" {
+		c.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3 credentials are required, set s3.access_key_id and s3.secret_access_key or the AWS environment variables")
+	}
+	if c.KeyTemplate == "" {
+		c.KeyTemplate = defaultSharePathTemplate
+	}
+	if c.PartSizeMB == 0 {
+		c.PartSizeMB = defaultS3PartSizeMB
+	}
+	if c.ContentDisposition == "" {
+		c.ContentDisposition = "attachment"
+	}
+
+	var errs []error
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("endpoint must be an http(s) URL"))
+	}
+	keyTemplate, err := parseSharePathTemplate(name, c.KeyTemplate)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("s3.key_template: %v", err))
+	}
+	switch c.ServerSideEncryption {
+	case "", S3EncryptionAES256:
+		if c.KMSKeyID != "" {
+			errs = append(errs, fmt.Errorf("s3.kms_key_id requires server_side_encryption %s", S3EncryptionKMS))
+		}
+	case S3EncryptionKMS:
+	default:
+		errs = append(errs, fmt.Errorf("s3.server_side_encryption must be %s or %s", S3EncryptionAES256, S3EncryptionKMS))
+	}
+	if c.ContentDisposition != "attachment" && c.ContentDisposition != "inline" {
+		errs = append(errs, fmt.Errorf("s3.content_disposition must be attachment or inline"))
+	}
+	if c.PresignExpirySeconds < 0 || c.PresignExpirySeconds > maxS3PresignSeconds {
+		errs = append(errs, fmt.Errorf("s3.presign_expiry_seconds must be between 0 and %d", maxS3PresignSeconds))
+	}
+	if c.PartSizeMB < minS3PartSizeMB {
+		errs = append(errs, fmt.Errorf("s3.part_size_mb must be at least %d", minS3PartSizeMB))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return &s3Provider{
+		name:        name,
+		config:      c,
+		endpoint:    u,
+		keyTemplate: keyTemplate,
+		partSize:    c.PartSizeMB << 20,
+		signer: &sigV4Signer{
+			credentials: awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken},
+			region:      c.Region,
+			service:     "s3",
+		},
+	}, nil
+}
+
+// Upload stores the PDF under the templated key, files larger than a part use multipart upload
+func (p *s3Provider) Upload(ctx context.Context, file io.Reader, filename string) (*ShareResponse, error) {
+	key, err := renderSharePath(ctx, p.keyTemplate, filename)
+	if err != nil {
+		return nil, err
+	}
+	key = strings.TrimLeft(key, "/")
+
+	// Read up to one byte past a part to tell whether the file fits into a single request,
+	// the buffer grows with the file so small PDFs do not allocate a whole part
+	first, err := io.ReadAll(io.LimitReader(file, int64(p.partSize)+1))
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("failed to read PDF: %v", err)
+	case len(first) <= p.partSize:
+		err = p.putObject(ctx, key, filename, first)
+	default:
+		err = p.multipartUpload(ctx, key, filename, io.MultiReader(bytes.NewReader(first), file))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to %s: %v", p.name, err)
+	}
+
+	response := &ShareResponse{Success: true, Key: key, Link: p.objectURL(key, nil).String()}
+	expiry := time.Duration(p.config.PresignExpirySeconds) * time.Second
+	if opts := shareOptionsFromContext(ctx); opts != nil && opts.expiry > 0 {
+		expiry = opts.expiry
+	}
+	if expiry > 0 {
+		now := time.Now()
+		expires := now.Add(expiry).UTC()
+		response.Link = p.signer.presign(http.MethodGet, p.objectURL(key, nil), expiry, now).String()
+		response.Expires = &expires
+	}
+	return response, nil
+}
+
+// checkShareOptions accepts an expiry of the presigned link up to seven days
+func (p *s3Provider) checkShareOptions(opts *ShareOptions) error {
+	if opts.expiry > maxS3PresignSeconds*time.Second {
+		return fmt.Errorf("expires must be at most 7d for presigned links")
+	}
+	return unsupportedShareOptions(opts, "expires")
+}
+
+// objectURL returns the URL of key with query, path style puts the bucket in the path
+func (p *s3Provider) objectURL(key string, query url.Values) *url.URL {
+	u := *p.endpoint
+	objectPath := "/" + key
+	if p.config.ForcePathStyle {
+		objectPath = "/" + p.config.Bucket + objectPath
+	} else {
+		u.Host = p.config.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawPath = sigV4Escape(u.Path, false)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// objectHeaders returns the metadata and encryption headers set when creating an object
+func (p *s3Provider) objectHeaders(filename string) http.Header {
+	header := http.Header{}
+	header.Set("Content-Type", shareContentType(filename))
+	header.Set("Content-Disposition", mime.FormatMediaType(p.config.ContentDisposition, map[string]string{"filename": filename}))
+	if p.config.ServerSideEncryption != "" {
+		header.Set("X-Amz-Server-Side-Encryption", p.config.ServerSideEncryption)
+	}
+	if p.config.KMSKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", p.config.KMSKeyID)
+	}
+	return header
+}
+
+// putObject uploads body in a single request
+func (p *s3Provider) putObject(ctx context.Context, key, filename string, body []byte) error {
+	resp, err := p.do(ctx, http.MethodPut, key, nil, p.objectHeaders(filename), body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// multipartUpload uploads body in parts, a failed upload is aborted so the parts do not linger
+func (p *s3Provider) multipartUpload(ctx context.Context, key, filename string, body io.Reader) (err error) {
+	resp, err := p.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, p.objectHeaders(filename), nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("failed to initiate multipart upload: invalid response")
+	}
+	uploadID := initiated.UploadID
+
+	defer func() {
+		if err != nil {
+			// Abort with a fresh context, the upload context may be what failed
+			abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cancel()
+			if resp, abortErr := p.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil); abortErr == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var parts []completedPart
+	buf := make([]byte, p.partSize)
+	for number := 1; ; number++ {
+		n, readErr := io.ReadFull(body, buf)
+		if n == 0 && readErr == io.EOF {
+			break
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read PDF: %v", readErr)
+		}
+		if number > maxS3Parts {
+			return fmt.Errorf("file exceeds %d parts of %d MB", maxS3Parts, p.config.PartSizeMB)
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := p.do(ctx, http.MethodPut, key, query, nil, buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %v", number, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		if readErr == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("failed to encode parts: %v", err)
+	}
+	resp, err = p.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, http.Header{"Content-Type": {"application/xml"}}, complete)
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Completion reports errors in a 200 response once it started processing the parts
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %v", err)
+	}
+	if s3Err := parseS3Error(data); s3Err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %v", s3Err)
+	}
+	return nil
+}
+
+// do sends a signed request for key, non-2xx responses are returned as errors
+func (p *s3Provider) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := p.objectURL(key, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadSHA256
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	p.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := shareHTTPClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if s3Err := parseS3Error(data); s3Err != nil {
+			return nil, fmt.Errorf("%s: %v", resp.Status, s3Err)
+		}
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// parseS3Error returns the error of an S3 XML error document, nil for other bodies
+func parseS3Error(data []byte) error {
+	var s3Err struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &s3Err) != nil || s3Err.Code == "" {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", s3Err.Code, s3Err.Message)
+}
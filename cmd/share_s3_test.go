@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an in-memory S3 endpoint with path style addressing and multipart uploads
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	aborted int
+	failPut bool // Part uploads fail with 500
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	return &fakeS3{t: t, objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" Credential=AKID/") {
+		f.t.Errorf("%s %s: unsigned request", r.Method, r.URL)
+	}
+	if hash := r.Header.Get("X-Amz-Content-Sha256"); hash != sha256Hex(body) {
+		f.t.Errorf("%s %s: payload hash %s does not match the body", r.Method, r.URL, hash)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.URL.Path
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(f.uploads)+1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		if f.failPut {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "<Error><Code>InternalError</Code><Message>part failed</Message></Error>")
+			return
+		}
+		var number int
+		fmt.Sscan(query.Get("partNumber"), &number)
+		f.uploads[query.Get("uploadId")][number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			f.t.Errorf("invalid complete request: %v", err)
+		}
+		parts := f.uploads[query.Get("uploadId")]
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				f.t.Errorf("part %d listed as %d with ETag %s", i+1, part.PartNumber, part.ETag)
+			}
+			object = append(object, parts[part.PartNumber]...)
+		}
+		f.objects[key] = object
+		io.WriteString(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		if r.Header.Get("Content-Type") != "application/pdf" {
+			f.t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		f.objects[key] = body
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestS3Provider(t *testing.T, endpoint string, expiry int) *s3Provider {
+	t.Helper()
+	provider, err := newS3Provider("s3", ShareServiceConfig{
+		Endpoint: endpoint,
+		S3: S3Config{
+			Bucket:               "bucket",
+			AccessKeyID:          "AKID",
+			SecretAccessKey:      "secret",
+			ForcePathStyle:       true,
+			KeyTemplate:          "pdf/{{.Filename}}",
+			PartSizeMB:           minS3PartSizeMB,
+			PresignExpirySeconds: expiry,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*s3Provider)
+}
+
+func TestS3UploadRoundTrip(t *testing.T) {
+	partSize := minS3PartSizeMB << 20
+	tests := []struct {
+		name  string
+		size  int
+		parts int
+	}{
+		{"single request", 1024, 0},
+		{"exactly one part", partSize, 0},
+		{"multipart", 2*partSize + 123, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := newFakeS3(t)
+			server := httptest.NewServer(s3)
+			defer server.Close()
+			provider := newTestS3Provider(t, server.URL, 0)
+
+			data := make([]byte, tt.size)
+			rand.Read(data)
+			response, err := provider.Upload(context.Background(), bytes.NewReader(data), "report.pdf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Key != "pdf/report.pdf" || response.Link != server.URL+"/bucket/pdf/report.pdf" {
+				t.Errorf("key %q link %q", response.Key, response.Link)
+			}
+			if !bytes.Equal(s3.objects["/bucket/pdf/report.pdf"], data) {
+				t.Errorf("stored object differs from the upload")
+			}
+			var parts []int
+			for _, upload := range s3.uploads {
+				for number := range upload {
+					parts = append(parts, number)
+				}
+			}
+			sort.Ints(parts)
+			if len(parts) != tt.parts {
+				t.Errorf("uploaded %d parts, want %d", len(parts), tt.parts)
+			}
+		})
+	}
+}
+
+func TestS3UploadAbortsFailedMultipartUpload(t *testing.T) {
+	s3 := newFakeS3(t)
+	s3.failPut = true
+	server := httptest.NewServer(s3)
+	defer server.Close()
+	provider := newTestS3Provider(t, server.URL, 0)
+
+	data := make([]byte, minS3PartSizeMB<<20+1)
+	_, err := provider.Upload(context.Background(), bytes.NewReader(data), "report.pdf")
+	if err == nil || !strings.Contains(err.Error(), "InternalError: part failed") {
+		t.Fatalf("err = %v, want the S3 error", err)
+	}
+	if s3.aborted != 1 || len(s3.objects) != 0 {
+		t.Errorf("aborted %d uploads, stored %d objects", s3.aborted, len(s3.objects))
+	}
+}
+
+func TestS3UploadPresignsLink(t *testing.T) {
+	s3 := newFakeS3(t)
+	server := httptest.NewServer(s3)
+	defer server.Close()
+	provider := newTestS3Provider(t, server.URL, 3600)
+
+	response, err := provider.Upload(context.Background(), strings.NewReader("%PDF"), "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Expires == nil || !strings.Contains(response.Link, "X-Amz-Expires=3600") || !strings.Contains(response.Link, "X-Amz-Signature=") {
+		t.Errorf("link %q expires %v is not presigned", response.Link, response.Expires)
+	}
+}
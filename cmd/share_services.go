@@ -1,222 +1,524 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// uploadToShareService uploads PDF to third-party sharing service
-func (s *PDFService) uploadToShareService(filePath, filename string, service FileShareService) (*ShareResponse, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open PDF file: %v", err)
-	}
-	defer file.Close()
+// Built-in share provider types
+func init() {
+	RegisterShareProvider(string(FileIO), newFormUploadFactory("https://file.io", parseFileIOResponse, "expires", "max_downloads", "auto_delete"))
+	RegisterShareProvider(string(KITC), newFormUploadFactory("https://ki.tc/file/u/", parseKITCResponse))
+	RegisterShareProvider(string(CVSH), newFormUploadFactory("https://c-v.sh", parseCVSHResponse))
+}
 
-	switch service {
-	case FileIO:
-		return s.uploadToFileIO(file, filename)
-	case KITC:
-		return s.uploadToKITC(file, filename)
-	case CVSH:
-		return s.uploadToCVSH(file, filename)
-	default:
-		return nil, fmt.Errorf("unsupported sharing service: %s", service)
+// renderToShare generates the PDF with generate, uploads it to the first of services accepting it and
+// writes the share response. It returns the render outcome
+func (s *PDFService) renderToShare(ctx context.Context, w http.ResponseWriter, services []FileShareService, filename string, generate func(ctx context.Context, w io.Writer) error) string {
+	// Providers that are down fail fast, before rendering
+	config := s.cfg().Outbound
+	if !slices.ContainsFunc(services, func(service FileShareService) bool { return s.breakers.get(config, service).available(config) }) {
+		retryAfter := seconds(config.BreakerCooldownSeconds)
+		for _, service := range services {
+			s.metrics.observeShareRejected(service)
+			retryAfter = min(retryAfter, s.breakers.get(config, service).cooldownLeft(config))
+		}
+		s.logger.WarnContext(ctx, "Sharing services unavailable", "services", services)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+1)))
+		writeProblem(ctx, w, http.StatusServiceUnavailable, CodeShareUnavailable, "Sharing service unavailable: "+errCircuitOpen.Error())
+		return OutcomeShareError
 	}
-}
 
-// uploadToFileIO uploads to file.io service
-func (s *PDFService) uploadToFileIO(file *os.File, filename string) (*ShareResponse, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	// Providers only receive the ciphertext, the key stays with the response
+	if key := shareEncryptionFromContext(ctx); key != nil {
+		generate = encryptedGenerate(key, generate)
+		filename += encryptedShareExtension
+	}
 
-	part, err := writer.CreateFormFile("file", filename)
+	source, err := s.newShareSource(ctx, services, generate)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %v", err)
+		s.logger.ErrorContext(ctx, "Failed to create temporary PDF file", "error", err)
+		writeProblem(ctx, w, http.StatusInternalServerError, CodeInternalError, "Internal server error")
+		return OutcomeServerError
 	}
+	defer source.close()
 
-	_, err = io.Copy(part, file)
+	response, err := s.uploadToShareServices(ctx, services, filename, source)
+	if renderErr := source.finish(err != nil); renderErr != nil {
+		s.logger.ErrorContext(ctx, "PDF generation failed", "error", renderErr)
+		writeRenderProblem(ctx, w, renderErr)
+		return OutcomeRenderError
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to copy file content: %v", err)
+		s.logger.ErrorContext(ctx, "Failed to upload to sharing service", "services", services, "error", err)
+		writeProblem(ctx, w, http.StatusBadGateway, CodeShareFailed, "Failed to upload to sharing service: "+err.Error())
+		return OutcomeShareError
 	}
 
-	err = writer.Close()
-	if err != nil {
-		return nil, fmt.Errorf("failed to close multipart writer: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return OutcomeSuccess
+}
+
+// shareServices returns service followed by fallbacks after checking that all are configured providers,
+// nil when service is empty
+func (s *PDFService) shareServices(service FileShareService, fallbacks []string) ([]FileShareService, error) {
+	if service == NoShare {
+		if len(fallbacks) > 0 {
+			return nil, fmt.Errorf("share_fallbacks requires share_service")
+		}
+		return nil, nil
+	}
+	services := []FileShareService{service}
+	for _, fallback := range fallbacks {
+		if slices.Contains(services, FileShareService(fallback)) {
+			return nil, fmt.Errorf("share_fallbacks repeats %s", fallback)
+		}
+		services = append(services, FileShareService(fallback))
 	}
+	for _, service := range services {
+		if _, err := s.shareProvider(service); err != nil {
+			return nil, err
+		}
+	}
+	return services, nil
+}
 
-	req, err := http.NewRequest("POST", "https://file.io", body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+// uploadToShareServices uploads the PDF to services in order until one succeeds. Network errors, 429 and 5xx
+// are retried with backoff, other failures and open circuits move on to the next service
+func (s *PDFService) uploadToShareServices(ctx context.Context, services []FileShareService, filename string, source *shareSource) (*ShareResponse, error) {
+	config := s.cfg().Outbound
+	var failures []string
+	var lastErr error
+	for _, service := range services {
+		breaker := s.breakers.get(config, service)
+		for retry := 0; retry <= config.MaxRetries; retry++ {
+			if retry > 0 {
+				if !breaker.available(config) {
+					break // Opened by the failures so far
+				}
+				s.metrics.observeShareRetry(service)
+				if err := sleepContext(ctx, retryBackoff(config, retry)); err != nil {
+					return nil, err
+				}
+			}
+			if !breaker.allow(config) {
+				s.metrics.observeShareRejected(service)
+				if retry == 0 {
+					lastErr = errCircuitOpen
+				}
+				break
+			}
+
+			file, err := source.next()
+			if err != nil {
+				breaker.release()
+				return nil, err
+			}
+			attemptCtx, attempt := withUploadAttempt(ctx)
+			response, err := s.uploadToShareService(attemptCtx, file, filename, service)
+			if err == nil {
+				breaker.record(config, false)
+				return response, nil
+			}
+			// Failed renders and cancelled requests say nothing about the provider
+			if source.renderFailed() || ctx.Err() != nil {
+				breaker.release()
+				return nil, err
+			}
+
+			source.closeStream() // Lets the render continue into the file during the backoff
+
+			transient := attempt.transient.Load()
+			breaker.record(config, transient)
+			s.logger.WarnContext(ctx, "Share upload failed", "service", service, "attempt", retry+1, "retryable", transient, "error", err)
+			lastErr = err
+			if !transient {
+				break
+			}
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", service, lastErr))
 	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if len(services) == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("all sharing services failed: %s", strings.Join(failures, "; "))
+}
+
+// shareSource provides the PDF to share upload attempts. The first attempt streams the render unless a
+// provider needs the rendered file, later attempts read the copy written to a temporary file
+type shareSource struct {
+	file      *os.File       // Rendered PDF, nil when only streamed
+	remove    func()         // Removes file
+	stream    *io.PipeReader // Render output, nil when rendered to file first
+	streamed  bool
+	rendered  chan error
+	renderErr error
+	done      bool
+	cancel    context.CancelFunc
+}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// newShareSource starts rendering the PDF with generate for uploads to services. The render is kept in
+// a temporary file when a provider needs the file, or when retries or fallbacks may upload it again
+func (s *PDFService) newShareSource(ctx context.Context, services []FileShareService, generate func(ctx context.Context, w io.Writer) error) (*shareSource, error) {
+	shares := s.shares.Load()
+	needsFile := slices.ContainsFunc(services, func(service FileShareService) bool { return shares.fileUploads[string(service)] })
+	source := &shareSource{rendered: make(chan error, 1), cancel: func() {}}
+	if needsFile || s.cfg().Outbound.MaxRetries > 0 || len(services) > 1 {
+		file, err := s.createTempFile("pdfshare-*.pdf")
+		if err != nil {
+			return nil, err
+		}
+		source.file = file
+		source.remove = func() { s.removeTemp(file.Name()) }
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+	if needsFile {
+		source.rendered <- generate(ctx, source.file)
+		return source, nil
 	}
-	defer resp.Body.Close()
 
-	var fileIOResp struct {
-		Success bool   `json:"success"`
-		Key     string `json:"key"`
-		Link    string `json:"link"`
-		Expiry  string `json:"expiry"`
+	// A failed render fails the streamed upload with its error. A failed upload closing the stream
+	// only detaches it, so its closed pipe is not mistaken for a render failure
+	renderCtx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	source.stream, source.cancel = pr, cancel
+	var out io.Writer = &detachableWriter{w: pw}
+	if source.file != nil {
+		out = io.MultiWriter(source.file, out)
 	}
+	go func() {
+		err := generate(renderCtx, out)
+		source.rendered <- err // Before closing the pipe, so failed uploads can tell whether the render failed first
+		pw.CloseWithError(err)
+	}()
+	return source, nil
+}
+
+// newFileShareSource returns a source uploading the rendered PDF in file, which the caller closes.
+// Its attempts read file with ReadAt, so several sources can share it
+func newFileShareSource(file *os.File) *shareSource {
+	source := &shareSource{file: file, rendered: make(chan error, 1), cancel: func() {}}
+	source.rendered <- nil
+	return source
+}
 
-	fmt.Println(resp.Status)
-	bodyBytes, err := io.ReadAll(resp.Body)
+// next returns the PDF for the next upload attempt, the render stream first and then the rendered file
+func (src *shareSource) next() (io.Reader, error) {
+	if src.stream != nil {
+		if !src.streamed {
+			src.streamed = true
+			return src.stream, nil
+		}
+		src.closeStream()
+	}
+	if err := src.wait(); err != nil {
+		return nil, err
+	}
+	if src.file == nil {
+		return nil, errors.New("the streamed PDF cannot be uploaded again")
+	}
+	info, err := src.file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return nil, err
 	}
+	return io.NewSectionReader(src.file, 0, info.Size()), nil
+}
 
-	fmt.Println(string(bodyBytes))
+// closeStream ends the streamed upload, the render goes on into the temporary file if there is one
+func (src *shareSource) closeStream() {
+	if src.stream != nil {
+		src.stream.Close()
+	}
+}
 
-	err = json.Unmarshal(bodyBytes, &fileIOResp)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse response: %v", err)
+// wait waits for the render and returns its error
+func (src *shareSource) wait() error {
+	if !src.done {
+		src.renderErr = <-src.rendered
+		src.done = true
 	}
+	return src.renderErr
+}
 
-	if !fileIOResp.Success {
-		return nil, fmt.Errorf("failed to upload to file.io")
+// renderFailed reports whether the render has already failed
+func (src *shareSource) renderFailed() bool {
+	if !src.done {
+		select {
+		case src.renderErr = <-src.rendered:
+			src.done = true
+		default:
+		}
+	}
+	return src.renderErr != nil
+}
+
+// finish ends the render and returns its error. After failed uploads a render still running is cancelled
+func (src *shareSource) finish(uploadFailed bool) error {
+	src.closeStream()
+	if uploadFailed && !src.renderFailed() && !src.done {
+		src.cancel()
+		<-src.rendered
+		src.done = true
+		return nil
 	}
+	return src.wait()
+}
 
-	return &ShareResponse{
-		Success:  true,
-		Link:     fileIOResp.Link,
-		Service:  string(FileIO),
-		Filename: filename,
-	}, nil
+// close releases the temporary file
+func (src *shareSource) close() {
+	src.cancel()
+	if src.file != nil {
+		src.file.Close()
+		src.remove()
+	}
 }
 
-// uploadToKITC uploads to ki.tc service
-func (s *PDFService) uploadToKITC(file *os.File, filename string) (*ShareResponse, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+// detachableWriter passes writes to w until one fails, then discards them
+type detachableWriter struct {
+	w      io.Writer
+	failed bool
+}
 
-	part, err := writer.CreateFormFile("file", filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %v", err)
+func (d *detachableWriter) Write(p []byte) (int, error) {
+	if !d.failed {
+		if _, err := d.w.Write(p); err != nil {
+			d.failed = true
+		}
 	}
+	return len(p), nil
+}
 
-	_, err = io.Copy(part, file)
+// linkBaseURL returns the base URL of service links of a share uploaded to provider: the public_url of
+// a local provider, falling back to the URL the request reached
+func (s *PDFService) linkBaseURL(ctx context.Context, provider ShareProvider) string {
+	if local, ok := provider.(*localShareProvider); ok {
+		return local.baseURL(ctx)
+	}
+	if publicURL := s.shares.Load().publicURL; publicURL != "" {
+		return publicURL
+	}
+	return requestBaseURLFromContext(ctx)
+}
+
+// uploadToShareService uploads the PDF read from file to a share provider
+func (s *PDFService) uploadToShareService(ctx context.Context, file io.Reader, filename string, service FileShareService) (response *ShareResponse, err error) {
+	ctx, span := tracer.Start(ctx, "uploadToShareService",
+		trace.WithAttributes(attribute.String("share.service", string(service))))
+	defer func() { endSpan(span, err) }()
+
+	provider, err := s.shareProvider(service)
 	if err != nil {
-		return nil, fmt.Errorf("failed to copy file content: %v", err)
+		return nil, err
 	}
 
-	err = writer.Close()
+	start := time.Now()
+	response, err = provider.Upload(ctx, file, filename)
+	s.metrics.observePhase(PhaseShare, time.Since(start).Seconds())
+	s.metrics.observeShare(service, err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to close multipart writer: %v", err)
+		return nil, err
+	}
+
+	response.Service = string(service)
+	response.Filename = filename
+	if key := shareEncryptionFromContext(ctx); key != nil {
+		encryptShareResponse(response, s.linkBaseURL(ctx, provider), key)
+	}
+	if opts := shareOptionsFromContext(ctx); opts != nil {
+		response.Options = opts.echo()
+		if opts.QRCode != "" {
+			// The PDF is shared, a link too long for a QR code only loses the image
+			if response.QRCode, err = qrCodeDataURI(response.Link, opts.QRCode); err != nil {
+				s.logger.WarnContext(ctx, "Failed to encode QR code", "service", service, "error", err)
+				err = nil
+			}
+		}
+	}
+	return response, nil
+}
+
+// formUploadProvider uploads the PDF as the "file" field of a multipart POST request
+type formUploadProvider struct {
+	name      string
+	config    ShareServiceConfig
+	parseLink func(resp *http.Response) (string, error)
+	options   []string // Supported share options, sent as file.io form fields
+}
+
+// newFormUploadFactory creates a factory for form upload providers, parseLink extracts the link from responses
+// and options lists the supported share options
+func newFormUploadFactory(defaultEndpoint string, parseLink func(resp *http.Response) (string, error), options ...string) ShareProviderFactory {
+	return func(name string, config ShareServiceConfig) (ShareProvider, error) {
+		if config.Endpoint == "" {
+			config.Endpoint = defaultEndpoint
+		}
+		if u, err := url.Parse(config.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("endpoint must be an http(s) URL")
+		}
+		return &formUploadProvider{name: name, config: config, parseLink: parseLink, options: options}, nil
 	}
+}
+
+// checkShareOptions accepts the share options the provider type supports
+func (p *formUploadProvider) checkShareOptions(opts *ShareOptions) error {
+	return unsupportedShareOptions(opts, p.options...)
+}
 
-	req, err := http.NewRequest("POST", "https://ki.tc/file/u/", body)
+// Upload streams the PDF as a multipart form with the configured credentials and expiry
+func (p *formUploadProvider) Upload(ctx context.Context, file io.Reader, filename string) (*ShareResponse, error) {
+	fields, expires := p.formFields(shareOptionsFromContext(ctx))
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeUploadForm(writer, file, filename, fields))
+	}()
+	defer pr.Close() // Stops the writer when the request ends early
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.config.Endpoint, pr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
+	// Files of known size are sent with a Content-Length, streams are chunked
+	if section, ok := file.(*io.SectionReader); ok {
+		length, err := formLength(writer.Boundary(), filename, fields, section.Size())
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode form: %v", err)
+		}
+		req.ContentLength = length
+	}
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
 	}
-	resp, err := client.Do(req)
+
+	resp, err := shareHTTPClient().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %v", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("failed to upload to ki.tc: %s", resp.Status)
-	}
-	// Parse JSON response
-	var response struct {
-		File struct {
-			DownloadPage string `json:"download_page"`
-		} `json:"file"`
+	link, err := p.parseLink(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to %s: %v", p.name, err)
 	}
+	return &ShareResponse{Success: true, Link: link, Expires: expires}, nil
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %v", err)
+// formFields returns the form fields sent along the PDF for the configured expiry and the request's share
+// options in file.io's format, and when a requested expiry ends
+func (p *formUploadProvider) formFields(opts *ShareOptions) ([][2]string, *time.Time) {
+	var fields [][2]string
+	var expires *time.Time
+	switch {
+	case opts != nil && opts.expiry > 0:
+		// Expiries are rounded up to whole days
+		days := int((opts.expiry + 24*time.Hour - 1) / (24 * time.Hour))
+		fields = append(fields, [2]string{"expires", strconv.Itoa(days) + "d"})
+		end := time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour)
+		expires = &end
+	case p.config.Expires != "":
+		fields = append(fields, [2]string{"expires", p.config.Expires})
 	}
-
-	if response.File.DownloadPage == "" {
-		return nil, fmt.Errorf("missing download page URL in response")
+	if opts != nil && opts.MaxDownloads > 0 {
+		fields = append(fields, [2]string{"maxDownloads", strconv.Itoa(opts.MaxDownloads)})
 	}
-
-	return &ShareResponse{
-		Success:  true,
-		Link:     response.File.DownloadPage,
-		Service:  string(KITC),
-		Filename: filename,
-	}, nil
+	if opts != nil && opts.AutoDelete {
+		fields = append(fields, [2]string{"autoDelete", "true"})
+	}
+	return fields, expires
 }
 
-// uploadToCVSH uploads to c-v.sh service
-func (s *PDFService) uploadToCVSH(file *os.File, filename string) (*ShareResponse, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
+// writeUploadForm writes the upload form with the PDF read from file followed by fields
+func writeUploadForm(writer *multipart.Writer, file io.Reader, filename string, fields [][2]string) error {
 	part, err := writer.CreateFormFile("file", filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %v", err)
+		return fmt.Errorf("failed to create form file: %v", err)
 	}
-
-	_, err = io.Copy(part, file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to copy file content: %v", err)
+	if _, err := io.Copy(part, file); err != nil {
+		return fmt.Errorf("failed to copy file content: %v", err)
+	}
+	for _, field := range fields {
+		writer.WriteField(field[0], field[1])
 	}
+	return writer.Close()
+}
 
-	err = writer.Close()
-	if err != nil {
-		return nil, fmt.Errorf("failed to close multipart writer: %v", err)
+// formLength returns the length of the upload form with boundary and fields for a PDF of size bytes
+func formLength(boundary, filename string, fields [][2]string, size int64) (int64, error) {
+	counter := &countingWriter{w: io.Discard}
+	writer := multipart.NewWriter(counter)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+	if err := writeUploadForm(writer, strings.NewReader(""), filename, fields); err != nil {
+		return 0, err
 	}
+	return counter.n + size, nil
+}
 
-	req, err := http.NewRequest("POST", "https://c-v.sh", body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+// parseFileIOResponse extracts the link from a file.io JSON response
+func parseFileIOResponse(resp *http.Response) (string, error) {
+	var fileIOResp struct {
+		Success bool   `json:"success"`
+		Key     string `json:"key"`
+		Link    string `json:"link"`
+		Expiry  string `json:"expiry"`
 	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if err := json.NewDecoder(resp.Body).Decode(&fileIOResp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %v", err)
+	}
+	if !fileIOResp.Success {
+		return "", fmt.Errorf("upload rejected: %s", resp.Status)
+	}
+	return fileIOResp.Link, nil
+}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// parseKITCResponse extracts the download page from a ki.tc JSON response
+func parseKITCResponse(resp *http.Response) (string, error) {
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+	var response struct {
+		File struct {
+			DownloadPage string `json:"download_page"`
+		} `json:"file"`
 	}
-	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to parse response: %v", err)
+	}
+	if response.File.DownloadPage == "" {
+		return "", fmt.Errorf("missing download page URL in response")
+	}
+	return response.File.DownloadPage, nil
+}
 
+// parseCVSHResponse reads the link c-v.sh returns as the response body
+func parseCVSHResponse(resp *http.Response) (string, error) {
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to upload to c-v.sh: %s", resp.Status)
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
 	}
-
-	// c-v.sh returns URL directly as response body
 	urlBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return "", fmt.Errorf("failed to read response: %v", err)
 	}
-
-	url := strings.TrimSpace(string(urlBytes))
-	if !strings.HasPrefix(url, "http") {
-		return nil, fmt.Errorf("invalid URL response: %s", url)
+	link := strings.TrimSpace(string(urlBytes))
+	if !strings.HasPrefix(link, "http") {
+		return "", fmt.Errorf("invalid URL response: %s", link)
 	}
-
-	return &ShareResponse{
-		Success:  true,
-		Link:     url,
-		Service:  string(CVSH),
-		Filename: filename,
-	}, nil
+	return link, nil
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedShareProviders holds the scripted test providers by name
+var scriptedShareProviders sync.Map
+
+func init() {
+	RegisterShareProvider("scripted", func(name string, config ShareServiceConfig) (ShareProvider, error) {
+		provider, ok := scriptedShareProviders.Load(name)
+		if !ok {
+			return nil, errors.New("no script for " + name)
+		}
+		return provider.(scriptedShareProvider), nil
+	})
+}
+
+// scriptedShareProvider is a share provider running a function given by the test
+type scriptedShareProvider func(ctx context.Context, file io.Reader) (*ShareResponse, error)
+
+func (p scriptedShareProvider) Upload(ctx context.Context, file io.Reader, filename string) (*ShareResponse, error) {
+	return p(ctx, file)
+}
+
+// newScriptedShareService returns a service with scripted providers named after the keys of uploads
+func newScriptedShareService(t *testing.T, outbound OutboundConfig, uploads map[string]scriptedShareProvider) *PDFService {
+	t.Helper()
+	config := defaultConfig()
+	config.Outbound = outbound
+	config.Share = make(map[string]ShareServiceConfig)
+	for name, upload := range uploads {
+		name = t.Name() + "/" + name
+		scriptedShareProviders.Store(name, upload)
+		t.Cleanup(func() { scriptedShareProviders.Delete(name) })
+		config.Share[name] = ShareServiceConfig{Type: "scripted"}
+	}
+	return newTestService(t, config)
+}
+
+// scriptedServices returns the names newScriptedShareService gave providers
+func scriptedServices(t *testing.T, names ...string) []FileShareService {
+	services := make([]FileShareService, len(names))
+	for i, name := range names {
+		services[i] = FileShareService(t.Name() + "/" + name)
+	}
+	return services
+}
+
+// endlessPDF writes until the write fails or ctx is done, like a long render
+func endlessPDF(ctx context.Context, w io.Writer) error {
+	chunk := []byte(strings.Repeat("%PDF", 8<<10))
+	for ctx.Err() == nil {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func TestRenderToShareStreamedUploadFailure(t *testing.T) {
+	// Like net/http, the failing upload closes the streamed body before returning
+	outbound := defaultConfig().Outbound
+	outbound.MaxRetries = 0
+	s := newScriptedShareService(t, outbound, map[string]scriptedShareProvider{
+		"down": func(ctx context.Context, file io.Reader) (*ShareResponse, error) {
+			file.(io.Closer).Close()
+			time.Sleep(20 * time.Millisecond) // The render notices the closed stream meanwhile
+			return nil, errors.New("upload rejected")
+		},
+	})
+
+	w := httptest.NewRecorder()
+	outcome := s.renderToShare(context.Background(), w, scriptedServices(t, "down"), "a.pdf", endlessPDF)
+	if outcome != OutcomeShareError || w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), CodeShareFailed) {
+		t.Errorf("outcome %s status %d body %s, want the share failure", outcome, w.Code, w.Body)
+	}
+}
+
+// uploadLog records the order of scripted uploads
+type uploadLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+// provider returns a scripted provider named name failing the first failures uploads, transiently
+// when transient is set, and succeeding afterwards. Every upload must read the whole PDF
+func (l *uploadLog) provider(t *testing.T, name string, failures int, transient bool) scriptedShareProvider {
+	return func(ctx context.Context, file io.Reader) (*ShareResponse, error) {
+		data, err := io.ReadAll(file)
+		if err != nil || string(data) != "%PDF-1.7 shared" {
+			t.Errorf("%s read %q, %v", name, data, err)
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.calls = append(l.calls, name)
+		if failures > 0 {
+			failures--
+			if transient {
+				markTransient(ctx)
+			}
+			return nil, errors.New(name + " failed")
+		}
+		return &ShareResponse{Success: true, Link: "https://share.example/" + name}, nil
+	}
+}
+
+func sharedPDF(ctx context.Context, w io.Writer) error {
+	_, err := io.WriteString(w, "%PDF-1.7 shared")
+	return err
+}
+
+// uploadFailures is the number of failing uploads of a scripted provider and whether they are transient
+type uploadFailures struct {
+	count     int
+	transient bool
+}
+
+func TestUploadToShareServicesRetriesAndFallbacks(t *testing.T) {
+	outbound := defaultConfig().Outbound
+	outbound.MaxRetries = 2
+	outbound.RetryBackoffMS = 1
+
+	tests := []struct {
+		name      string
+		providers map[string]uploadFailures
+		services  []string
+		calls     string
+		status    int
+		link      string
+	}{
+		{"transient failures are retried", map[string]uploadFailures{"a": {2, true}}, []string{"a"}, "a a a", http.StatusOK, "a"},
+		{"retries are limited", map[string]uploadFailures{"a": {3, true}}, []string{"a"}, "a a a", http.StatusBadGateway, ""},
+		{"other failures are not retried", map[string]uploadFailures{"a": {1, false}}, []string{"a"}, "a", http.StatusBadGateway, ""},
+		{"fallbacks in order", map[string]uploadFailures{"a": {1, false}, "b": {3, true}, "c": {}, "d": {}}, []string{"a", "b", "c", "d"}, "a b b b c", http.StatusOK, "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &uploadLog{}
+			providers := make(map[string]scriptedShareProvider)
+			for name, failures := range tt.providers {
+				providers[name] = log.provider(t, name, failures.count, failures.transient)
+			}
+			s := newScriptedShareService(t, outbound, providers)
+
+			w := httptest.NewRecorder()
+			s.renderToShare(context.Background(), w, scriptedServices(t, tt.services...), "a.pdf", sharedPDF)
+			if calls := strings.Join(log.calls, " "); calls != tt.calls {
+				t.Errorf("uploads %q, want %q", calls, tt.calls)
+			}
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.link != "" && !strings.Contains(w.Body.String(), "https://share.example/"+tt.link) {
+				t.Errorf("response %s, want the link of %s", w.Body, tt.link)
+			}
+		})
+	}
+}
+
+func TestRenderToShareSkipsOpenCircuits(t *testing.T) {
+	outbound := defaultConfig().Outbound
+	outbound.BreakerFailures = 1
+	outbound.BreakerCooldownSeconds = 30
+	log := &uploadLog{}
+	s := newScriptedShareService(t, outbound, map[string]scriptedShareProvider{
+		"a": log.provider(t, "a", 0, false),
+		"b": log.provider(t, "b", 0, false),
+	})
+	a, b := scriptedServices(t, "a")[0], scriptedServices(t, "b")[0]
+	s.breakers.get(outbound, a).record(outbound, true)
+
+	// The open circuit of a moves on to b
+	w := httptest.NewRecorder()
+	s.renderToShare(context.Background(), w, []FileShareService{a, b}, "a.pdf", sharedPDF)
+	if w.Code != http.StatusOK || strings.Join(log.calls, " ") != "b" {
+		t.Errorf("status %d uploads %v, want b only", w.Code, log.calls)
+	}
+
+	// With every circuit open the request fails fast with the shortest cooldown left
+	s.breakers.get(outbound, b).record(outbound, true)
+	s.breakers.get(outbound, a).openedAt = time.Now().Add(-20 * time.Second)
+	rendered := false
+	w = httptest.NewRecorder()
+	outcome := s.renderToShare(context.Background(), w, []FileShareService{a, b}, "a.pdf", func(ctx context.Context, w io.Writer) error {
+		rendered = true
+		return sharedPDF(ctx, w)
+	})
+	if outcome != OutcomeShareError || w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), CodeShareUnavailable) {
+		t.Fatalf("outcome %s status %d body %s, want share_unavailable", outcome, w.Code, w.Body)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "10" {
+		t.Errorf("Retry-After %q, want 10", retryAfter)
+	}
+	if rendered {
+		t.Errorf("PDF rendered although no provider is available")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"text/template"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	RegisterShareProvider("sftp", newSFTPProvider)
+}
+
+// sftpProvider uploads PDFs over SFTP, writing a temporary file renamed into place
+type sftpProvider struct {
+	name         string
+	config       SFTPConfig
+	address      string
+	clientConfig *ssh.ClientConfig
+	pathTemplate *template.Template
+}
+
+// newSFTPProvider creates an SFTP provider, loading the private key and known hosts
+func newSFTPProvider(name string, config ShareServiceConfig) (ShareProvider, error) {
+	c := config.SFTP
+	if c.PathTemplate == "" {
+		c.PathTemplate = defaultSharePathTemplate
+	}
+
+	var errs []error
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || u.Scheme != "sftp" || u.Hostname() == "" {
+		errs = append(errs, fmt.Errorf("endpoint must be an sftp://host[:port] URL"))
+	}
+	if c.Username == "" {
+		errs = append(errs, fmt.Errorf("sftp.username is required"))
+	}
+
+	var auth []ssh.AuthMethod
+	if c.PrivateKeyFile != "" {
+		signer, err := loadSSHSigner(c.PrivateKeyFile, c.PrivateKeyPassphrase)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sftp.private_key_file: %v", err))
+		} else {
+			auth = append(auth, ssh.PublicKeys(signer))
+		}
+	}
+	if c.Password != "" {
+		auth = append(auth, ssh.Password(c.Password))
+	}
+	if c.PrivateKeyFile == "" && c.Password == "" {
+		errs = append(errs, fmt.Errorf("sftp.private_key_file or sftp.password is required"))
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if c.KnownHostsFile == "" {
+		errs = append(errs, fmt.Errorf("sftp.known_hosts_file is required to verify the server"))
+	} else if hostKeyCallback, err = knownhosts.New(c.KnownHostsFile); err != nil {
+		errs = append(errs, fmt.Errorf("sftp.known_hosts_file: %v", err))
+	}
+
+	pathTemplate, err := parseSharePathTemplate(name, c.PathTemplate)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("sftp.path_template: %v", err))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	return &sftpProvider{
+		name:    name,
+		config:  c,
+		address: net.JoinHostPort(u.Hostname(), port),
+		clientConfig: &ssh.ClientConfig{
+			User:            c.Username,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+		},
+		pathTemplate: pathTemplate,
+	}, nil
+}
+
+// loadSSHSigner reads a PEM private key, decrypting it with passphrase when set
+func loadSSHSigner(file, passphrase string) (ssh.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+// Upload writes the PDF to a temporary file next to the templated path and renames it into place,
+// so consumers polling the directory never see partial files
+func (p *sftpProvider) Upload(ctx context.Context, file io.Reader, filename string) (*ShareResponse, error) {
+	remotePath, err := renderSharePath(ctx, p.pathTemplate, filename)
+	if err != nil {
+		return nil, err
+	}
+	remotePath = path.Clean(remotePath)
+
+	client, err := p.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", p.name, err)
+	}
+	defer client.Close()
+
+	// Close the connection on cancellation, SFTP calls do not take a context
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-done:
+		}
+	}()
+
+	finalPath, err := p.put(client, file, remotePath)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to upload to %s: %v", p.name, err)
+	}
+
+	link := url.URL{Scheme: "sftp", Host: p.address, Path: finalPath}
+	return &ShareResponse{Success: true, Link: link.String(), Path: finalPath}, nil
+}
+
+// put uploads file to remotePath through a temporary file and returns the absolute remote path
+func (p *sftpProvider) put(client *sftpClient, file io.Reader, remotePath string) (string, error) {
+	dir := path.Dir(remotePath)
+	if err := client.MkdirAll(dir); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	tmpPath := path.Join(dir, "."+path.Base(remotePath)+"."+hex.EncodeToString(suffix)+".part")
+	tmp, err := client.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %v", tmpPath, err)
+	}
+	_, err = tmp.ReadFrom(file)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		client.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write %s: %v", tmpPath, err)
+	}
+
+	// posix-rename replaces existing files atomically, plain rename fails on them
+	if err := client.PosixRename(tmpPath, remotePath); err != nil {
+		if err := client.Rename(tmpPath, remotePath); err != nil {
+			client.Remove(tmpPath)
+			return "", fmt.Errorf("failed to rename %s to %s: %v", tmpPath, remotePath, err)
+		}
+	}
+
+	finalPath, err := client.RealPath(remotePath)
+	if err != nil {
+		return remotePath, nil
+	}
+	return finalPath, nil
+}
+
+// sftpClient is an SFTP session with its SSH connection
+type sftpClient struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+// Close ends the SFTP session and the SSH connection
+func (c *sftpClient) Close() error {
+	c.Client.Close()
+	return c.conn.Close()
+}
+
+// connect opens an SSH connection, verifying the host key, and starts an SFTP session
+func (p *sftpProvider) connect(ctx context.Context) (*sftpClient, error) {
+	// Connecting and the SSH handshake are bounded by the outbound connect timeout
+	dialer := &net.Dialer{Timeout: connectTimeout()}
+	netConn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		markTransient(ctx)
+		return nil, err
+	}
+	netConn.SetDeadline(time.Now().Add(connectTimeout()))
+	conn, chans, reqs, err := ssh.NewClientConn(netConn, p.address, p.clientConfig)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+
+	sshClient := ssh.NewClient(conn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+	return &sftpClient{Client: client, conn: sshClient}, nil
+}
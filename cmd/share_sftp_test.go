@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startSFTPServer serves SFTP for sftp/secret rooted at dir and returns its address and host key
+func startSFTPServer(t *testing.T, dir string) (string, ssh.PublicKey) {
+	t.Helper()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "sftp" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTPConn(conn, config, dir)
+		}
+	}()
+	return listener.Addr().String(), hostKey.PublicKey()
+}
+
+func serveSFTPConn(conn net.Conn, config *ssh.ServerConfig, dir string) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(dir))
+					if err != nil {
+						channel.Close()
+						return
+					}
+					server.Serve()
+					server.Close()
+					return
+				}
+			}
+		}()
+	}
+}
+
+// writeKnownHosts writes a known_hosts file listing key for address
+func writeKnownHosts(t *testing.T, address string, key ssh.PublicKey) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, key)
+	if err := os.WriteFile(file, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newTestSFTPProvider(t *testing.T, address, knownHostsFile string) *sftpProvider {
+	t.Helper()
+	provider, err := newSFTPProvider("sftp", ShareServiceConfig{
+		Endpoint: "sftp://" + address,
+		SFTP: SFTPConfig{
+			Username:       "sftp",
+			Password:       "secret",
+			KnownHostsFile: knownHostsFile,
+			PathTemplate:   "outbox/{{.Filename}}",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*sftpProvider)
+}
+
+func TestSFTPUploadRenamesIntoPlace(t *testing.T) {
+	dir := t.TempDir()
+	address, hostKey := startSFTPServer(t, dir)
+	provider := newTestSFTPProvider(t, address, writeKnownHosts(t, address, hostKey))
+
+	for _, content := range []string{"%PDF first", "%PDF second"} {
+		response, err := provider.Upload(context.Background(), strings.NewReader(content), "report.pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantPath := filepath.ToSlash(filepath.Join(dir, "outbox", "report.pdf"))
+		if response.Path != wantPath || response.Link != "sftp://"+address+wantPath {
+			t.Errorf("path %q link %q, want %q", response.Path, response.Link, wantPath)
+		}
+		// An existing file is replaced
+		data, err := os.ReadFile(filepath.Join(dir, "outbox", "report.pdf"))
+		if err != nil || string(data) != content {
+			t.Errorf("remote file %q, %v, want %q", data, err, content)
+		}
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "outbox"))
+	if len(entries) != 1 {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("outbox holds %v, temporary files should be renamed", names)
+	}
+}
+
+func TestSFTPUploadRejectsUnknownHostKeys(t *testing.T) {
+	dir := t.TempDir()
+	address, _ := startSFTPServer(t, dir)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	other, _ := ssh.NewSignerFromKey(otherKey)
+
+	tests := []struct {
+		name       string
+		knownHosts string
+		want       string
+	}{
+		{"changed key", writeKnownHosts(t, address, other.PublicKey()), "key mismatch"},
+		{"unknown host", writeKnownHosts(t, "192.0.2.1:22", other.PublicKey()), "key is unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestSFTPProvider(t, address, tt.knownHosts)
+			_, err := provider.Upload(context.Background(), strings.NewReader("%PDF"), "report.pdf")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "outbox")); !os.IsNotExist(err) {
+				t.Errorf("upload reached the server")
+			}
+		})
+	}
+}
+
+func TestNewSFTPProviderRequiresKnownHosts(t *testing.T) {
+	_, err := newSFTPProvider("sftp", ShareServiceConfig{
+		Endpoint: "sftp://127.0.0.1",
+		SFTP:     SFTPConfig{Username: "sftp", Password: "secret"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "known_hosts_file is required") {
+		t.Fatalf("err = %v, want known_hosts_file to be required", err)
+	}
+}
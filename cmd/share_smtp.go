@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SMTP connection security modes
+const (
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "implicit"
+	SMTPTLSNone     = "none" // Plain connections, e.g. to a local SMTP sink
+)
+
+// SMTP limits and defaults
+const (
+	maxEmailRecipients       = 50
+	defaultEmailBodyTemplate = "{{.Filename}} is attached.\n"
+	anyRecipient             = "*" // allowed_recipients entry allowing every recipient
+)
+
+func init() {
+	RegisterShareProvider("smtp", newSMTPProvider)
+}
+
+// EmailMessage addresses the email an smtp share provider delivers the PDF with
+type EmailMessage struct {
+	To      []string `json:"to" doc:"Recipient addresses" schema:"required"`
+	Cc      []string `json:"cc,omitempty" doc:"Carbon copy addresses"`
+	Bcc     []string `json:"bcc,omitempty" doc:"Blind carbon copy addresses"`
+	From    string   `json:"from,omitempty" doc:"Sender address, must be allowed for the tenant, defaults to the provider's sender"`
+	ReplyTo string   `json:"reply_to,omitempty" doc:"Reply-To address"`
+	Subject string   `json:"subject" doc:"Go template of the subject with .Filename, .Tenant, .RenderID, .Date and .Time" schema:"required"`
+	Body    string   `json:"body,omitempty" doc:"Go template of the plain text body with the subject's fields"`
+}
+
+// emailMessageKey is the context key of the request's email message
+type emailMessageKey struct{}
+
+// withEmailMessage stores the email message of a request in the context
+func withEmailMessage(ctx context.Context, msg *EmailMessage) context.Context {
+	if msg == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, emailMessageKey{}, msg)
+}
+
+// emailMessageFromContext returns the email message of the request, nil if it has none
+func emailMessageFromContext(ctx context.Context) *EmailMessage {
+	msg, _ := ctx.Value(emailMessageKey{}).(*EmailMessage)
+	return msg
+}
+
+// parseEmailMessage decodes an email message given as JSON, e.g. a form field
+func parseEmailMessage(data string) (*EmailMessage, error) {
+	var body interface{}
+	if err := json.Unmarshal([]byte(data), &body); err != nil {
+		return nil, withProblemCode(CodeInvalidJSON, errors.New("invalid JSON format email"))
+	}
+	if err := validateJSONRequest(body, "EmailMessage"); err != nil {
+		return nil, err
+	}
+	var msg EmailMessage
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return nil, withProblemCode(CodeInvalidRequest, fmt.Errorf("email: %w", err))
+	}
+	return &msg, nil
+}
+
+// validateEmail checks that email is set exactly when services include smtp share providers and
+// can be sent by each of them for the tenant of ctx with the PDF named filename
+func (s *PDFService) validateEmail(ctx context.Context, services []FileShareService, msg *EmailMessage, filename string) error {
+	mailed := false
+	for _, service := range services {
+		shareProvider, err := s.shareProvider(service)
+		if err != nil {
+			return err
+		}
+		provider, ok := shareProvider.(*smtpProvider)
+		if !ok {
+			continue
+		}
+		if msg == nil {
+			return fmt.Errorf("share_service %s requires email", service)
+		}
+		if _, err := provider.compose(ctx, msg, filename); err != nil {
+			return err
+		}
+		mailed = true
+	}
+	if msg != nil && !mailed {
+		return fmt.Errorf("email requires an smtp share_service")
+	}
+	return nil
+}
+
+// smtpProvider mails PDFs as attachments
+type smtpProvider struct {
+	name      string
+	config    SMTPConfig
+	host      string
+	address   string
+	from      *mail.Address
+	tlsConfig *tls.Config
+	helo      string
+}
+
+// newSMTPProvider creates an SMTP provider, endpoint is smtp://host[:port] or smtps://host[:port]
+func newSMTPProvider(name string, config ShareServiceConfig) (ShareProvider, error) {
+	c := config.SMTP
+
+	var errs []error
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || (u.Scheme != "smtp" && u.Scheme != "smtps") || u.Hostname() == "" {
+		errs = append(errs, fmt.Errorf("endpoint must be an smtp://host[:port] or smtps://host[:port] URL"))
+		u = &url.URL{}
+	}
+	if c.TLS == "" {
+		c.TLS = SMTPTLSStartTLS
+		if u.Scheme == "smtps" || u.Port() == "465" {
+			c.TLS = SMTPTLSImplicit
+		}
+	}
+	switch c.TLS {
+	case SMTPTLSStartTLS, SMTPTLSImplicit, SMTPTLSNone:
+	default:
+		errs = append(errs, fmt.Errorf("smtp.tls must be %s, %s or %s", SMTPTLSStartTLS, SMTPTLSImplicit, SMTPTLSNone))
+	}
+
+	from, err := mail.ParseAddress(c.From)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("smtp.from must be an email address"))
+	}
+	for tenant, senders := range c.Senders {
+		for _, sender := range senders {
+			if !validAddressPattern(sender) {
+				errs = append(errs, fmt.Errorf("smtp.senders.%s: %q is not an address or @domain", tenant, sender))
+			}
+		}
+	}
+	// Recipients are listed explicitly so the service cannot relay mail to arbitrary addresses
+	if len(c.AllowedRecipients) == 0 {
+		errs = append(errs, fmt.Errorf("smtp.allowed_recipients is required, list addresses or @domains, or %q to allow any recipient", anyRecipient))
+	}
+	for _, recipient := range c.AllowedRecipients {
+		if recipient != anyRecipient && !validAddressPattern(recipient) {
+			errs = append(errs, fmt.Errorf("smtp.allowed_recipients: %q is not an address or @domain", recipient))
+		}
+	}
+
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("smtp.ca_file: %v", err))
+		} else {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+				errs = append(errs, fmt.Errorf("smtp.ca_file: no certificates found"))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{SMTPTLSStartTLS: "587", SMTPTLSImplicit: "465", SMTPTLSNone: "25"}[c.TLS]
+	}
+	helo := c.Hello
+	if helo == "" {
+		if helo, err = os.Hostname(); err != nil {
+			helo = "localhost"
+		}
+	}
+	return &smtpProvider{
+		name:      name,
+		config:    c,
+		host:      u.Hostname(),
+		address:   net.JoinHostPort(u.Hostname(), port),
+		from:      from,
+		tlsConfig: tlsConfig,
+		helo:      helo,
+	}, nil
+}
+
+// validAddressPattern reports whether pattern is an email address or an @domain
+func validAddressPattern(pattern string) bool {
+	if domain, ok := strings.CutPrefix(pattern, "@"); ok {
+		return domain != "" && !strings.ContainsAny(domain, "@ ")
+	}
+	address, err := mail.ParseAddress(pattern)
+	return err == nil && address.Address == pattern
+}
+
+// matchesAddress reports whether address equals one of patterns or is in one of their @domains
+func matchesAddress(patterns []string, address string) bool {
+	_, domain, _ := strings.Cut(address, "@")
+	for _, pattern := range patterns {
+		if strings.EqualFold(pattern, address) || strings.EqualFold(pattern, "@"+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// emailEnvelope is a validated email message with its templates rendered
+type emailEnvelope struct {
+	from       *mail.Address
+	replyTo    *mail.Address
+	to         []*mail.Address
+	cc         []*mail.Address
+	recipients []string // SMTP recipients, including blind copies
+	subject    string
+	body       string
+}
+
+// compose validates msg for the tenant of ctx, parsing its addresses and rendering its templates
+// for the PDF named filename
+func (p *smtpProvider) compose(ctx context.Context, msg *EmailMessage, filename string) (*emailEnvelope, error) {
+	var errs []error
+	env := &emailEnvelope{from: p.from}
+	parseList := func(field string, list []string) []*mail.Address {
+		var addresses []*mail.Address
+		for _, value := range list {
+			address, err := mail.ParseAddress(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("email.%s: invalid address %q", field, value))
+				continue
+			}
+			if !slices.Contains(p.config.AllowedRecipients, anyRecipient) && !matchesAddress(p.config.AllowedRecipients, address.Address) {
+				errs = append(errs, fmt.Errorf("email.%s: recipient %s is not allowed", field, address.Address))
+				continue
+			}
+			addresses = append(addresses, address)
+			env.recipients = append(env.recipients, address.Address)
+		}
+		return addresses
+	}
+	env.to = parseList("to", msg.To)
+	env.cc = parseList("cc", msg.Cc)
+	parseList("bcc", msg.Bcc)
+	if len(msg.To) == 0 {
+		errs = append(errs, fmt.Errorf("email.to: at least one recipient is required"))
+	}
+	if count := len(msg.To) + len(msg.Cc) + len(msg.Bcc); count > maxEmailRecipients {
+		errs = append(errs, fmt.Errorf("email: %d recipients exceed the limit of %d", count, maxEmailRecipients))
+	}
+
+	// Tenants send from the provider's address or the senders configured for them, only authenticated
+	// tenants are looked up so X-Tenant-ID cannot pick another tenant's senders
+	if msg.From != "" {
+		tenant := verifiedTenantFromContext(ctx)
+		from, err := mail.ParseAddress(msg.From)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("email.from: invalid address %q", msg.From))
+		case !strings.EqualFold(from.Address, p.from.Address) && (tenant == "" || !matchesAddress(p.config.Senders[tenant], from.Address)):
+			errs = append(errs, fmt.Errorf("email.from: sender %s is not allowed", from.Address))
+		default:
+			env.from = from
+		}
+	}
+	if msg.ReplyTo != "" {
+		var err error
+		if env.replyTo, err = mail.ParseAddress(msg.ReplyTo); err != nil {
+			errs = append(errs, fmt.Errorf("email.reply_to: invalid address %q", msg.ReplyTo))
+		}
+	}
+
+	data := newSharePathData(ctx, filename)
+	var err error
+	if env.subject, err = renderEmailTemplate("subject", msg.Subject, data); err != nil {
+		errs = append(errs, fmt.Errorf("email.subject: %v", err))
+	} else if strings.ContainsAny(env.subject, "\r\n") {
+		errs = append(errs, fmt.Errorf("email.subject: must be a single line"))
+	}
+	body := msg.Body
+	if body == "" {
+		body = defaultEmailBodyTemplate
+	}
+	if env.body, err = renderEmailTemplate("body", body, data); err != nil {
+		errs = append(errs, fmt.Errorf("email.body: %v", err))
+	}
+	return env, errors.Join(errs...)
+}
+
+// renderEmailTemplate parses and executes the email template text
+func renderEmailTemplate(name, text string, data sharePathData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Upload mails the PDF as an attachment named filename to the recipients of the request's email
+func (p *smtpProvider) Upload(ctx context.Context, file io.Reader, filename string) (*ShareResponse, error) {
+	msg := emailMessageFromContext(ctx)
+	if msg == nil {
+		return nil, fmt.Errorf("%s requires email", p.name)
+	}
+	env, err := p.compose(ctx, msg, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	messageID := p.newMessageID(ctx, env.from)
+	err = p.send(ctx, env, func(w io.Writer) error {
+		return writeEmail(w, env, messageID, filename, file)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to send via %s: %v", p.name, err)
+	}
+
+	return &ShareResponse{
+		Success:   true,
+		Link:      "mid:" + url.PathEscape(strings.Trim(messageID, "<>")),
+		MessageID: messageID,
+		Message:   "Email sent",
+	}, nil
+}
+
+// newMessageID returns a unique Message-ID in the domain of the sender
+func (p *smtpProvider) newMessageID(ctx context.Context, from *mail.Address) string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	local := hex.EncodeToString(random)
+	if renderID := renderIDFromContext(ctx); renderID != "" {
+		local = renderID + "." + local
+	}
+	_, domain, _ := strings.Cut(from.Address, "@")
+	return "<" + local + "@" + domain + ">"
+}
+
+// send delivers the message written by write to the envelope's recipients
+func (p *smtpProvider) send(ctx context.Context, env *emailEnvelope, write func(w io.Writer) error) (err error) {
+	defer func() { markSMTPTransient(ctx, err) }()
+
+	dialer := &net.Dialer{Timeout: connectTimeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+	if p.config.TLS == SMTPTLSImplicit {
+		conn = tls.Client(conn, p.tlsConfig)
+	}
+
+	// Close the connection on cancellation, SMTP calls do not take a context
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	client, err := smtp.NewClient(conn, p.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Hello(p.helo); err != nil {
+		return err
+	}
+	if p.config.TLS == SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server does not support STARTTLS")
+		}
+		if err := client.StartTLS(p.tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if p.config.Username != "" {
+		// PLAIN auth refuses unencrypted connections except to localhost
+		if err := client.Auth(smtp.PlainAuth("", p.config.Username, p.config.Password, p.host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(env.from.Address); err != nil {
+		return fmt.Errorf("sender rejected: %w", err)
+	}
+	for _, recipient := range env.recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", recipient, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message rejected: %w", err)
+	}
+	return client.Quit()
+}
+
+// markSMTPTransient marks network errors and 4xx replies, which ask to try again later, as transient failures
+func markSMTPTransient(ctx context.Context, err error) {
+	var reply *textproto.Error
+	var netErr net.Error
+	if (errors.As(err, &reply) && reply.Code/100 == 4) || errors.As(err, &netErr) {
+		markTransient(ctx)
+	}
+}
+
+// writeEmail writes a multipart message with the plain text body and the PDF read from pdf attached
+func writeEmail(w io.Writer, env *emailEnvelope, messageID, filename string, pdf io.Reader) error {
+	mw := multipart.NewWriter(w)
+
+	var header strings.Builder
+	addHeader := func(name, value string) { fmt.Fprintf(&header, "%s: %s\r\n", name, value) }
+	addHeader("From", env.from.String())
+	addHeader("To", formatAddressList(env.to))
+	if len(env.cc) > 0 {
+		addHeader("Cc", formatAddressList(env.cc))
+	}
+	if env.replyTo != nil {
+		addHeader("Reply-To", env.replyTo.String())
+	}
+	addHeader("Subject", mime.QEncoding.Encode("utf-8", env.subject))
+	addHeader("Date", time.Now().Format(time.RFC1123Z))
+	addHeader("Message-ID", messageID)
+	addHeader("MIME-Version", "1.0")
+	addHeader("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	header.WriteString("\r\n")
+	if _, err := io.WriteString(w, header.String()); err != nil {
+		return err
+	}
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := io.WriteString(qp, env.body); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType("application/pdf", map[string]string{"name": filename})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: part, width: 76})
+	if _, err := io.Copy(encoder, pdf); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// formatAddressList formats addresses for an address list header
+func formatAddressList(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = address.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// lineWrapper breaks the written data into CRLF terminated lines of width bytes
+type lineWrapper struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+// Write writes p, inserting line breaks
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(l.width-l.col, len(p))
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.col += n
+		p = p[n:]
+		if l.col == l.width {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+	}
+	return written, nil
+}
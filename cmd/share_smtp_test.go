@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpSink is an in-process SMTP server recording the delivered messages
+type smtpSink struct {
+	mu       sync.Mutex
+	address  string
+	messages []sinkMessage
+	rcptCode int // Reply code of RCPT, 250 unless set
+}
+
+// sinkMessage is a message received by smtpSink
+type sinkMessage struct {
+	from       string
+	recipients []string
+	data       string
+}
+
+func startSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	sink := &smtpSink{address: listener.Addr().String(), rcptCode: 250}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) { fmt.Fprintf(conn, format+"\r\n", args...) }
+	reply("220 sink ESMTP")
+
+	var msg sinkMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250-sink\r\n250 8BITMIME")
+		case "MAIL":
+			msg = sinkMessage{from: angleAddress(arg)}
+			reply("250 OK")
+		case "RCPT":
+			s.mu.Lock()
+			code := s.rcptCode
+			s.mu.Unlock()
+			if code != 250 {
+				reply("%d mailbox unavailable", code)
+				continue
+			}
+			msg.recipients = append(msg.recipients, angleAddress(arg))
+			reply("250 OK")
+		case "DATA":
+			reply("354 send data")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// angleAddress returns the address in angle brackets of a MAIL or RCPT argument
+func angleAddress(arg string) string {
+	_, address, _ := strings.Cut(arg, "<")
+	address, _, _ = strings.Cut(address, ">")
+	return address
+}
+
+func newTestSMTPProvider(t *testing.T, address string, allowed []string) *smtpProvider {
+	t.Helper()
+	provider, err := newSMTPProvider("mail", ShareServiceConfig{
+		Endpoint: "smtp://" + address,
+		SMTP: SMTPConfig{
+			TLS:               SMTPTLSNone,
+			Hello:             "test",
+			From:              "PDF Service <pdf@example.com>",
+			Senders:           map[string][]string{"billing": {"@billing.example.com"}},
+			AllowedRecipients: allowed,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*smtpProvider)
+}
+
+func TestSMTPUploadDeliversAttachment(t *testing.T) {
+	sink := startSMTPSink(t)
+	provider := newTestSMTPProvider(t, sink.address, []string{"@example.com"})
+	pdf := "%PDF-1.7\n" + strings.Repeat("content ", 100)
+
+	ctx := withEmailMessage(withTenant(context.Background(), "billing", true), &EmailMessage{
+		To:      []string{"Alice <alice@example.com>"},
+		Cc:      []string{"carol@example.com"},
+		Bcc:     []string{"audit@example.com"},
+		From:    "invoices@billing.example.com",
+		Subject: "Invoice {{.Filename}}",
+	})
+	response, err := provider.Upload(ctx, strings.NewReader(pdf), "invoice.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Success || response.MessageID == "" {
+		t.Errorf("response %+v", response)
+	}
+
+	if len(sink.messages) != 1 {
+		t.Fatalf("sink received %d messages", len(sink.messages))
+	}
+	received := sink.messages[0]
+	if received.from != "invoices@billing.example.com" {
+		t.Errorf("MAIL FROM %s", received.from)
+	}
+	if got := strings.Join(received.recipients, ","); got != "alice@example.com,carol@example.com,audit@example.com" {
+		t.Errorf("RCPT TO %s", got)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(received.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("Subject") != "Invoice invoice.pdf" || msg.Header.Get("Message-Id") != response.MessageID {
+		t.Errorf("headers %v", msg.Header)
+	}
+	if strings.Contains(received.data, "audit@example.com") {
+		t.Errorf("blind copy recipient appears in the message")
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type %s: %v", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	if _, err := mr.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	attachment, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "invoice.pdf" {
+		t.Errorf("attachment named %q", attachment.FileName())
+	}
+	data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, attachment))
+	if err != nil || string(data) != pdf {
+		t.Errorf("attachment differs from the PDF")
+	}
+}
+
+func TestSMTPComposeChecksRecipientsAndSenders(t *testing.T) {
+	provider := newTestSMTPProvider(t, "127.0.0.1:25", []string{"@example.com", "bob@partner.test"})
+	tests := []struct {
+		name     string
+		tenant   string
+		verified bool
+		msg      EmailMessage
+		wantErr  string
+	}{
+		{"allowed domain", "", false, EmailMessage{To: []string{"alice@example.com", "bob@partner.test"}}, ""},
+		{"recipient not allowed", "", false, EmailMessage{To: []string{"alice@example.com"}, Bcc: []string{"eve@evil.test"}}, "email.bcc: recipient eve@evil.test is not allowed"},
+		{"provider sender", "", false, EmailMessage{To: []string{"alice@example.com"}, From: "pdf@example.com"}, ""},
+		{"authenticated tenant sender", "billing", true, EmailMessage{To: []string{"alice@example.com"}, From: "a@billing.example.com"}, ""},
+		{"claimed tenant sender", "billing", false, EmailMessage{To: []string{"alice@example.com"}, From: "a@billing.example.com"}, "sender a@billing.example.com is not allowed"},
+		{"other tenant sender", "support", true, EmailMessage{To: []string{"alice@example.com"}, From: "a@billing.example.com"}, "sender a@billing.example.com is not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := withTenant(context.Background(), tt.tenant, tt.verified)
+			_, err := provider.compose(ctx, &tt.msg, "a.pdf")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	anyone := newTestSMTPProvider(t, "127.0.0.1:25", []string{anyRecipient})
+	if _, err := anyone.compose(context.Background(), &EmailMessage{To: []string{"someone@anywhere.test"}}, "a.pdf"); err != nil {
+		t.Errorf("%q rejected a recipient: %v", anyRecipient, err)
+	}
+}
+
+func TestNewSMTPProviderRequiresAllowedRecipients(t *testing.T) {
+	_, err := newSMTPProvider("mail", ShareServiceConfig{
+		Endpoint: "smtp://127.0.0.1",
+		SMTP:     SMTPConfig{From: "pdf@example.com"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "smtp.allowed_recipients is required") {
+		t.Fatalf("err = %v, want allowed_recipients to be required", err)
+	}
+}
+
+func TestSMTPUploadReportsTransientRejection(t *testing.T) {
+	sink := startSMTPSink(t)
+	sink.rcptCode = 451
+	provider := newTestSMTPProvider(t, sink.address, []string{anyRecipient})
+
+	ctx, attempt := withUploadAttempt(context.Background())
+	ctx = withEmailMessage(ctx, &EmailMessage{To: []string{"alice@example.com"}, Subject: "PDF"})
+	if _, err := provider.Upload(ctx, strings.NewReader("%PDF"), "a.pdf"); err == nil || !strings.Contains(err.Error(), "451") {
+		t.Fatalf("err = %v, want the 451 rejection", err)
+	}
+	if !attempt.transient.Load() {
+		t.Errorf("451 was not marked transient")
+	}
+}
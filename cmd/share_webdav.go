@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// WebDAV authentication schemes
+const (
+	WebDAVAuthBasic  = "basic"
+	WebDAVAuthDigest = "digest"
+)
+
+func init() {
+	RegisterShareProvider("webdav", newWebDAVProvider)
+}
+
+// webDAVProvider uploads PDFs with PUT, creating missing collections with MKCOL
+type webDAVProvider struct {
+	name         string
+	config       WebDAVConfig
+	endpoint     *url.URL
+	pathTemplate *template.Template
+}
+
+// newWebDAVProvider creates a WebDAV provider
+func newWebDAVProvider(name string, config ShareServiceConfig) (ShareProvider, error) {
+	c := config.WebDAV
+	if c.PathTemplate == "" {
+		c.PathTemplate = defaultSharePathTemplate
+	}
+
+	var errs []error
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("endpoint must be the http(s) URL of a WebDAV collection"))
+	}
+	pathTemplate, err := parseSharePathTemplate(name, c.PathTemplate)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("webdav.path_template: %v", err))
+	}
+	switch c.Auth {
+	case "", WebDAVAuthBasic, WebDAVAuthDigest:
+	default:
+		errs = append(errs, fmt.Errorf("webdav.auth must be %s or %s", WebDAVAuthBasic, WebDAVAuthDigest))
+	}
+	if c.Auth != "" && c.Username == "" {
+		errs = append(errs, fmt.Errorf("webdav.username is required with webdav.auth"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return &webDAVProvider{name: name, config: c, endpoint: u, pathTemplate: pathTemplate}, nil
+}
+
+// Upload puts the PDF at the templated path, creating missing parent collections
+func (p *webDAVProvider) Upload(ctx context.Context, file io.Reader, filename string) (*ShareResponse, error) {
+	remotePath, err := renderSharePath(ctx, p.pathTemplate, filename)
+	if err != nil {
+		return nil, err
+	}
+	segments := strings.FieldsFunc(remotePath, func(r rune) bool { return r == '/' })
+
+	// The body is replayed after authentication challenges and collection creation
+	body, size, err := replayableBody(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %v", err)
+	}
+
+	session := &webDAVSession{provider: p}
+	target := p.resourceURL(segments)
+	header := http.Header{"Content-Type": {shareContentType(filename)}}
+	resp, err := session.do(ctx, http.MethodPut, target, header, body, size)
+	if err == nil && resp.StatusCode == http.StatusConflict {
+		// 409 means a parent collection is missing
+		resp.Body.Close()
+		if err := session.makeCollections(ctx, segments[:len(segments)-1]); err != nil {
+			return nil, fmt.Errorf("failed to upload to %s: %v", p.name, err)
+		}
+		resp, err = session.do(ctx, http.MethodPut, target, header, body, size)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to %s: %v", p.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to upload to %s: unexpected status: %s", p.name, resp.Status)
+	}
+
+	return &ShareResponse{Success: true, Link: target.String(), Path: target.Path}, nil
+}
+
+// rereadsUploads reports that uploads are replayed, see webDAVSession.do
+func (p *webDAVProvider) rereadsUploads() bool { return true }
+
+// resourceURL returns the URL of the resource at segments below the endpoint
+func (p *webDAVProvider) resourceURL(segments []string) *url.URL {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	return p.endpoint.JoinPath(escaped...)
+}
+
+// webDAVSession sends the requests of one upload, remembering how the server authenticates
+type webDAVSession struct {
+	provider *webDAVProvider
+	basic    bool
+	digest   *digestChallenge
+}
+
+// makeCollections creates the collections at segments from the top, existing ones are kept
+func (s *webDAVSession) makeCollections(ctx context.Context, segments []string) error {
+	for i := range segments {
+		collection := s.provider.resourceURL(segments[:i+1])
+		collection.Path += "/"
+		resp, err := s.do(ctx, "MKCOL", collection, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// 405 reports an existing collection
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("failed to create collection %s: %s", collection.Path, resp.Status)
+		}
+	}
+	return nil
+}
+
+// do sends an authenticated request with the size bytes read from body, answering one
+// authentication challenge
+func (s *webDAVSession) do(ctx context.Context, method string, target *url.URL, header http.Header, body func() io.Reader, size int64) (*http.Response, error) {
+	c := s.provider.config
+	for attempt := 0; ; attempt++ {
+		reqBody := io.Reader(http.NoBody)
+		if body != nil {
+			reqBody = body()
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+		req.ContentLength = size
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		switch {
+		case s.digest != nil:
+			req.Header.Set("Authorization", s.digest.authorization(method, req.URL.RequestURI(), c.Username, c.Password))
+		case s.basic || c.Auth == WebDAVAuthBasic:
+			req.SetBasicAuth(c.Username, c.Password)
+		}
+
+		resp, err := shareHTTPClient().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute request: %v", err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 || c.Username == "" || c.Auth == WebDAVAuthBasic {
+			return resp, nil
+		}
+
+		// Answer the challenge, digest is preferred unless basic auth is configured
+		challenges := resp.Header.Values("WWW-Authenticate")
+		resp.Body.Close()
+		if s.digest, err = parseDigestChallenge(challenges); err != nil {
+			if c.Auth == WebDAVAuthDigest || !offersBasicAuth(challenges) {
+				return nil, err
+			}
+			s.basic = true
+		}
+	}
+}
+
+// offersBasicAuth reports whether the challenges include basic authentication
+func offersBasicAuth(challenges []string) bool {
+	for _, challenge := range challenges {
+		scheme, _, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+		if strings.EqualFold(scheme, "Basic") {
+			return true
+		}
+	}
+	return false
+}
+
+// digestChallenge is a parsed HTTP digest challenge (RFC 7616)
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	count     int // Nonce count of requests sent with this nonce
+}
+
+// parseDigestChallenge returns the first digest challenge with a supported algorithm
+func parseDigestChallenge(headers []string) (*digestChallenge, error) {
+	for _, header := range headers {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		values := parseAuthParams(params)
+		challenge := &digestChallenge{
+			realm:     values["realm"],
+			nonce:     values["nonce"],
+			opaque:    values["opaque"],
+			algorithm: values["algorithm"],
+		}
+		if challenge.algorithm == "" {
+			challenge.algorithm = "MD5"
+		}
+		if challenge.newHash() == nil || challenge.nonce == "" {
+			continue
+		}
+		for _, qop := range strings.Split(values["qop"], ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				challenge.qop = "auth"
+			}
+		}
+		if values["qop"] != "" && challenge.qop == "" {
+			continue // Only auth-int offered
+		}
+		return challenge, nil
+	}
+	return nil, fmt.Errorf("server did not offer a supported digest challenge")
+}
+
+// newHash returns the hash of the challenge's algorithm, nil if unsupported
+func (c *digestChallenge) newHash() hash.Hash {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(c.algorithm), "-sess")) {
+	case "MD5":
+		return md5.New()
+	case "SHA-256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// authorization returns the Authorization header answering the challenge for a request
+func (c *digestChallenge) authorization(method, uri, username, password string) string {
+	h := func(s string) string {
+		hash := c.newHash()
+		hash.Write([]byte(s))
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+
+	c.count++
+	nc := fmt.Sprintf("%08x", c.count)
+	cnonceBytes := make([]byte, 12)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+
+	ha1 := h(username + ":" + c.realm + ":" + password)
+	if strings.HasSuffix(strings.ToLower(c.algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	response := h(ha1 + ":" + c.nonce + ":" + ha2)
+	if c.qop != "" {
+		response = h(strings.Join([]string{ha1, c.nonce, nc, cnonce, c.qop, ha2}, ":"))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, response=%q`,
+		username, c.realm, c.nonce, uri, c.algorithm, response)
+	if c.opaque != "" {
+		fmt.Fprintf(&b, `, opaque=%q`, c.opaque)
+	}
+	if c.qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce=%q`, c.qop, nc, cnonce)
+	}
+	return b.String()
+}
+
+// parseAuthParams parses comma separated key=value authentication parameters, values may be quoted
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[key] = value.String()
+	}
+	return params
+}
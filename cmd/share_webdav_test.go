@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeWebDAV is an in-memory WebDAV server answering 409 for resources below missing collections
+type fakeWebDAV struct {
+	t         *testing.T
+	mu        sync.Mutex
+	auth      string // basic, or the digest algorithm
+	nonce     string
+	files     map[string][]byte
+	dirs      map[string]bool
+	requests  []string
+	challenge int
+}
+
+func newFakeWebDAV(t *testing.T, auth string, dirs ...string) *fakeWebDAV {
+	f := &fakeWebDAV{t: t, auth: auth, nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093", files: map[string][]byte{}, dirs: map[string]bool{"/": true}}
+	for _, dir := range dirs {
+		f.dirs[dir] = true
+	}
+	return f
+}
+
+func (f *fakeWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	if !f.authorized(r) {
+		f.challenge++
+		if f.auth == "basic" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dav"`)
+		} else {
+			w.Header().Add("WWW-Authenticate", `Digest realm="dav", nonce="unsupported", algorithm=SHA-512-256`)
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="dav", qop="auth,auth-int", nonce=%q, opaque="5ccc069c403ebaf9f0171e9517f40e41", algorithm=%s`, f.nonce, f.auth))
+			w.Header().Add("WWW-Authenticate", `Basic realm="dav"`)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimSuffix(r.URL.Path, "/")
+	if !f.dirs[path.Dir(name)] {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		f.files[name] = body
+		w.WriteHeader(http.StatusCreated)
+	case "MKCOL":
+		if f.dirs[name] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		f.dirs[name] = true
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized verifies the credentials dav/secret independently of the provider's digest code
+func (f *fakeWebDAV) authorized(r *http.Request) bool {
+	if f.auth == "basic" {
+		username, password, ok := r.BasicAuth()
+		return ok && username == "dav" && password == "secret"
+	}
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != "Digest" {
+		return false
+	}
+	values := parseAuthParams(params)
+	newHash := md5.New
+	if f.auth == "SHA-256" {
+		newHash = sha256.New
+	}
+	h := func(parts ...string) string {
+		var hash hash.Hash = newHash()
+		hash.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+	if values["uri"] != r.URL.RequestURI() || values["nonce"] != f.nonce || values["qop"] != "auth" ||
+		values["opaque"] != "5ccc069c403ebaf9f0171e9517f40e41" || values["algorithm"] != f.auth {
+		f.t.Errorf("%s %s: unexpected digest parameters %v", r.Method, r.URL, values)
+		return false
+	}
+	ha1 := h("dav", "dav", "secret")
+	ha2 := h(r.Method, values["uri"])
+	return values["response"] == h(ha1, f.nonce, values["nc"], values["cnonce"], "auth", ha2)
+}
+
+func newTestWebDAVProvider(t *testing.T, endpoint, auth string) *webDAVProvider {
+	t.Helper()
+	provider, err := newWebDAVProvider("dav", ShareServiceConfig{
+		Endpoint: endpoint,
+		WebDAV: WebDAVConfig{
+			Username:     "dav",
+			Password:     "secret",
+			Auth:         auth,
+			PathTemplate: "reports/2024/{{.Filename}}",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.(*webDAVProvider)
+}
+
+func TestWebDAVUploadCreatesMissingCollections(t *testing.T) {
+	dav := newFakeWebDAV(t, "MD5", "/dav", "/dav/reports")
+	server := httptest.NewServer(dav)
+	defer server.Close()
+	provider := newTestWebDAVProvider(t, server.URL+"/dav/", "")
+
+	response, err := provider.Upload(context.Background(), strings.NewReader("%PDF-1.7"), "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Path != "/dav/reports/2024/report.pdf" || response.Link != server.URL+"/dav/reports/2024/report.pdf" {
+		t.Errorf("path %q link %q", response.Path, response.Link)
+	}
+	if got := string(dav.files["/dav/reports/2024/report.pdf"]); got != "%PDF-1.7" {
+		t.Errorf("stored %q", got)
+	}
+
+	want := []string{
+		"PUT /dav/reports/2024/report.pdf", // Challenged
+		"PUT /dav/reports/2024/report.pdf", // 409
+		"MKCOL /dav/reports/",              // 405, exists
+		"MKCOL /dav/reports/2024/",
+		"PUT /dav/reports/2024/report.pdf",
+	}
+	if strings.Join(dav.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests\n%s\nwant\n%s", strings.Join(dav.requests, "\n"), strings.Join(want, "\n"))
+	}
+	if dav.challenge != 1 {
+		t.Errorf("challenged %d times, the digest should be reused", dav.challenge)
+	}
+}
+
+func TestWebDAVUploadAuthentication(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverAuth string
+		auth       string
+		challenges int
+	}{
+		{"digest MD5", "MD5", "", 1},
+		{"digest SHA-256", "SHA-256", WebDAVAuthDigest, 1},
+		{"basic after challenge", "basic", "", 1},
+		{"preemptive basic", "basic", WebDAVAuthBasic, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dav := newFakeWebDAV(t, tt.serverAuth, "/reports", "/reports/2024")
+			server := httptest.NewServer(dav)
+			defer server.Close()
+			provider := newTestWebDAVProvider(t, server.URL, tt.auth)
+
+			if _, err := provider.Upload(context.Background(), strings.NewReader("%PDF"), "report.pdf"); err != nil {
+				t.Fatal(err)
+			}
+			if dav.challenge != tt.challenges {
+				t.Errorf("challenged %d times, want %d", dav.challenge, tt.challenges)
+			}
+		})
+	}
+}
+
+func TestWebDAVUploadWrongPassword(t *testing.T) {
+	dav := newFakeWebDAV(t, "MD5", "/reports", "/reports/2024")
+	server := httptest.NewServer(dav)
+	defer server.Close()
+	provider := newTestWebDAVProvider(t, server.URL, "")
+	provider.config.Password = "wrong"
+
+	_, err := provider.Upload(context.Background(), strings.NewReader("%PDF"), "report.pdf")
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("err = %v, want 401", err)
+	}
+	if len(dav.requests) != 2 {
+		t.Errorf("sent %d requests, want one retry after the challenge", len(dav.requests))
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   []string
+		algorithm string
+		qop       string
+		wantErr   bool
+	}{
+		{"default MD5", []string{`Digest realm="r", nonce="n"`}, "MD5", "", false},
+		{"skips unsupported", []string{`Digest realm="r", nonce="a", algorithm=SHA-512-256`, `Digest realm="r", nonce="b", algorithm=SHA-256, qop="auth"`}, "SHA-256", "auth", false},
+		{"session variant", []string{`Digest realm="r", nonce="n", algorithm=MD5-sess, qop="auth-int, auth"`}, "MD5-sess", "auth", false},
+		{"auth-int only", []string{`Digest realm="r", nonce="n", qop="auth-int"`}, "", "", true},
+		{"basic only", []string{`Basic realm="r"`}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, err := parseDigestChallenge(tt.headers)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got challenge %+v, want error", challenge)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if challenge.algorithm != tt.algorithm || challenge.qop != tt.qop {
+				t.Errorf("algorithm %q qop %q, want %q %q", challenge.algorithm, challenge.qop, tt.algorithm, tt.qop)
+			}
+		})
+	}
+}
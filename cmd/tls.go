@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client certificate verification modes
+const (
+	ClientAuthNone    = "none"    // Client certificates are not requested
+	ClientAuthRequest = "request" // Verified when presented
+	ClientAuthRequire = "require" // Required and verified
+)
+
+// tlsVersions maps configurable minimum TLS versions to their protocol values
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Enabled reports whether the server serves HTTPS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// validate checks the TLS settings for errors
+func (c TLSConfig) validate() []error {
+	var errs []error
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("server.tls.cert_file and server.tls.key_file must be set together"))
+	}
+	if _, err := parseTLSVersion(c.MinVersion); err != nil {
+		errs = append(errs, fmt.Errorf("server.tls.min_version: %v", err))
+	}
+	if _, err := parseCipherSuites(c.CipherSuites); err != nil {
+		errs = append(errs, fmt.Errorf("server.tls.cipher_suites: %v", err))
+	}
+	switch c.ClientAuth {
+	case "", ClientAuthNone, ClientAuthRequest, ClientAuthRequire:
+	default:
+		errs = append(errs, fmt.Errorf("server.tls.client_auth must be %s, %s or %s", ClientAuthNone, ClientAuthRequest, ClientAuthRequire))
+	}
+	if c.ClientCAFile != "" && !c.Enabled() {
+		errs = append(errs, fmt.Errorf("server.tls.client_ca_file requires server.tls.cert_file"))
+	}
+	if c.ClientCAFile == "" && (c.ClientAuth == ClientAuthRequest || c.ClientAuth == ClientAuthRequire) {
+		errs = append(errs, fmt.Errorf("server.tls.client_auth %s requires server.tls.client_ca_file", c.ClientAuth))
+	}
+	return errs
+}
+
+// newTLSConfig builds the server TLS configuration, the certificate is reloaded when its files change
+func newTLSConfig(c TLSConfig, logger *slog.Logger) (*tls.Config, error) {
+	certs, err := newCertReloader(c.CertFile, c.KeyFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	minVersion, err := parseTLSVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+
+		switch c.ClientAuth {
+		case ClientAuthNone:
+			config.ClientAuth = tls.NoClientCert
+		case ClientAuthRequest:
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return config, nil
+}
+
+// parseTLSVersion parses a minimum TLS version, empty defaults to 1.2
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	if v, ok := tlsVersions[strings.TrimPrefix(version, "TLS")]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q", version)
+}
+
+// parseCipherSuites resolves cipher suite names, empty uses Go's defaults.
+// Only secure suites are accepted, TLS 1.3 suites are not configurable
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// certReloader serves a certificate and reloads it when the certificate or key file changes
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the certificate, failing if it is invalid
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := cr.load(cr.latestModTime()); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate returns the current certificate, reloading it if the files changed.
+// A failed reload keeps serving the previous certificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if modTime := cr.latestModTime(); modTime.After(cr.modTime) {
+		if err := cr.load(modTime); err != nil {
+			cr.logger.Error("TLS certificate reload failed, keeping current certificate", "error", err)
+			cr.modTime = modTime // Retried on the next change
+		} else {
+			cr.logger.Info("TLS certificate reloaded", "cert_file", cr.certFile)
+		}
+	}
+	return cr.cert, nil
+}
+
+// load reads the certificate and key pair
+func (cr *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the most recent modification time of the certificate and key files
+func (cr *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{cr.certFile, cr.keyFile} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingSpanNames(t *testing.T) {
+	// The global provider stays installed, the service tracer delegates to the first one set
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	config := defaultConfig()
+	config.Render.WeasyPrintBin = fakeWeasyPrint(t, `printf '%%PDF-1.7'`)
+	s := newTestService(t, config)
+	router := setupRouter(s)
+	router.Post("/api/v1/pdf/render/html", s.HandleHTMLRender)
+	router.Get(localShareRoute, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		method, path string
+		spans        []string // Names of the ended spans, children first
+		route        string   // http.route of the server span
+		urlPath      string   // url.path of the server span
+	}{
+		{http.MethodPost, "/api/v1/pdf/render/html", []string{"executeWeasyPrint", "POST /api/v1/pdf/render/html"}, "/api/v1/pdf/render/html", "/api/v1/pdf/render/html"},
+		{http.MethodGet, "/s/secret-token", []string{"GET /s/{token}"}, localShareRoute, localShareRoute},
+		{http.MethodGet, "/unknown/secret-token", []string{"GET"}, "", ""},
+	}
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, tt := range tests {
+		start := len(recorder.Ended())
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"html": "<h1>Hi</h1>"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		spans := recorder.Ended()[start:]
+		var names []string
+		for _, span := range spans {
+			names = append(names, span.Name())
+			if span.SpanContext().TraceID().String() != traceID {
+				t.Errorf("%s %s: span %s did not continue the incoming trace", tt.method, tt.path, span.Name())
+			}
+		}
+		if strings.Join(names, ", ") != strings.Join(tt.spans, ", ") {
+			t.Errorf("%s %s: spans %q, want %q", tt.method, tt.path, names, tt.spans)
+			continue
+		}
+
+		attributes := make(map[string]string)
+		for _, attribute := range spans[len(spans)-1].Attributes() {
+			attributes[string(attribute.Key)] = attribute.Value.Emit()
+			if strings.Contains(attribute.Value.Emit(), "secret-token") {
+				t.Errorf("%s %s: attribute %s holds the path token", tt.method, tt.path, attribute.Key)
+			}
+		}
+		if attributes["http.route"] != tt.route || attributes["url.path"] != tt.urlPath {
+			t.Errorf("%s %s: http.route %q url.path %q, want %q %q", tt.method, tt.path, attributes["http.route"], attributes["url.path"], tt.route, tt.urlPath)
+		}
+	}
+}
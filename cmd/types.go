@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 const (
 	NoShare FileShareService = ""        // No sharing, return PDF directly
 	FileIO  FileShareService = "file.io" // https://file.io
@@ -7,65 +9,94 @@ const (
 	CVSH    FileShareService = "c-v.sh"  // https://c-v.sh
 )
 
+// pdfVariants lists the supported PDF variants
+var pdfVariants = []string{
+	"pdf/a-1b", "pdf/a-2b", "pdf/a-3b", "pdf/a-4b",
+	"pdf/a-2u", "pdf/a-3u", "pdf/a-4u",
+	"pdf/ua-1", "debug",
+}
+
 // HTMLRequest represents JSON request structure
 type HTMLRequest struct {
-	HTML         string                 `json:"html"`
-	Options      map[string]interface{} `json:"options,omitempty"`
-	ShareService string                 `json:"share_service,omitempty"`
+	HTML           string                 `json:"html" doc:"HTML document or http(s) URL to render" schema:"required"`
+	Options        map[string]interface{} `json:"options,omitempty" doc:"WeasyPrint options" schema:"ref=WeasyPrintOptions,lenient"`
+	ShareService   string                 `json:"share_service,omitempty" doc:"Upload the PDF to a share provider listed by /api/v1/share/providers and return a link instead"`
+	ShareFallbacks []string               `json:"share_fallbacks,omitempty" doc:"Share providers tried in order when share_service fails or is unavailable"`
+	Output         *OutputTarget          `json:"output,omitempty" doc:"Upload the PDF to a client-supplied URL and return its size, hash and page count instead"`
+	Email          *EmailMessage          `json:"email,omitempty" doc:"Recipients and templates of the email sent by smtp share providers"`
+	ShareOptions   *ShareOptions          `json:"share_options,omitempty" doc:"Expiry, download limit, password and QR code of the share link, validated per provider"`
+	EncryptShare   bool                   `json:"encrypt_share,omitempty" doc:"Encrypt the PDF with AES-256-GCM before uploading it, the link opens a decrypt page with the key in its fragment"`
+	Outputs        []OutputDestination    `json:"outputs,omitempty" doc:"Destinations the single rendered PDF is fanned out to, replacing output and share_service, with a summary of their results"`
+	StrictOptions  bool                   `json:"strict_options,omitempty" doc:"Reject the request with 422 instead of ignoring invalid options"`
 }
 
 // WeasyPrintOptions represents weasyprint supported options
 type WeasyPrintOptions struct {
 	// Basic options
-	Encoding  string `json:"encoding"`
-	MediaType string `json:"media_type"`
-	BaseURL   string `json:"base_url"`
+	Encoding  string `json:"encoding" doc:"Character encoding of the input"`
+	MediaType string `json:"media_type" doc:"CSS media type, defaults to print"`
+	BaseURL   string `json:"base_url" doc:"Base URL for relative URLs, remote hosts must be allowed"`
 
 	// PDF related options
-	PDFIdentifier string `json:"pdf_identifier"`
-	PDFVariant    string `json:"pdf_variant"`
-	PDFVersion    string `json:"pdf_version"`
-	PDFForms      bool   `json:"pdf_forms"`
+	PDFIdentifier string `json:"pdf_identifier" doc:"PDF file identifier"`
+	PDFVariant    string `json:"pdf_variant" doc:"PDF variant to generate" schema:"enum=pdfVariants"`
+	PDFVersion    string `json:"pdf_version" doc:"PDF version number"`
+	PDFForms      bool   `json:"pdf_forms" doc:"Include PDF forms"`
 
 	// Output options
-	UncompressedPDF     bool `json:"uncompressed_pdf"`
-	CustomMetadata      bool `json:"custom_metadata"`
-	PresentationalHints bool `json:"presentational_hints"`
-	SRGB                bool `json:"srgb"`
-	OptimizeImages      bool `json:"optimize_images"`
-	FullFonts           bool `json:"full_fonts"`
-	Hinting             bool `json:"hinting"`
+	UncompressedPDF     bool `json:"uncompressed_pdf" doc:"Do not compress PDF content"`
+	CustomMetadata      bool `json:"custom_metadata" doc:"Include custom HTML meta tags in PDF metadata"`
+	PresentationalHints bool `json:"presentational_hints" doc:"Follow HTML presentational hints"`
+	SRGB                bool `json:"srgb" doc:"Include sRGB color profile"`
+	OptimizeImages      bool `json:"optimize_images" doc:"Optimize size of embedded images"`
+	FullFonts           bool `json:"full_fonts" doc:"Embed unmodified font files"`
+	Hinting             bool `json:"hinting" doc:"Keep hinting information in embedded fonts"`
 
 	// Quality and performance options
-	JPEGQuality int    `json:"jpeg_quality"`
-	DPI         int    `json:"dpi"`
-	CacheFolder string `json:"cache_folder"`
-	Timeout     int    `json:"timeout"`
+	JPEGQuality int    `json:"jpeg_quality" doc:"JPEG quality of embedded images" schema:"minimum=0,maximum=95"`
+	DPI         int    `json:"dpi" doc:"Maximum resolution of embedded images" schema:"minimum=50,maximum=600"`
+	CacheFolder string `json:"cache_folder" schema:"-"` // Not accepted from requests
+	Timeout     int    `json:"timeout" doc:"Timeout for remote resources in seconds" schema:"minimum=1,maximum=300"`
 
 	// Log level
-	Verbose bool `json:"verbose"`
-	Debug   bool `json:"debug"`
-	Quiet   bool `json:"quiet"`
+	Verbose bool `json:"verbose" doc:"Show warnings and information messages"`
+	Debug   bool `json:"debug" doc:"Show debugging messages"`
+	Quiet   bool `json:"quiet" doc:"Hide logging messages"`
 }
 
 // UploadedFileInfo stores uploaded file information
 type UploadedFileInfo struct {
-	HTMLPath     string
-	CSSPaths     []string
-	Attachments  []string
-	Options      *WeasyPrintOptions
-	Filename     string           // Add filename field
-	ShareService FileShareService // Sharing service
+	HTMLPath      string
+	CSSPaths      []string
+	Attachments   []string
+	Options       *WeasyPrintOptions
+	Ignored       []string            // Options rejected by validateOptions
+	StrictOptions bool                // Ignored options fail the request
+	Filename      string              // Add filename field
+	ShareService  FileShareService    // Sharing service
+	ShareTo       []FileShareService  // ShareService followed by its fallbacks
+	Output        *OutputTarget       // Client-supplied upload destination
+	Email         *EmailMessage       // Email sent by smtp share providers
+	ShareOptions  *ShareOptions       // Link settings for the share providers
+	ShareKey      []byte              // Key the share is encrypted with, nil when it is not
+	Outputs       []OutputDestination // Destinations the PDF is fanned out to
 }
 
-// FileShareService defines supported third-party file sharing services
+// FileShareService is the name of a configured share provider
 type FileShareService string
 
 // ShareResponse represents the response from file sharing service
 type ShareResponse struct {
-	Link     string `json:"link"`
-	Service  string `json:"service"`
-	Success  bool   `json:"success"`
-	Message  string `json:"message,omitempty"`
-	Filename string `json:"filename,omitempty"`
+	Link       string           `json:"link" doc:"Download link of the PDF" schema:"required"`
+	Service    string           `json:"service" doc:"Sharing service used" schema:"required"`
+	Success    bool             `json:"success" schema:"required"`
+	Message    string           `json:"message,omitempty"`
+	Filename   string           `json:"filename,omitempty"`
+	Key        string           `json:"key,omitempty" doc:"Object key for storage providers"`
+	Path       string           `json:"path,omitempty" doc:"Remote path for WebDAV and SFTP destinations"`
+	Expires    *time.Time       `json:"expires_at,omitempty" doc:"When the link expires"`
+	MessageID  string           `json:"message_id,omitempty" doc:"Message-ID of the email sent by smtp providers, the link is its mid: URL"`
+	Options    *ShareOptions    `json:"share_options,omitempty" doc:"Share options the link was created with, without the password"`
+	QRCode     string           `json:"qr_code,omitempty" doc:"QR code of the link as a PNG or SVG data URI"`
+	Encryption *ShareEncryption `json:"encryption,omitempty" doc:"Key and ciphertext link of encrypted shares, link then opens the decrypt page"`
 }
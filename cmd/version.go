@@ -24,10 +24,10 @@ var (
 	initErr           error
 )
 
-func versionHandler(w http.ResponseWriter, r *http.Request) {
+func (s *PDFService) versionHandler(w http.ResponseWriter, r *http.Request) {
 	// need to get weasyprint version dynamically
 	once.Do(func() {
-		cmd := exec.Command("weasyprint", "--version")
+		cmd := exec.Command(s.cfg().Render.WeasyPrintBin, "--version")
 		output, err := cmd.Output()
 		if err != nil {
 			initErr = err
@@ -36,7 +36,7 @@ func versionHandler(w http.ResponseWriter, r *http.Request) {
 		weasyprintVersion = strings.TrimSpace(string(output))
 	})
 	if initErr != nil {
-		http.Error(w, "failed to get weasyprint version: "+initErr.Error(), http.StatusInternalServerError)
+		writeProblem(r.Context(), w, http.StatusInternalServerError, CodeInternalError, "failed to get weasyprint version: "+initErr.Error())
 		return
 	}
 	info := map[string]string{
@@ -1,16 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"math"
+	"net/http"
 	"net/url"
-	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// weasyPrintWaitDelay bounds the wait for WeasyPrint output after its context is cancelled
+const weasyPrintWaitDelay = 5 * time.Second
+
+// ignoredOptionsHeader lists the options a lenient render ignored
+const ignoredOptionsHeader = "X-Ignored-Options"
+
 // buildWeasyPrintArgs builds weasyprint command arguments
 func (s *PDFService) buildWeasyPrintArgs(options *WeasyPrintOptions) []string {
 	var args []string
@@ -112,17 +125,49 @@ func (s *PDFService) buildWeasyPrintArgs(options *WeasyPrintOptions) []string {
 }
 
 // executeWeasyPrint executes weasyprint command
-func (s *PDFService) executeWeasyPrint(ctx context.Context, w io.Writer, args []string) error {
-	s.logger.Printf("Executing weasyprint command: %v", args)
+func (s *PDFService) executeWeasyPrint(ctx context.Context, w io.Writer, args []string) (err error) {
+	ctx, span := tracer.Start(ctx, "executeWeasyPrint",
+		trace.WithAttributes(attribute.StringSlice("weasyprint.args", redactArgs(args))))
+	defer func() { endSpan(span, err) }()
+
+	release, err := s.acquireRenderSlot(ctx)
+	if err != nil {
+		return &problemError{code: renderFailureCode(ctx, ""), err: fmt.Errorf("waiting for render slot: %v", err)}
+	}
+	defer release()
+
+	s.logger.InfoContext(ctx, "Executing weasyprint command", "args", redactArgs(args))
+
+	output := &countingWriter{w: w}
+	cmd := exec.CommandContext(ctx, s.cfg().Render.WeasyPrintBin, args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = output
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = weasyPrintWaitDelay // Don't hang on output pipes held open after cancellation
+
+	start := time.Now()
+	err = cmd.Run()
+	s.metrics.observePhase(PhaseWeasyPrint, time.Since(start).Seconds())
 
-	cmd := exec.CommandContext(ctx, "weasyprint", args...)
-	cmd.Stdout = w
-	cmd.Stderr = os.Stderr
+	if stderr.Len() > 0 {
+		s.logger.WarnContext(ctx, "WeasyPrint diagnostics", "stderr", strings.TrimSpace(stderr.String()))
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("weasyprint execution failed: %v", err)
+	// ProcessState is nil if the process never started
+	if cmd.ProcessState != nil {
+		s.metrics.observeExitCode(cmd.ProcessState.ExitCode())
+		span.SetAttributes(attribute.Int("weasyprint.exit_code", cmd.ProcessState.ExitCode()))
+	}
+	if err != nil {
+		return &problemError{
+			code:        renderFailureCode(ctx, stderr.String()),
+			err:         fmt.Errorf("weasyprint execution failed: %v", err),
+			diagnostics: weasyPrintDiagnostics(stderr.String()),
+		}
 	}
 
+	s.metrics.outputSize.Observe(float64(output.n))
+	span.SetAttributes(attribute.Int64("pdf.size_bytes", output.n))
 	return nil
 }
 
@@ -149,33 +194,27 @@ func (s *PDFService) generatePDFFromFiles(ctx context.Context, w io.Writer, file
 
 // generatePDFFromHTML generates PDF from HTML string
 func (s *PDFService) generatePDFFromHTML(ctx context.Context, w io.Writer, htmlContent string, options *WeasyPrintOptions) error {
-	// Check if htmlContent is a URL
-	isURL := false
-	if strings.HasPrefix(htmlContent, "http://") || strings.HasPrefix(htmlContent, "https://") {
-		// Try to parse URL to ensure format is correct
-		_, err := url.Parse(htmlContent)
-		if err == nil {
-			isURL = true
-		}
-	}
-
 	// Build command arguments
 	args := s.buildWeasyPrintArgs(options)
 
-	if isURL {
+	if isRemoteURL(htmlContent) {
+		if err := checkAllowedURL(s.cfg().Security.AllowedHosts, htmlContent); err != nil {
+			return withProblemCode(CodeURLNotAllowed, err)
+		}
 		// If it's a URL, add directly to arguments
-		s.logger.Printf("Detected URL: %s", htmlContent)
+		s.logger.InfoContext(ctx, "Detected URL", "url", redactURL(htmlContent))
 		args = append(args, htmlContent, "-")
 	} else {
 		// Not a URL, create temporary HTML file
-		tempFile, err := os.CreateTemp("", "*.html")
+		start := time.Now()
+		tempFile, err := s.createTempFile("pdfgen-*.html")
 		if err != nil {
 			return fmt.Errorf("failed to create temporary file: %v", err)
 		}
 
 		defer func() {
 			tempFile.Close()
-			os.Remove(tempFile.Name())
+			s.removeTemp(tempFile.Name())
 		}()
 
 		// Write HTML content
@@ -186,6 +225,7 @@ func (s *PDFService) generatePDFFromHTML(ctx context.Context, w io.Writer, htmlC
 		if err := tempFile.Close(); err != nil {
 			return fmt.Errorf("failed to close temporary file: %v", err)
 		}
+		s.metrics.observePhase(PhaseTempWrite, time.Since(start).Seconds())
 
 		args = append(args, tempFile.Name(), "-")
 	}
@@ -193,6 +233,16 @@ func (s *PDFService) generatePDFFromHTML(ctx context.Context, w io.Writer, htmlC
 	return s.executeWeasyPrint(ctx, w, args)
 }
 
+// isRemoteURL checks if content is an HTTP(S) URL rather than HTML
+func isRemoteURL(content string) bool {
+	if !strings.HasPrefix(content, "http://") && !strings.HasPrefix(content, "https://") {
+		return false
+	}
+	// Try to parse URL to ensure format is correct
+	_, err := url.Parse(content)
+	return err == nil
+}
+
 // getDefaultOptions returns default weasyprint options
 func getDefaultOptions() *WeasyPrintOptions {
 	return &WeasyPrintOptions{
@@ -200,154 +250,170 @@ func getDefaultOptions() *WeasyPrintOptions {
 	}
 }
 
-// validateOptions validates and cleans options
-func (s *PDFService) validateOptions(options map[string]interface{}) *WeasyPrintOptions {
+// validateOptions validates and cleans options, configured default options apply to keys not in options.
+// It returns the options applied and the request options it ignored as "key: reason", sorted by key
+func (s *PDFService) validateOptions(ctx context.Context, options map[string]interface{}) (*WeasyPrintOptions, []string) {
 	result := getDefaultOptions()
+	requested := options
 
-	// Define safe options list (exclude unsafe or unsupported options)
-	safeOptions := map[string]bool{
-		"encoding": true, "media_type": true, "base_url": true,
-		"pdf_identifier": true, "pdf_variant": true, "pdf_version": true, "pdf_forms": true,
-		"uncompressed_pdf": true, "custom_metadata": true, "presentational_hints": true,
-		"srgb": true, "optimize_images": true, "full_fonts": true, "hinting": true,
-		"jpeg_quality": true, "dpi": true, "timeout": true,
-		"verbose": true, "debug": true, "quiet": true,
+	if defaults := s.cfg().Render.DefaultOptions; len(defaults) > 0 {
+		merged := make(map[string]interface{}, len(defaults)+len(options))
+		for key, value := range defaults {
+			merged[key] = value
+		}
+		for key, value := range options {
+			merged[key] = value
+		}
+		options = merged
 	}
 
+	var ignored []string
 	for key, value := range options {
-		if !safeOptions[key] {
-			s.logger.Printf("Ignoring unsafe option: %s", key)
-			continue
-		}
-
+		var reason string
 		switch key {
 		case "encoding":
-			if str, ok := value.(string); ok {
-				result.Encoding = str
-			}
+			reason = stringOption(&result.Encoding, value)
 		case "media_type":
-			if str, ok := value.(string); ok {
-				result.MediaType = str
-			}
+			reason = stringOption(&result.MediaType, value)
 		case "base_url":
-			if str, ok := value.(string); ok {
-				result.BaseURL = str
+			var baseURL string
+			if reason = stringOption(&baseURL, value); reason != "" {
+				break
 			}
-		case "pdf_identifier":
-			if str, ok := value.(string); ok {
-				result.PDFIdentifier = str
+			if err := checkAllowedURL(s.cfg().Security.AllowedHosts, baseURL); isRemoteURL(baseURL) && err != nil {
+				reason = err.Error()
+				break
 			}
+			result.BaseURL = baseURL
+		case "pdf_identifier":
+			reason = stringOption(&result.PDFIdentifier, value)
 		case "pdf_variant":
-			if str, ok := value.(string); ok && s.isValidPDFVariant(str) {
-				result.PDFVariant = str
+			var variant string
+			if reason = stringOption(&variant, value); reason == "" && !slices.Contains(pdfVariants, variant) {
+				reason = "must be one of " + strings.Join(pdfVariants, ", ")
+				break
 			}
+			result.PDFVariant = variant
 		case "pdf_version":
-			if str, ok := value.(string); ok {
-				result.PDFVersion = str
-			}
+			reason = stringOption(&result.PDFVersion, value)
 		case "pdf_forms":
-			if b, ok := value.(bool); ok {
-				result.PDFForms = b
-			}
+			reason = boolOption(&result.PDFForms, value)
 		case "uncompressed_pdf":
-			if b, ok := value.(bool); ok {
-				result.UncompressedPDF = b
-			}
+			reason = boolOption(&result.UncompressedPDF, value)
 		case "custom_metadata":
-			if b, ok := value.(bool); ok {
-				result.CustomMetadata = b
-			}
+			reason = boolOption(&result.CustomMetadata, value)
 		case "presentational_hints":
-			if b, ok := value.(bool); ok {
-				result.PresentationalHints = b
-			}
+			reason = boolOption(&result.PresentationalHints, value)
 		case "srgb":
-			if b, ok := value.(bool); ok {
-				result.SRGB = b
-			}
+			reason = boolOption(&result.SRGB, value)
 		case "optimize_images":
-			if b, ok := value.(bool); ok {
-				result.OptimizeImages = b
-			}
+			reason = boolOption(&result.OptimizeImages, value)
 		case "full_fonts":
-			if b, ok := value.(bool); ok {
-				result.FullFonts = b
-			}
+			reason = boolOption(&result.FullFonts, value)
 		case "hinting":
-			if b, ok := value.(bool); ok {
-				result.Hinting = b
-			}
+			reason = boolOption(&result.Hinting, value)
 		case "jpeg_quality":
-			if quality := s.parseIntValue(value, 0, 95); quality > 0 {
-				result.JPEGQuality = quality
-			}
+			reason = intOption(&result.JPEGQuality, value, 0, 95)
 		case "dpi":
-			if dpi := s.parseIntValue(value, 50, 600); dpi > 0 {
-				result.DPI = dpi
-			}
+			reason = intOption(&result.DPI, value, 50, 600)
 		case "timeout":
-			if timeout := s.parseIntValue(value, 1, 300); timeout > 0 {
-				result.Timeout = timeout
-			}
+			reason = intOption(&result.Timeout, value, 1, 300)
 		case "verbose":
-			if b, ok := value.(bool); ok {
-				result.Verbose = b
-			}
+			reason = boolOption(&result.Verbose, value)
 		case "debug":
-			if b, ok := value.(bool); ok {
-				result.Debug = b
-			}
+			reason = boolOption(&result.Debug, value)
 		case "quiet":
-			if b, ok := value.(bool); ok {
-				result.Quiet = b
-			}
+			reason = boolOption(&result.Quiet, value)
+		default:
+			// Unsafe or unsupported options such as cache_folder
+			reason = "unsupported option"
+		}
+		if reason == "" {
+			continue
+		}
+		if _, ok := requested[key]; !ok {
+			s.logger.WarnContext(ctx, "Ignoring default option", "option", key, "reason", reason)
+			continue
 		}
+		ignored = append(ignored, key+": "+reason)
 	}
+	slices.Sort(ignored)
 
-	return result
+	return result, ignored
 }
 
-// parseIntValue parses integer value and validates range
-func (s *PDFService) parseIntValue(value interface{}, min, max int) int {
-	var intVal int
+// stringOption sets dst to the string value, it returns why value was rejected
+func stringOption(dst *string, value interface{}) string {
+	str, ok := value.(string)
+	if !ok {
+		return "must be a string"
+	}
+	*dst = str
+	return ""
+}
+
+// boolOption sets dst to the boolean value, it returns why value was rejected
+func boolOption(dst *bool, value interface{}) string {
+	b, ok := value.(bool)
+	if !ok {
+		return "must be a boolean"
+	}
+	*dst = b
+	return ""
+}
 
+// intOption sets dst to the integer value, numeric strings included, if it is within min and max.
+// It returns why value was rejected
+func intOption(dst *int, value interface{}, min, max int) string {
+	var intVal int
 	switch v := value.(type) {
 	case int:
 		intVal = v
 	case float64:
+		if v != math.Trunc(v) {
+			return "must be an integer"
+		}
 		intVal = int(v)
 	case string:
-		if parsed, err := strconv.Atoi(v); err == nil {
-			intVal = parsed
-		} else {
-			return 0
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return "must be an integer"
 		}
+		intVal = parsed
 	default:
-		return 0
+		return "must be an integer"
 	}
 
 	if intVal < min || intVal > max {
-		s.logger.Printf("Integer value %d out of range [%d, %d]", intVal, min, max)
-		return 0
+		return fmt.Sprintf("must be between %d and %d", min, max)
 	}
-
-	return intVal
+	*dst = intVal
+	return ""
 }
 
-// isValidPDFVariant validates if PDF variant is valid
-func (s *PDFService) isValidPDFVariant(variant string) bool {
-	validVariants := []string{
-		"pdf/a-1b", "pdf/a-2b", "pdf/a-3b", "pdf/a-4b",
-		"pdf/a-2u", "pdf/a-3u", "pdf/a-4u",
-		"pdf/ua-1", "debug",
-	}
+// strictOptions reports whether ignored options fail the request, set globally or by the request
+func (s *PDFService) strictOptions(r *http.Request, requested bool) bool {
+	return s.cfg().Render.StrictOptions || requested || r.URL.Query().Get("strict_options") == "true"
+}
 
-	for _, valid := range validVariants {
-		if variant == valid {
-			return true
+// checkIgnoredOptions rejects ignored options in strict mode, otherwise lists their keys in the
+// X-Ignored-Options header
+func (s *PDFService) checkIgnoredOptions(ctx context.Context, w http.ResponseWriter, ignored []string, strict bool) error {
+	if len(ignored) == 0 {
+		return nil
+	}
+	if strict {
+		return &problemError{
+			code:   CodeInvalidOption,
+			err:    fmt.Errorf("invalid options: %s", strings.Join(ignored, "; ")),
+			errors: ignored,
 		}
 	}
-
-	s.logger.Printf("Invalid PDF variant: %s", variant)
-	return false
+	keys := make([]string, len(ignored))
+	for i, option := range ignored {
+		keys[i], _, _ = strings.Cut(option, ":")
+	}
+	w.Header().Set(ignoredOptionsHeader, strings.Join(keys, ", "))
+	s.logger.WarnContext(ctx, "Ignoring options", "options", ignored)
+	return nil
 }